@@ -0,0 +1,402 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package core
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/import-yuefeng/smartDNS/core/cache"
+	"github.com/import-yuefeng/smartDNS/core/common"
+	"github.com/import-yuefeng/smartDNS/core/config"
+	"github.com/import-yuefeng/smartDNS/core/dnstap"
+	"github.com/import-yuefeng/smartDNS/core/metrics"
+	"github.com/import-yuefeng/smartDNS/core/outbound"
+	"github.com/import-yuefeng/smartDNS/core/rule"
+)
+
+// overrides are changes made by admin API, they are applied again to dispatchers created by reload
+type overrides struct {
+	defaultDNSBundle    string
+	hasDefaultDNSBundle bool
+	// disabled upstreams of each bundle
+	disabled map[string]map[string]bool
+	// runtime domains of each filter, see common.Filter.SetDomain
+	domains map[string]map[string]bool
+}
+
+// dispatcherOf func create dispatcher by config with changes made by admin API
+// Changes referring to bundles, upstreams or filters no longer in config are dropped.
+func (s *Server) dispatcherOf(conf *config.Config, tap *dnstap.Tap) *outbound.Dispatcher {
+	d := newDispatcher(conf, s.cacheTimer, s.smart, tap)
+	o := &s.overrides
+
+	if o.hasDefaultDNSBundle {
+		if _, ok := conf.DNSBunch[o.defaultDNSBundle]; ok || o.defaultDNSBundle == "" {
+			d.DefaultDNSBundle = o.defaultDNSBundle
+		} else {
+			log.Warnf("DefaultDNSBundle %s set by admin API no longer exists, use %s of config", o.defaultDNSBundle, conf.DefaultDNSBundle)
+			o.defaultDNSBundle, o.hasDefaultDNSBundle = "", false
+		}
+	}
+
+	if len(o.disabled) != 0 {
+		// upstream lists of config are kept as they are, they are compared by reload
+		bunch := make(map[string][]*common.DNSUpstream, len(conf.DNSBunch))
+		for name, ul := range conf.DNSBunch {
+			bunch[name] = ul
+			if len(o.disabled[name]) == 0 {
+				continue
+			}
+			var enabled []*common.DNSUpstream
+			for _, u := range ul {
+				if !o.disabled[name][u.Name] {
+					enabled = append(enabled, u)
+				}
+			}
+			if len(enabled) == 0 {
+				log.Warnf("All upstreams of DNSBunch %s are disabled by admin API, enable them all", name)
+				delete(o.disabled, name)
+				continue
+			}
+			bunch[name] = enabled
+		}
+		d.DNSBunch = bunch
+	}
+
+	for name, domains := range o.domains {
+		f, ok := conf.DNSFilter[name]
+		if !ok {
+			log.Warnf("DNSFilter %s changed by admin API no longer exists", name)
+			delete(o.domains, name)
+			continue
+		}
+		for domain, matched := range domains {
+			f.SetDomain(domain, matched)
+		}
+	}
+	return d
+}
+
+// adminHandler func return handler of admin API, requests must carry AdminToken of current config as bearer token
+func (s *Server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/cache", s.adminCache)
+	mux.HandleFunc("/admin/domains", s.adminDomains)
+	mux.HandleFunc("/admin/upstreams", s.adminUpstreams)
+	mux.HandleFunc("/admin/default", s.adminDefault)
+	mux.HandleFunc("/admin/fastmap", s.adminFastMap)
+	mux.HandleFunc("/admin/reload", s.adminReload)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.Lock()
+		token := ""
+		if s.conf != nil {
+			token = s.conf.AdminToken
+		}
+		s.Unlock()
+		if token == "" {
+			http.NotFound(w, req)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			log.Warnf("Unauthorized admin API request from %s: %s %s", req.RemoteAddr, req.Method, req.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="smartDNS"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		mux.ServeHTTP(w, req)
+	})
+}
+
+// adminCache func flush the whole cache, or one question if name is given (DELETE /admin/cache?name=&type=)
+func (s *Server) adminCache(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodDelete) {
+		return
+	}
+	s.Lock()
+	c := s.conf.Cache
+	s.Unlock()
+	if c == nil {
+		writeError(w, http.StatusConflict, "cache not enabled")
+		return
+	}
+
+	name := req.URL.Query().Get("name")
+	if name == "" {
+		n := c.Flush()
+		log.Infof("Admin API: cache flushed, %d entries removed", n)
+		writeJSON(w, map[string]int{"removed": n})
+		return
+	}
+	q, err := NewQuery(name, req.URL.Query().Get("type"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	removed := 0
+	if c.RemoveByKey(cache.Key(q.Question[0])) {
+		removed = 1
+		log.Infof("Admin API: %s %s removed from cache", q.Question[0].Name, metrics.QType(q.Question[0].Qtype))
+	}
+	writeJSON(w, map[string]int{"removed": removed})
+}
+
+// adminDomains func list, add (POST), remove (DELETE) or reset (DELETE with reset=true) domains of a filter at runtime
+// A domain covers its subdomains, and runtime domains take precedence over the domain list of the filter.
+func (s *Server) adminDomains(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodGet, http.MethodPost, http.MethodDelete) {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	query := req.URL.Query()
+	name, domain := query.Get("filter"), query.Get("domain")
+	if req.Method == http.MethodGet && name == "" {
+		rs := make(map[string]map[string]bool)
+		for name, f := range s.conf.DNSFilter {
+			rs[name] = f.RuntimeDomains()
+		}
+		writeJSON(w, rs)
+		return
+	}
+	f, ok := s.conf.DNSFilter[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "DNSFilter "+name+" does not exist")
+		return
+	}
+	if req.Method == http.MethodGet {
+		writeJSON(w, f.RuntimeDomains())
+		return
+	}
+	// same form as domain lists, so IDN entries match A-label query names
+	domain, err := rule.NormalizeDomain(domain)
+	if _, ok := dns.IsDomainName(domain); err != nil || !ok {
+		writeError(w, http.StatusBadRequest, "invalid domain")
+		return
+	}
+
+	if s.overrides.domains == nil {
+		s.overrides.domains = make(map[string]map[string]bool)
+	}
+	if s.overrides.domains[name] == nil {
+		s.overrides.domains[name] = make(map[string]bool)
+	}
+	switch {
+	case req.Method == http.MethodPost:
+		f.SetDomain(domain, true)
+		s.overrides.domains[name][domain] = true
+		log.Infof("Admin API: %s added to DNSFilter %s", domain, name)
+	case query.Get("reset") == "true":
+		f.ResetDomain(domain)
+		delete(s.overrides.domains[name], domain)
+		log.Infof("Admin API: %s of DNSFilter %s is reset to the domain list", domain, name)
+	default:
+		f.SetDomain(domain, false)
+		s.overrides.domains[name][domain] = false
+		log.Infof("Admin API: %s removed from DNSFilter %s", domain, name)
+	}
+	io.WriteString(w, "ok")
+}
+
+// adminUpstreams func list upstreams, or enable and disable one (POST /admin/upstreams?bundle=&upstream=&enabled=)
+func (s *Server) adminUpstreams(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodGet, http.MethodPost) {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.inbound == nil {
+		writeError(w, http.StatusServiceUnavailable, "server is not running")
+		return
+	}
+
+	if req.Method == http.MethodGet {
+		type upstream struct {
+			Name     string `json:"name"`
+			Address  string `json:"address"`
+			Protocol string `json:"protocol"`
+			Enabled  bool   `json:"enabled"`
+		}
+		rs := make(map[string][]upstream)
+		for name, ul := range s.conf.DNSBunch {
+			rs[name] = []upstream{}
+			for _, u := range ul {
+				rs[name] = append(rs[name], upstream{u.Name, u.Address, u.Protocol, !s.overrides.disabled[name][u.Name]})
+			}
+		}
+		writeJSON(w, rs)
+		return
+	}
+
+	query := req.URL.Query()
+	name, upstreamName := query.Get("bundle"), query.Get("upstream")
+	enabled, err := strconv.ParseBool(query.Get("enabled"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "enabled must be true or false")
+		return
+	}
+	ul, ok := s.conf.DNSBunch[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "DNSBunch "+name+" does not exist")
+		return
+	}
+	active, exists := 0, false
+	for _, u := range ul {
+		if u.Name == upstreamName {
+			exists = true
+		}
+		if !s.overrides.disabled[name][u.Name] && u.Name != upstreamName {
+			active++
+		}
+	}
+	if !exists {
+		writeError(w, http.StatusNotFound, "upstream "+upstreamName+" of DNSBunch "+name+" does not exist")
+		return
+	}
+	if !enabled && active == 0 {
+		writeError(w, http.StatusConflict, "upstream "+upstreamName+" is the last enabled one of DNSBunch "+name)
+		return
+	}
+
+	if s.overrides.disabled == nil {
+		s.overrides.disabled = make(map[string]map[string]bool)
+	}
+	if s.overrides.disabled[name] == nil {
+		s.overrides.disabled[name] = make(map[string]bool)
+	}
+	if enabled {
+		delete(s.overrides.disabled[name], upstreamName)
+	} else {
+		s.overrides.disabled[name][upstreamName] = true
+	}
+	s.inbound.SetDispatcher(s.dispatcherOf(s.conf, s.tap), s.conf.RejectQType)
+	log.Infof("Admin API: upstream %s of DNSBunch %s is enabled: %t", upstreamName, name, enabled)
+	io.WriteString(w, "ok")
+}
+
+// adminDefault func show or change DefaultDNSBundle (PUT /admin/default?bundle=), empty bundle disables it
+func (s *Server) adminDefault(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodGet, http.MethodPut) {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.inbound == nil {
+		writeError(w, http.StatusServiceUnavailable, "server is not running")
+		return
+	}
+
+	if req.Method == http.MethodGet {
+		writeJSON(w, map[string]string{"bundle": s.inbound.Dispatcher().DefaultDNSBundle})
+		return
+	}
+	name := req.URL.Query().Get("bundle")
+	if _, ok := s.conf.DNSBunch[name]; name != "" && !ok {
+		writeError(w, http.StatusNotFound, "DNSBunch "+name+" does not exist")
+		return
+	}
+	s.overrides.defaultDNSBundle, s.overrides.hasDefaultDNSBundle = name, true
+	s.inbound.SetDispatcher(s.dispatcherOf(s.conf, s.tap), s.conf.RejectQType)
+	log.Infof("Admin API: DefaultDNSBundle is changed to %q", name)
+	io.WriteString(w, "ok")
+}
+
+// adminFastMap func list bundles learned for cached questions, or change one (PUT /admin/fastmap?name=&type=&bundle=)
+func (s *Server) adminFastMap(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodGet, http.MethodPut) {
+		return
+	}
+	s.Lock()
+	c, bunch := s.conf.Cache, s.conf.DNSBunch
+	s.Unlock()
+	if c == nil {
+		writeError(w, http.StatusConflict, "cache not enabled")
+		return
+	}
+
+	if req.Method == http.MethodGet {
+		type entry struct {
+			Name   string `json:"name"`
+			Type   string `json:"type"`
+			Bundle string `json:"bundle"`
+			Domain string `json:"domain"`
+		}
+		rs := []entry{}
+		for q, m := range c.FastMaps() {
+			rs = append(rs, entry{q.Name, metrics.QType(q.Qtype), m.DnsBundle, m.Domain})
+		}
+		sort.Slice(rs, func(i, j int) bool {
+			if rs[i].Name != rs[j].Name {
+				return rs[i].Name < rs[j].Name
+			}
+			return rs[i].Type < rs[j].Type
+		})
+		writeJSON(w, rs)
+		return
+	}
+
+	q, err := NewQuery(req.URL.Query().Get("name"), req.URL.Query().Get("type"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := req.URL.Query().Get("bundle")
+	if _, ok := bunch[name]; !ok {
+		writeError(w, http.StatusNotFound, "DNSBunch "+name+" does not exist")
+		return
+	}
+	key := cache.Key(q.Question[0])
+	old := c.GetFastTable(key)
+	if old == nil || !c.Update(key, &cache.FastMap{DnsBundle: name, Domain: old.Domain}) {
+		writeError(w, http.StatusNotFound, q.Question[0].Name+" is not in cache")
+		return
+	}
+	log.Infof("Admin API: %s %s is bound to DNSBunch %s", q.Question[0].Name, metrics.QType(q.Question[0].Qtype), name)
+	io.WriteString(w, "ok")
+}
+
+// adminReload func reload config (POST /admin/reload), changes made by admin API are kept
+func (s *Server) adminReload(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodPost) {
+		return
+	}
+	if err := s.Reload(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	io.WriteString(w, "ok")
+}
+
+func allowMethod(w http.ResponseWriter, req *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if req.Method == m {
+			return true
+		}
+	}
+	writeError(w, http.StatusMethodNotAllowed, "method must be one of "+strings.Join(methods, ", "))
+	return false
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.WriteHeader(code)
+	io.WriteString(w, "error: "+msg)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,138 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/import-yuefeng/smartDNS/core/cache"
+	"github.com/import-yuefeng/smartDNS/core/common"
+	"github.com/import-yuefeng/smartDNS/core/config"
+	"github.com/import-yuefeng/smartDNS/core/inbound"
+)
+
+func newAdminTestServer() *Server {
+	conf := &config.Config{
+		AdminToken:       "secret",
+		DefaultDNSBundle: "A",
+		Cache:            cache.New(10),
+		DNSBunch: map[string][]*common.DNSUpstream{
+			"A": {{Name: "a1"}, {Name: "a2"}},
+			"B": {{Name: "b1"}},
+		},
+		DNSFilter: map[string]*common.Filter{"A": {}, "B": {}},
+	}
+	s := &Server{conf: conf}
+	s.inbound = inbound.NewServer(nil, "", s.dispatcherOf(conf, nil), nil)
+	return s
+}
+
+func adminRequest(h http.Handler, method string, url string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminAuth(t *testing.T) {
+	s := newAdminTestServer()
+	h := s.adminHandler()
+	if w := adminRequest(h, "GET", "/admin/upstreams", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("request without token: %d", w.Code)
+	}
+	if w := adminRequest(h, "GET", "/admin/upstreams", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("request with wrong token: %d", w.Code)
+	}
+	if w := adminRequest(h, "GET", "/admin/upstreams", "secret"); w.Code != http.StatusOK {
+		t.Errorf("request with token: %d %s", w.Code, w.Body)
+	}
+
+	s.conf.AdminToken = ""
+	if w := adminRequest(h, "GET", "/admin/upstreams", "secret"); w.Code != http.StatusNotFound {
+		t.Errorf("admin API without AdminToken: %d", w.Code)
+	}
+}
+
+func TestAdminOverrides(t *testing.T) {
+	s := newAdminTestServer()
+	h := s.adminHandler()
+
+	for _, c := range []struct {
+		method, url string
+		code        int
+	}{
+		{"POST", "/admin/upstreams?bundle=A&upstream=a1&enabled=false", http.StatusOK},
+		{"POST", "/admin/upstreams?bundle=A&upstream=a2&enabled=false", http.StatusConflict},
+		{"POST", "/admin/upstreams?bundle=A&upstream=x&enabled=false", http.StatusNotFound},
+		{"PUT", "/admin/default?bundle=B", http.StatusOK},
+		{"PUT", "/admin/default?bundle=C", http.StatusNotFound},
+		{"POST", "/admin/domains?filter=B&domain=example.com", http.StatusOK},
+		{"POST", "/admin/domains?filter=C&domain=example.com", http.StatusNotFound},
+		{"POST", "/admin/domains?filter=B&domain=B%C3%BCcher.Example.", http.StatusOK},
+		{"POST", "/admin/domains?filter=B&domain=a..example", http.StatusBadRequest},
+		{"DELETE", "/admin/default", http.StatusMethodNotAllowed},
+	} {
+		if w := adminRequest(h, c.method, c.url, "secret"); w.Code != c.code {
+			t.Errorf("%s %s: got %d %s, want %d", c.method, c.url, w.Code, w.Body, c.code)
+		}
+	}
+
+	check := func(when string) {
+		d := s.inbound.Dispatcher()
+		if d.DefaultDNSBundle != "B" {
+			t.Errorf("%s: DefaultDNSBundle is %s", when, d.DefaultDNSBundle)
+		}
+		if len(d.DNSBunch["A"]) != 1 || d.DNSBunch["A"][0].Name != "a2" || len(s.conf.DNSBunch["A"]) != 2 {
+			t.Errorf("%s: upstream a1 is not disabled only in dispatcher", when)
+		}
+		if matched, ok := d.DNSFilter["B"].MatchRuntimeDomain("www.example.com"); !matched || !ok {
+			t.Errorf("%s: runtime domain is lost", when)
+		}
+		if matched, ok := d.DNSFilter["B"].MatchRuntimeDomain("www.xn--bcher-kva.example."); !matched || !ok {
+			t.Errorf("%s: IDN runtime domain does not match A-label name", when)
+		}
+	}
+	check("before reload")
+
+	// reload creates new filters from config, changes are applied to them again
+	conf := *s.conf
+	conf.DNSFilter = map[string]*common.Filter{"A": {}, "B": {}}
+	s.inbound.SetDispatcher(s.dispatcherOf(&conf, nil), nil)
+	s.conf = &conf
+	check("after reload")
+}
+
+func TestAdminCache(t *testing.T) {
+	s := newAdminTestServer()
+	h := s.adminHandler()
+	for _, name := range []string{"a.example.", "b.example."} {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		s.conf.Cache.Insert(cache.Key(m.Question[0]), m, 60, "A", "")
+	}
+
+	if w := adminRequest(h, "PUT", "/admin/fastmap?name=a.example&bundle=B", "secret"); w.Code != http.StatusOK {
+		t.Errorf("change fastmap: %d %s", w.Code, w.Body)
+	}
+	if w := adminRequest(h, "GET", "/admin/fastmap", "secret"); !strings.Contains(w.Body.String(), `"name":"a.example.","type":"A","bundle":"B"`) {
+		t.Errorf("fastmap is not changed: %s", w.Body)
+	}
+	if w := adminRequest(h, "DELETE", "/admin/cache?name=a.example", "secret"); w.Body.String() != "{\"removed\":1}\n" {
+		t.Errorf("remove key: %s", w.Body)
+	}
+	if w := adminRequest(h, "DELETE", "/admin/cache", "secret"); w.Body.String() != "{\"removed\":1}\n" || s.conf.Cache.Size() != 0 {
+		t.Errorf("flush: %s", w.Body)
+	}
+}
@@ -18,6 +18,8 @@ import (
 
 	"github.com/miekg/dns"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/import-yuefeng/smartDNS/core/metrics"
 )
 
 // Elem hold an answer and additional section that returned from the cache.
@@ -74,17 +76,70 @@ func (c *Cache) ReturnBack() *elem {
 	return backElem.Value.(*elem)
 }
 
-// RemoveByKey any elem based on key
-func (c *Cache) RemoveByKey(key string) {
+// RemoveByKey any elem based on key, it returns false if key is not in cache
+func (c *Cache) RemoveByKey(key string) bool {
+	c.Lock()
+	defer c.Unlock()
 	if delElem, ok := c.domain[key]; ok {
-		c.Lock()
 		c.head.Remove(delElem)
 		delete(c.domain, key)
 		// Use built-in functions for map
-		c.Unlock()
-	} else {
-		return
+		return true
+	}
+	return false
+}
+
+// Flush func remove all elems, it returns count of removed elems
+func (c *Cache) Flush() int {
+	c.Lock()
+	defer c.Unlock()
+	n := c.head.Len()
+	c.domain = make(map[string]*list.Element)
+	c.head.Init()
+	return n
+}
+
+// Entry is a cached message, it is used by debug views
+type Entry struct {
+	Question   dns.Question
+	Expiration time.Time
+	Rcode      int
+	Answer     []dns.RR
+	Bundle     string
+}
+
+// Entries func return entries whose name contains search, at most limit entries are returned
+func (c *Cache) Entries(search string, limit int) (rs []Entry) {
+	c.RLock()
+	defer c.RUnlock()
+	for e := c.head.Front(); e != nil && len(rs) < limit; e = e.Next() {
+		v := e.Value.(*elem)
+		if len(v.msg.Question) == 0 || !strings.Contains(v.msg.Question[0].Name, search) {
+			continue
+		}
+		entry := Entry{Question: v.msg.Question[0], Expiration: v.expiration, Rcode: v.msg.Rcode}
+		for _, rr := range v.msg.Answer {
+			entry.Answer = append(entry.Answer, dns.Copy(rr))
+		}
+		if v.fastMap != nil {
+			entry.Bundle = v.fastMap.DnsBundle
+		}
+		rs = append(rs, entry)
+	}
+	return rs
+}
+
+// FastMaps func return copy of FastMap of all elems, key is question of the elem
+func (c *Cache) FastMaps() map[dns.Question]FastMap {
+	c.RLock()
+	defer c.RUnlock()
+	rs := make(map[dns.Question]FastMap, len(c.domain))
+	for _, e := range c.domain {
+		if v := e.Value.(*elem); v.fastMap != nil && len(v.msg.Question) > 0 {
+			rs[v.msg.Question[0]] = *v.fastMap
+		}
 	}
+	return rs
 }
 
 func (c *Cache) Update(key string, fastMap *FastMap) bool {
@@ -123,6 +178,7 @@ func (c *Cache) RemoveTail(num int) {
 			log.Info(i.Value, v)
 		}
 		c.head.Remove(i)
+		metrics.CacheEvictions.Inc()
 
 		// Use built-in functions for map
 		num--
@@ -205,12 +261,15 @@ func (c *Cache) Hit(key string, msgid uint16) (isHit bool, BundleName string, _
 			for _, a := range pointer.msg.Answer {
 				a.Header().Ttl = uint32(time.Since(exp).Seconds() * -1)
 			}
+			metrics.CacheHits.Inc()
 			return true, pointer.fastMap.DnsBundle, pointer.msg
 		}
 		// TODO Update program
+		metrics.CacheMisses.Inc()
 		return true, pointer.fastMap.DnsBundle, nil
 		// Expired! /o\
 	}
+	metrics.CacheMisses.Inc()
 	return false, "", nil
 }
 
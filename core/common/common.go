@@ -9,40 +9,15 @@ package common
 
 import (
 	"net"
-	"regexp"
 	"strings"
 
 	"github.com/miekg/dns"
-	log "github.com/sirupsen/logrus"
+
+	"github.com/import-yuefeng/smartDNS/core/rule"
 )
 
 var ReservedIPNetworkList = getReservedIPNetworkList()
 
-func IsIPMatchList(ip net.IP, ipNetList []*net.IPNet, isLog bool, name string) bool {
-	if ipNetList != nil {
-		for _, ipNet := range ipNetList {
-			if ipNet.Contains(ip) {
-				if isLog {
-					log.Debugf("Matched: IP network %s %s %s", name, ip.String(), ipNet.String())
-				}
-				return true
-			}
-		}
-	} else {
-		log.Debug("IP network list is nil, not checking")
-	}
-
-	return false
-}
-
-func IsDomainMatchRule(pattern string, domain string) bool {
-	matched, err := regexp.MatchString(pattern, domain)
-	if err != nil {
-		log.Warnf("Error matching domain %s with pattern %s: %s", domain, pattern, err)
-	}
-	return matched
-}
-
 func HasAnswer(m *dns.Msg) bool { return m != nil && len(m.Answer) != 0 }
 
 func HasSubDomain(s string, sub string) bool {
@@ -84,16 +59,36 @@ func SetMinimumTTL(msg *dns.Msg, minimumTTL uint32) {
 	}
 }
 
-func SetTTLByMap(msg *dns.Msg, domainTTLMap map[string]uint32) {
-	if len(domainTTLMap) == 0 {
+// TTLRules set TTL of answers by domain regex, the first matched rule in file order is used
+type TTLRules struct {
+	patterns rule.RegexSet
+	ttls     []uint32
+}
+
+// Add func compile pattern and append the rule
+func (r *TTLRules) Add(pattern string, ttl uint32) error {
+	if err := r.patterns.Add(pattern); err != nil {
+		return err
+	}
+	r.ttls = append(r.ttls, ttl)
+	return nil
+}
+
+// Len func return count of rules
+func (r *TTLRules) Len() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.ttls)
+}
+
+func SetTTLByRules(msg *dns.Msg, rules *TTLRules) {
+	if rules.Len() == 0 {
 		return
 	}
 	for _, a := range msg.Answer {
-		name := a.Header().Name[:len(a.Header().Name)-1]
-		for k, v := range domainTTLMap {
-			if IsDomainMatchRule(k, name) {
-				a.Header().Ttl = v
-			}
+		if i := rules.patterns.Find(rule.NormalizeName(a.Header().Name)); i >= 0 {
+			a.Header().Ttl = rules.ttls[i]
 		}
 	}
 }
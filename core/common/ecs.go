@@ -0,0 +1,51 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package common
+
+import (
+	"fmt"
+	"net"
+)
+
+// Policies of EDNSClientSubnet
+const (
+	ECSDisable = "disable"
+	ECSEnable  = "enable"
+)
+
+// EDNSClientSubnet is the EDNS client subnet setting of an upstream, kept for configs imported from overture
+// Its fields are accepted and validated but not applied, queries are sent unchanged until cache and coalescing
+// are keyed on client subnet.
+type EDNSClientSubnet struct {
+	// Policy is "disable" (default) or "enable", neither changes queries for now
+	Policy string
+	// ExternalIP is validated, it is meant to replace reserved client addresses like 192.168.1.2
+	ExternalIP string
+	// NoCookie is accepted, EDNS cookie of client is not removed for now
+	NoCookie bool
+}
+
+// Check func return error if policy or external IP is invalid
+func (e *EDNSClientSubnet) Check() error {
+	if e == nil {
+		return nil
+	}
+	switch e.Policy {
+	case "", ECSDisable, ECSEnable:
+	default:
+		return fmt.Errorf("invalid Policy %s, must be %s or %s", e.Policy, ECSDisable, ECSEnable)
+	}
+	if e.ExternalIP != "" && net.ParseIP(e.ExternalIP) == nil {
+		return fmt.Errorf("invalid ExternalIP %s", e.ExternalIP)
+	}
+	return nil
+}
+
+// Enabled func report whether policy asks for client subnet, which is not applied yet
+func (e *EDNSClientSubnet) Enabled() bool {
+	return e != nil && e.Policy == ECSEnable
+}
@@ -0,0 +1,26 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package common
+
+import "testing"
+
+func TestEDNSClientSubnet_Check(t *testing.T) {
+	var e *EDNSClientSubnet
+	if err := e.Check(); err != nil {
+		t.Errorf("nil EDNSClientSubnet: %s", err)
+	}
+	for _, e := range []*EDNSClientSubnet{{}, {Policy: ECSDisable}, {Policy: ECSEnable, ExternalIP: "203.0.113.7", NoCookie: true}} {
+		if err := e.Check(); err != nil {
+			t.Errorf("%+v: %s", e, err)
+		}
+	}
+	for _, e := range []*EDNSClientSubnet{{Policy: "auto"}, {Policy: ECSEnable, ExternalIP: "203.0.113"}} {
+		if err := e.Check(); err == nil {
+			t.Errorf("%+v should be invalid", e)
+		}
+	}
+}
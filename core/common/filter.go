@@ -8,14 +8,165 @@ package common
 
 import (
 	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
 
 	"github.com/import-yuefeng/smartDNS/core/matcher"
+	"github.com/import-yuefeng/smartDNS/core/matcher/iptrie"
+	"github.com/import-yuefeng/smartDNS/core/rule"
+)
+
+// Actions of answers that contain a bogus IP
+const (
+	BogusIPNXDomain = "nxdomain"
+	BogusIPFail     = "fail"
 )
 
+// defaultAntiPoisoningWindow is used when AntiPoisoningWindow is not set
+const defaultAntiPoisoningWindow = 100 * time.Millisecond
+
 type Filter struct {
-	Matcher       string
-	DomainFile    string
-	IPNetworkFile string
-	IPNetworkList []*net.IPNet
-	DomainList    matcher.Matcher
+	Matcher              string
+	DomainFile           string
+	DomainURL            string
+	DomainChecksumURL    string
+	IPNetworkFile        string
+	IPNetworkURL         string
+	IPNetworkChecksumURL string
+	IPNetworkList        *iptrie.Trie
+	DomainList           matcher.Matcher
+
+	// BogusIPFile lists hijack addresses that upstreams of the bundle return instead of NXDOMAIN
+	BogusIPFile string
+	// BogusIPAction is "nxdomain" (default) or "fail", the latter drops the answer and waits for another upstream
+	BogusIPAction string
+	BogusIPList   *iptrie.Trie
+	// AntiPoisoning waits AntiPoisoningWindow milliseconds for a second UDP response and prefers it
+	AntiPoisoning       bool
+	AntiPoisoningWindow int
+
+	// lock guards DomainList and IPNetworkList, they are replaced when a subscription is refreshed
+	lock sync.RWMutex
+	// domains are added (true) or removed (false) at runtime, they take precedence over DomainList
+	domains map[string]bool
+}
+
+// SetDomain func add domain and its subdomains to filter at runtime, or remove them if matched is false
+// IDN domains must be converted by rule.NormalizeDomain first, like entries of domain lists.
+func (f *Filter) SetDomain(domain string, matched bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.domains == nil {
+		f.domains = make(map[string]bool)
+	}
+	f.domains[rule.NormalizeName(domain)] = matched
+}
+
+// ResetDomain func drop the runtime change of domain, DomainList decides again
+func (f *Filter) ResetDomain(domain string) {
+	f.lock.Lock()
+	delete(f.domains, rule.NormalizeName(domain))
+	f.lock.Unlock()
+}
+
+// RuntimeDomains func return copy of domains changed at runtime
+func (f *Filter) RuntimeDomains() map[string]bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	domains := make(map[string]bool, len(f.domains))
+	for k, v := range f.domains {
+		domains[k] = v
+	}
+	return domains
+}
+
+// MatchRuntimeDomain func check name against domains changed at runtime, the most specific one wins
+// ok is false if neither name nor its parents are changed.
+func (f *Filter) MatchRuntimeDomain(name string) (matched bool, ok bool) {
+	if f == nil {
+		return false, false
+	}
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	if len(f.domains) == 0 {
+		return false, false
+	}
+	name = rule.NormalizeName(name)
+	for {
+		if matched, ok = f.domains[name]; ok {
+			return matched, true
+		}
+		i := strings.Index(name, ".")
+		if i < 0 {
+			return false, false
+		}
+		name = name[i+1:]
+	}
+}
+
+// GetDomainList func return current domain matcher
+func (f *Filter) GetDomainList() matcher.Matcher {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.DomainList
+}
+
+// SetDomainList func replace domain matcher, queries in flight keep using the old one
+func (f *Filter) SetDomainList(m matcher.Matcher) {
+	f.lock.Lock()
+	f.DomainList = m
+	f.lock.Unlock()
+}
+
+// GetIPNetworkList func return current ip network list
+func (f *Filter) GetIPNetworkList() *iptrie.Trie {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.IPNetworkList
+}
+
+// SetIPNetworkList func replace ip network list
+func (f *Filter) SetIPNetworkList(l *iptrie.Trie) {
+	f.lock.Lock()
+	f.IPNetworkList = l
+	f.lock.Unlock()
+}
+
+// IsBogus func return true if any A/AAAA answer of msg is in BogusIPList
+func (f *Filter) IsBogus(msg *dns.Msg) bool {
+	if f == nil || f.BogusIPList == nil || msg == nil {
+		return false
+	}
+	for _, rr := range msg.Answer {
+		var ip net.IP
+		switch rr := rr.(type) {
+		case *dns.A:
+			ip = rr.A
+		case *dns.AAAA:
+			ip = rr.AAAA
+		}
+		if f.BogusIPList.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// FailOnBogus func return true if answers with bogus IP are dropped instead of turned into NXDOMAIN
+func (f *Filter) FailOnBogus() bool {
+	return f != nil && f.BogusIPAction == BogusIPFail
+}
+
+// AntiPoisoningTimeout func return how long to wait for a second UDP response, zero if anti-poisoning is off
+func (f *Filter) AntiPoisoningTimeout() time.Duration {
+	if f == nil || !f.AntiPoisoning {
+		return 0
+	}
+	if f.AntiPoisoningWindow > 0 {
+		return time.Duration(f.AntiPoisoningWindow) * time.Millisecond
+	}
+	return defaultAntiPoisoningWindow
 }
@@ -0,0 +1,43 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package common
+
+import "testing"
+
+func TestFilter_MatchRuntimeDomain(t *testing.T) {
+	f := new(Filter)
+	if _, ok := f.MatchRuntimeDomain("example.com"); ok {
+		t.Fatal("filter without runtime domains decides")
+	}
+	f.SetDomain("Example.com.", true)
+	f.SetDomain("ads.example.com", false)
+
+	for _, c := range []struct {
+		name    string
+		matched bool
+		ok      bool
+	}{
+		{"example.com.", true, true},
+		{"www.EXAMPLE.com", true, true},
+		{"ads.example.com", false, true},
+		{"x.ads.example.com", false, true},
+		{"example.org", false, false},
+		{"com", false, false},
+	} {
+		if matched, ok := f.MatchRuntimeDomain(c.name); matched != c.matched || ok != c.ok {
+			t.Errorf("%s: got %t %t, want %t %t", c.name, matched, ok, c.matched, c.ok)
+		}
+	}
+
+	f.ResetDomain("ads.example.com")
+	if matched, ok := f.MatchRuntimeDomain("x.ads.example.com"); !matched || !ok {
+		t.Error("reset domain still overrides its parent")
+	}
+	if len(f.RuntimeDomains()) != 1 {
+		t.Errorf("unexpected runtime domains %v", f.RuntimeDomains())
+	}
+}
@@ -0,0 +1,95 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package common
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/import-yuefeng/smartDNS/core/matcher/iptrie"
+)
+
+// Protocols of Listener
+const (
+	ListenUDP   = "udp"
+	ListenTCP   = "tcp"
+	ListenTLS   = "tls"
+	ListenHTTPS = "https"
+)
+
+// ListenProtocols are the protocols a listener may serve
+var ListenProtocols = []string{ListenUDP, ListenTCP, ListenTLS, ListenHTTPS}
+
+// defaultDoHPath is Path of https listeners, as suggested by RFC 8484
+const defaultDoHPath = "/dns-query"
+
+// Listener is an address smartDNS serves clients on, with its own access control and rejected query types
+type Listener struct {
+	// Name is the listener label of metrics, Address is used if it is empty
+	Name    string
+	Address string
+	// Protocols default to udp and tcp, tls (DNS over TLS) and https (DNS over HTTPS) require CertFile and KeyFile
+	Protocols []string
+	CertFile  string
+	KeyFile   string
+	// Path of DNS over HTTPS requests, "/dns-query" if empty
+	Path string
+	// Group names the clients of the listener in query log
+	Group string
+	// ACL lists clients (IP, CIDR or range) allowed to query, "!" before an entry denies it.
+	// Clients are allowed if the list is empty or has only denied entries, other clients are refused.
+	ACL []string
+	// RejectQType is used instead of RejectQType of config if it is not empty
+	RejectQType []uint16
+
+	allow *iptrie.Trie
+	deny  *iptrie.Trie
+}
+
+// Init func fill defaults and parse ACL
+func (l *Listener) Init() error {
+	if l.Name == "" {
+		l.Name = l.Address
+	}
+	if len(l.Protocols) == 0 {
+		l.Protocols = []string{ListenUDP, ListenTCP}
+	}
+	if l.Path == "" {
+		l.Path = defaultDoHPath
+	}
+
+	l.allow, l.deny = iptrie.New(), iptrie.New()
+	for _, s := range l.ACL {
+		t := l.allow
+		if strings.HasPrefix(s, "!") {
+			t, s = l.deny, s[1:]
+		}
+		if err := t.Insert(s); err != nil {
+			return fmt.Errorf("Invalid ACL of listener %s: %s", l.Name, err)
+		}
+	}
+	return nil
+}
+
+// Allowed func return true if ip may query the listener
+func (l *Listener) Allowed(ip net.IP) bool {
+	if l.deny != nil && l.deny.Contains(ip) {
+		return false
+	}
+	return l.allow == nil || l.allow.Len() == 0 || l.allow.Contains(ip)
+}
+
+// Serves func return true if protocol is one of Protocols
+func (l *Listener) Serves(protocol string) bool {
+	for _, p := range l.Protocols {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package common
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/import-yuefeng/smartDNS/core/matcher"
+	"github.com/import-yuefeng/smartDNS/core/matcher/iptrie"
+	"github.com/import-yuefeng/smartDNS/core/rule"
+)
+
+// Modes of rebinding protection
+const (
+	RebindingOff      = "off"
+	RebindingStrip    = "strip"
+	RebindingNXDomain = "nxdomain"
+)
+
+// rebindingNetworks are ReservedIPNetworkList and the unspecified, link-local and IPv6 private ranges it lacks,
+// 0.0.0.0 reaches local services on Linux and macOS
+var rebindingNetworks = newRebindingNetworks()
+
+func newRebindingNetworks() *iptrie.Trie {
+	t := iptrie.New()
+	for _, ipNet := range append(parseCIDRList("0.0.0.0/8", "169.254.0.0/16", "::/128", "::1/128", "fc00::/7", "fe80::/10"), ReservedIPNetworkList...) {
+		t.InsertIPNet(ipNet)
+	}
+	return t
+}
+
+// Rebinding removes upstream answers that point at private, loopback, link-local or CGNAT addresses (DNS rebinding),
+// names in AllowDomains and AllowFile may still resolve to them
+type Rebinding struct {
+	Mode         string
+	AllowDomains []string
+	AllowFile    string
+	AllowList    matcher.Matcher
+}
+
+// Enabled func return true if answers are checked
+func (r *Rebinding) Enabled() bool {
+	return r != nil && (r.Mode == RebindingStrip || r.Mode == RebindingNXDomain)
+}
+
+// Check func strip private addresses from msg, or turn msg into NXDOMAIN, it returns true if msg is changed
+func (r *Rebinding) Check(msg *dns.Msg) bool {
+	if !r.Enabled() || msg == nil || len(msg.Question) == 0 {
+		return false
+	}
+
+	var kept []dns.RR
+	blocked := false
+	for _, rr := range msg.Answer {
+		var ip net.IP
+		switch rr := rr.(type) {
+		case *dns.A:
+			ip = rr.A
+		case *dns.AAAA:
+			ip = rr.AAAA
+		}
+		if ip != nil && rebindingNetworks.Contains(ip) && !r.allowed(rr.Header().Name) && !r.allowed(msg.Question[0].Name) {
+			blocked = true
+			continue
+		}
+		kept = append(kept, rr)
+	}
+	if !blocked {
+		return false
+	}
+
+	log.Warnf("Rebinding protection: answer of %s points at reserved address (%s)", msg.Question[0].Name, r.Mode)
+	if r.Mode == RebindingNXDomain {
+		msg.Rcode = dns.RcodeNameError
+		msg.Answer = nil
+		return true
+	}
+	msg.Answer = kept
+	return true
+}
+
+func (r *Rebinding) allowed(name string) bool {
+	return r.AllowList != nil && r.AllowList.Has(rule.NormalizeName(name))
+}
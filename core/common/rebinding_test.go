@@ -0,0 +1,74 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package common
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/import-yuefeng/smartDNS/core/matcher/suffix"
+)
+
+func TestRebinding_Check(t *testing.T) {
+	allow := suffix.NewDomainTree()
+	allow.Insert("corp.internal")
+
+	answer := func(name string, rrs ...string) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		for _, s := range rrs {
+			rr, err := dns.NewRR(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.Answer = append(m.Answer, rr)
+		}
+		return m
+	}
+
+	strip := &Rebinding{Mode: RebindingStrip, AllowList: allow}
+	m := answer("evil.com.", "evil.com. 60 IN A 192.168.1.1", "evil.com. 60 IN A 1.2.3.4", "evil.com. 60 IN AAAA fe80::1")
+	if !strip.Check(m) || len(m.Answer) != 1 || m.Rcode != dns.RcodeSuccess {
+		t.Errorf("strip: %s", m)
+	}
+
+	nx := &Rebinding{Mode: RebindingNXDomain, AllowList: allow}
+	m = answer("evil.com.", "evil.com. 60 IN A 127.0.0.1")
+	if !nx.Check(m) || len(m.Answer) != 0 || m.Rcode != dns.RcodeNameError {
+		t.Errorf("nxdomain: %s", m)
+	}
+
+	for _, m := range []*dns.Msg{
+		answer("www.corp.internal.", "www.corp.internal. 60 IN A 10.0.0.1"),
+		answer("alias.example.com.", "alias.example.com. 60 IN CNAME www.corp.internal.", "www.corp.internal. 60 IN A 10.0.0.1"),
+		answer("example.com.", "example.com. 60 IN A 93.184.216.34"),
+	} {
+		if nx.Check(m) {
+			t.Errorf("allowed answer is changed: %s", m)
+		}
+	}
+
+	var off *Rebinding
+	m = answer("evil.com.", "evil.com. 60 IN A 10.0.0.1")
+	if off.Check(m) || (&Rebinding{Mode: RebindingOff}).Check(m) {
+		t.Error("disabled protection should not change answer")
+	}
+}
+
+func TestRebindingNetworks(t *testing.T) {
+	for ip, want := range map[string]bool{
+		"127.0.0.1": true, "10.1.2.3": true, "172.31.0.1": true, "192.168.1.1": true, "100.64.0.1": true,
+		"169.254.169.254": true, "::1": true, "fd12::1": true, "fe80::1": true, "0.0.0.0": true, "0.1.2.3": true, "::": true,
+		"8.8.8.8": false, "172.32.0.1": false, "2001:4860::8888": false,
+	} {
+		if got := rebindingNetworks.Contains(net.ParseIP(ip)); got != want {
+			t.Errorf("%s: got %v, want %v", ip, got, want)
+		}
+	}
+}
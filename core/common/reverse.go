@@ -0,0 +1,98 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package common
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	v4ReverseSuffix = "in-addr.arpa."
+	v6ReverseSuffix = "ip6.arpa."
+)
+
+// privateReverseNetworks are the RFC 6303 and RFC 7793 locally served ranges, only reverse zones use them
+var privateReverseNetworks = parseCIDRList(
+	"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10",
+	"0.0.0.0/8", "169.254.0.0/16", "192.0.2.0/24", "198.51.100.0/24", "203.0.113.0/24", "255.255.255.255/32",
+	"::/128", "::1/128", "fd00::/8", "fe80::/10", "2001:db8::/32",
+)
+
+// PrivateReverseZone func return apex of the locally served reverse zone (RFC 6303) that name belongs to,
+// e.g. "4.3.2.10.in-addr.arpa." -> "10.in-addr.arpa."
+func PrivateReverseZone(name string) (apex string, ok bool) {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+
+	var suffix string
+	var size, unit int
+	switch {
+	case strings.HasSuffix(name, "."+v4ReverseSuffix):
+		suffix, size, unit = v4ReverseSuffix, net.IPv4len, 8
+	case strings.HasSuffix(name, "."+v6ReverseSuffix):
+		suffix, size, unit = v6ReverseSuffix, net.IPv6len, 4
+	default:
+		return "", false
+	}
+
+	// labels from the most significant one, parsing stops at the first label that is not an octet or nibble
+	labels := strings.Split(strings.TrimSuffix(name, "."+suffix), ".")
+	ip := make(net.IP, size)
+	bits := 0
+	for i := len(labels) - 1; i >= 0 && bits < size*8; i-- {
+		var v uint64
+		var err error
+		switch {
+		case unit == 8:
+			v, err = strconv.ParseUint(labels[i], 10, 8)
+		case len(labels[i]) == 1:
+			v, err = strconv.ParseUint(labels[i], 16, 4)
+		default:
+			err = strconv.ErrSyntax
+		}
+		if err != nil {
+			break
+		}
+		if unit == 8 {
+			ip[bits/8] = byte(v)
+		} else {
+			ip[bits/8] |= byte(v) << uint(4-bits%8)
+		}
+		bits += unit
+	}
+
+	for _, ipNet := range privateReverseNetworks {
+		ones, netSize := ipNet.Mask.Size()
+		if netSize != size*8 || bits < ones || !ipNet.Contains(ip) {
+			continue
+		}
+		// apex is the shortest reverse name covering the network
+		n := (ones + unit - 1) / unit
+		if n == 0 {
+			return suffix, true
+		}
+		apex = strings.Join(labels[len(labels)-n:], ".") + "." + suffix
+		return apex, true
+	}
+	return "", false
+}
+
+func parseCIDRList(cidrs ...string) []*net.IPNet {
+	var ipNetList []*net.IPNet
+	for _, c := range cidrs {
+		_, ipNet, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		ipNetList = append(ipNetList, ipNet)
+	}
+	return ipNetList
+}
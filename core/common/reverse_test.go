@@ -0,0 +1,39 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package common
+
+import "testing"
+
+func TestPrivateReverseZone(t *testing.T) {
+	cases := map[string]string{
+		"4.3.2.10.in-addr.arpa.":        "10.in-addr.arpa.",
+		"10.in-addr.arpa":               "10.in-addr.arpa.",
+		"1.1.168.192.IN-ADDR.ARPA.":     "168.192.in-addr.arpa.",
+		"1.20.172.in-addr.arpa.":        "20.172.in-addr.arpa.",
+		"1.32.172.in-addr.arpa.":        "",
+		"172.in-addr.arpa.":             "",
+		"in-addr.arpa.":                 "",
+		"1.2.0.192.in-addr.arpa.":       "2.0.192.in-addr.arpa.",
+		"x.1.100.in-addr.arpa.":         "",
+		"x.64.100.in-addr.arpa.":        "64.100.in-addr.arpa.",
+		"8.8.8.8.in-addr.arpa.":         "",
+		"255.255.255.255.in-addr.arpa.": "255.255.255.255.in-addr.arpa.",
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa.": "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa.",
+		"0.0.ip6.arpa.":             "",
+		"1.2.3.d.f.ip6.arpa.":       "d.f.ip6.arpa.",
+		"b.e.f.ip6.arpa.":           "b.e.f.ip6.arpa.",
+		"c.e.f.ip6.arpa.":           "",
+		"8.b.d.0.1.0.0.2.ip6.arpa.": "8.b.d.0.1.0.0.2.ip6.arpa.",
+		"www.example.com.":          "",
+	}
+	for name, want := range cases {
+		apex, ok := PrivateReverseZone(name)
+		if apex != want || ok != (want != "") {
+			t.Errorf("PrivateReverseZone(%s) = %s %v, want %s", name, apex, ok, want)
+		}
+	}
+}
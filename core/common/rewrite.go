@@ -0,0 +1,46 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package common
+
+import "github.com/import-yuefeng/smartDNS/core/finder"
+
+// Rewrite holds domain rewrite rules, they are checked before hosts, cache and domain lists.
+// AddressList maps domain to comma separated IPs, CNAMEList maps domain to CNAME target,
+// and BundleList maps domain to the name of DNSBunch which must be used.
+type Rewrite struct {
+	Finder      string
+	AddressFile string
+	CNAMEFile   string
+	BundleFile  string
+	AddressList finder.Finder
+	CNAMEList   finder.Finder
+	BundleList  finder.Finder
+}
+
+// GetAddress func return comma separated IPs of domain, or empty string
+func (r *Rewrite) GetAddress(name string) string {
+	if r == nil || r.AddressList == nil {
+		return ""
+	}
+	return r.AddressList.Get(name)
+}
+
+// GetCNAME func return CNAME target of domain, or empty string
+func (r *Rewrite) GetCNAME(name string) string {
+	if r == nil || r.CNAMEList == nil {
+		return ""
+	}
+	return r.CNAMEList.Get(name)
+}
+
+// GetBundle func return DNSBunch name of domain, or empty string
+func (r *Rewrite) GetBundle(name string) string {
+	if r == nil || r.BundleList == nil {
+		return ""
+	}
+	return r.BundleList.Get(name)
+}
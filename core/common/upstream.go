@@ -12,4 +12,6 @@ type DNSUpstream struct {
 	Protocol      string
 	SOCKS5Address string
 	Timeout       int
+	// EDNSClientSubnet is nil if queries are sent as they are
+	EDNSClientSubnet *EDNSClientSubnet `json:",omitempty"`
 }
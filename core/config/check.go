@@ -0,0 +1,431 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/robfig/cron"
+
+	"github.com/import-yuefeng/smartDNS/core/common"
+	"github.com/import-yuefeng/smartDNS/core/matcher/iptrie"
+	"github.com/import-yuefeng/smartDNS/core/zone"
+)
+
+// Names accepted by Matcher of DNSFilter and Finder of Rewrite
+var (
+	matcherNames = []string{"suffix-tree", "full-map", "full-list", "regex-list", "mix-list"}
+	finderNames  = []string{"suffix-tree", "full-map", "regex-list"}
+	protocols    = []string{"udp", "tcp", "tcp-tls"}
+)
+
+// problems collects config errors, each one is prefixed by the path of the field
+type problems []string
+
+func (p *problems) add(path string, format string, args ...interface{}) {
+	if path != "" {
+		path += ": "
+	}
+	*p = append(*p, path+fmt.Sprintf(format, args...))
+}
+
+// Check func validate config file without loading rule lists or starting anything, all problems are returned
+// Problems of fields start with their path, like "DNSBunch.HK-DNS[0].Address: missing port in address".
+func Check(configFile string) []string {
+	var p problems
+	src, err := loadSource(configFile)
+	if err != nil {
+		p.add("", "%s", strings.TrimPrefix(err.Error(), configFile+": "))
+		return p
+	}
+	for _, env := range src.unknownEnv {
+		p.add(env, "environment variable matches no top-level setting")
+	}
+	for _, f := range unknownFields("", src.raw, reflect.TypeOf(Config{})) {
+		p.add(f, "unknown field")
+	}
+
+	c := new(Config)
+	if err := src.decode(c); err != nil {
+		if terr, ok := err.(*json.UnmarshalTypeError); ok && terr.Field != "" {
+			p.add(terr.Field, "cannot use %s as %s", terr.Value, terr.Type)
+		} else {
+			p.add("", "%s", err)
+		}
+		return p
+	}
+	c.check(&p)
+	return p
+}
+
+// check func validate fields of parsed config
+func (c *Config) check(p *problems) {
+	if c.BindAddress != "" || len(c.Listeners) == 0 {
+		checkHostPort(p, "BindAddress", c.BindAddress, true)
+	}
+	c.checkListeners(p)
+	if _, _, err := (&Config{User: c.User}).RunAs(); err != nil {
+		p.add("User", "%s does not exist", c.User)
+	}
+	if _, _, err := (&Config{Group: c.Group}).RunAs(); err != nil {
+		p.add("Group", "%s does not exist", c.Group)
+	}
+	if c.Group != "" && c.User == "" {
+		p.add("Group", "requires User, privileges are only dropped to a user")
+	}
+	if c.DebugHTTPAddress != "" {
+		checkHostPort(p, "DebugHTTPAddress", c.DebugHTTPAddress, true)
+	}
+	if c.MinimumTTL < 0 {
+		p.add("MinimumTTL", "must not be negative")
+	}
+	if c.CacheSize < 0 {
+		p.add("CacheSize", "must not be negative")
+	}
+	checkCrontab(p, "CacheCrontab", c.CacheCrontab)
+	checkCrontab(p, "RuleRefreshCrontab", c.RuleRefreshCrontab)
+
+	if len(c.DNSBunch) == 0 {
+		p.add("DNSBunch", "no bundle is defined")
+	}
+	for _, name := range sortedKeys(c.DNSBunch) {
+		path := "DNSBunch." + name
+		if _, ok := c.DNSFilter[name]; !ok {
+			p.add(path, "has no DNSFilter with the same name")
+		}
+		if len(c.DNSBunch[name]) == 0 {
+			p.add(path, "has no upstream")
+		}
+		names := make(map[string]bool)
+		for i, u := range c.DNSBunch[name] {
+			upath := fmt.Sprintf("%s[%d]", path, i)
+			if u == nil {
+				p.add(upath, "is null")
+				continue
+			}
+			if u.Name == "" {
+				p.add(upath+".Name", "is empty")
+			} else if names[u.Name] {
+				p.add(upath+".Name", "%s is used by another upstream of the bundle", u.Name)
+			}
+			names[u.Name] = true
+			if u.Protocol == "tcp-tls" {
+				checkTLSAddress(p, upath+".Address", u.Address)
+			} else {
+				checkHostPort(p, upath+".Address", u.Address, false)
+			}
+			if !contains(protocols, u.Protocol) {
+				p.add(upath+".Protocol", "%q is not one of %s", u.Protocol, strings.Join(protocols, ", "))
+			}
+			if u.SOCKS5Address != "" {
+				checkHostPort(p, upath+".SOCKS5Address", u.SOCKS5Address, false)
+			}
+			if u.Timeout <= 0 {
+				p.add(upath+".Timeout", "must be positive seconds")
+			}
+			if err := u.EDNSClientSubnet.Check(); err != nil {
+				p.add(upath+".EDNSClientSubnet", "%s", err)
+			} else if u.EDNSClientSubnet.Enabled() {
+				warnECS(upath)
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(c.DNSFilter) {
+		path := "DNSFilter." + name
+		f := c.DNSFilter[name]
+		if _, ok := c.DNSBunch[name]; !ok {
+			p.add(path, "has no DNSBunch with the same name")
+		}
+		if f == nil {
+			continue
+		}
+		if f.Matcher != "" && !contains(matcherNames, f.Matcher) {
+			p.add(path+".Matcher", "%q is not one of %s", f.Matcher, strings.Join(matcherNames, ", "))
+		}
+		// with a URL, the file is only a local copy and may not exist yet
+		if f.DomainURL != "" {
+			checkURL(p, path+".DomainURL", f.DomainURL)
+		} else {
+			checkFile(p, path+".DomainFile", f.DomainFile)
+		}
+		if f.IPNetworkURL != "" {
+			checkURL(p, path+".IPNetworkURL", f.IPNetworkURL)
+		} else {
+			checkFile(p, path+".IPNetworkFile", f.IPNetworkFile)
+		}
+		if f.DomainChecksumURL != "" {
+			checkURL(p, path+".DomainChecksumURL", f.DomainChecksumURL)
+		}
+		if f.IPNetworkChecksumURL != "" {
+			checkURL(p, path+".IPNetworkChecksumURL", f.IPNetworkChecksumURL)
+		}
+		checkFile(p, path+".BogusIPFile", f.BogusIPFile)
+		switch f.BogusIPAction {
+		case "", common.BogusIPNXDomain, common.BogusIPFail:
+		default:
+			p.add(path+".BogusIPAction", "%q is not one of %s, %s", f.BogusIPAction, common.BogusIPNXDomain, common.BogusIPFail)
+		}
+		if f.AntiPoisoningWindow < 0 {
+			p.add(path+".AntiPoisoningWindow", "must not be negative")
+		}
+	}
+
+	if _, ok := c.DNSBunch[c.DefaultDNSBundle]; c.DefaultDNSBundle != "" && !ok {
+		p.add("DefaultDNSBundle", "DNSBunch %s does not exist", c.DefaultDNSBundle)
+	}
+	if _, ok := c.DNSBunch[c.PrivateReverseBundle]; c.PrivateReverseBundle != "" && !ok {
+		p.add("PrivateReverseBundle", "DNSBunch %s does not exist", c.PrivateReverseBundle)
+	}
+
+	for i, f := range c.HostsFile {
+		checkFile(p, fmt.Sprintf("HostsFile[%d]", i), f)
+	}
+	checkFile(p, "DomainTTLFile", c.DomainTTLFile)
+
+	if r := c.Rewrite; r != nil {
+		if r.Finder != "" && !contains(finderNames, r.Finder) {
+			p.add("Rewrite.Finder", "%q is not one of %s", r.Finder, strings.Join(finderNames, ", "))
+		}
+		checkFile(p, "Rewrite.AddressFile", r.AddressFile)
+		checkFile(p, "Rewrite.CNAMEFile", r.CNAMEFile)
+		checkFile(p, "Rewrite.BundleFile", r.BundleFile)
+	}
+	if r := c.RebindingProtection; r != nil {
+		switch r.Mode {
+		case "", common.RebindingOff, common.RebindingStrip, common.RebindingNXDomain:
+		default:
+			p.add("RebindingProtection.Mode", "%q is not one of %s, %s, %s", r.Mode, common.RebindingOff, common.RebindingStrip, common.RebindingNXDomain)
+		}
+		checkFile(p, "RebindingProtection.AllowFile", r.AllowFile)
+	}
+
+	for i, z := range c.LocalZones {
+		if z == nil {
+			p.add(fmt.Sprintf("LocalZones[%d]", i), "is null")
+			continue
+		}
+		if _, err := zone.New([]*zone.Config{z}); err != nil {
+			p.add(fmt.Sprintf("LocalZones[%d]", i), "%s", err)
+		}
+	}
+	if err := c.QueryLog.Check(); err != nil {
+		p.add("QueryLog", "%s", err)
+	}
+	if err := c.Dnstap.Check(); err != nil {
+		p.add("Dnstap", "%s", err)
+	}
+}
+
+// unknownFields func return paths of keys in raw JSON value v that have no field in type t
+// Keys are matched case-insensitively like encoding/json does.
+func unknownFields(path string, v interface{}, t reflect.Type) (unknown []string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()) {
+		return nil
+	}
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for _, key := range sortedKeys(m) {
+			field, ok := jsonField(t, key)
+			if !ok {
+				unknown = append(unknown, join(key))
+				continue
+			}
+			unknown = append(unknown, unknownFields(join(key), m[key], field.Type)...)
+		}
+	case reflect.Map:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for _, key := range sortedKeys(m) {
+			unknown = append(unknown, unknownFields(join(key), m[key], t.Elem())...)
+		}
+	case reflect.Slice, reflect.Array:
+		l, ok := v.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, e := range l {
+			unknown = append(unknown, unknownFields(fmt.Sprintf("%s[%d]", path, i), e, t.Elem())...)
+		}
+	}
+	return unknown
+}
+
+// jsonField func find exported field of struct t that JSON key decodes into
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// checkListeners func validate Listeners, the same address and protocol can't be used twice
+func (c *Config) checkListeners(p *problems) {
+	used := make(map[string]string)
+	if c.BindAddress != "" {
+		used["udp "+c.BindAddress] = "BindAddress"
+		used["tcp "+c.BindAddress] = "BindAddress"
+	}
+	for i, l := range c.Listeners {
+		path := fmt.Sprintf("Listeners[%d]", i)
+		if l == nil {
+			p.add(path, "is null")
+			continue
+		}
+		checkHostPort(p, path+".Address", l.Address, true)
+		protocols := l.Protocols
+		if len(protocols) == 0 {
+			protocols = []string{common.ListenUDP, common.ListenTCP}
+		}
+		secure := false
+		for _, proto := range protocols {
+			if !contains(common.ListenProtocols, proto) {
+				p.add(path+".Protocols", "%q is not one of %s", proto, strings.Join(common.ListenProtocols, ", "))
+				continue
+			}
+			secure = secure || proto == common.ListenTLS || proto == common.ListenHTTPS
+			// tls and https share the tcp port space with tcp
+			network := "udp "
+			if proto != common.ListenUDP {
+				network = "tcp "
+			}
+			if other, ok := used[network+l.Address]; ok {
+				p.add(path+".Address", "%s of %s is already used by %s", proto, l.Address, other)
+			}
+			used[network+l.Address] = path
+		}
+		if secure {
+			if l.CertFile == "" || l.KeyFile == "" {
+				p.add(path, "CertFile and KeyFile are required by tls and https")
+			}
+			checkFile(p, path+".CertFile", l.CertFile)
+			checkFile(p, path+".KeyFile", l.KeyFile)
+		}
+		if l.Path != "" && !strings.HasPrefix(l.Path, "/") {
+			p.add(path+".Path", "must start with /")
+		}
+		for j, s := range l.ACL {
+			if err := iptrie.New().Insert(strings.TrimPrefix(s, "!")); err != nil {
+				p.add(fmt.Sprintf("%s.ACL[%d]", path, j), "%s", err)
+			}
+		}
+	}
+}
+
+func checkHostPort(p *problems, path string, address string, listen bool) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		p.add(path, "%s", err)
+		return
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil || port == "" {
+		p.add(path, "invalid port %q", port)
+	}
+	if host == "" && !listen {
+		p.add(path, "host is empty")
+	}
+}
+
+// checkTLSAddress func check address of DNS over TLS upstream, host:port@ip connects to ip with host as server name
+func checkTLSAddress(p *problems, path string, address string) {
+	s := strings.Split(address, "@")
+	switch len(s) {
+	case 1:
+		checkHostPort(p, path, address, false)
+	case 2:
+		checkHostPort(p, path, s[0], false)
+		if net.ParseIP(s[1]) == nil {
+			p.add(path, "invalid IP %q after @", s[1])
+		}
+	default:
+		p.add(path, "must be host:port or host:port@ip")
+	}
+}
+
+func checkFile(p *problems, path string, file string) {
+	if file == "" {
+		return
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		p.add(path, "%s", err)
+		return
+	}
+	f.Close()
+}
+
+func checkURL(p *problems, path string, rawURL string) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		p.add(path, "%s", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		p.add(path, "%s is not a http or https URL", rawURL)
+	}
+}
+
+func checkCrontab(p *problems, path string, spec string) {
+	if spec == "" {
+		return
+	}
+	if _, err := cron.Parse(spec); err != nil {
+		p.add(path, "%s", err)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys func return sorted keys of a map with string key
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,148 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{
+  "BindAddress": ":53",
+  "bindaddress": ":53",
+  "DebugHTTPAdress": "127.0.0.1:5555",
+  "HostsFile": "./missing-hosts",
+  "DNSBunch": {
+    "A": [{"Name": "a1", "Address": "1.1.1.1", "Protocol": "udp", "Timeout": 3,
+      "EDNSClientSubnet": {"Policy": "enable", "ExternalIP": "", "NoCookie": false, "Subnet": 24}}]
+  },
+  "DNSFilter": {"B": {"Matcher": "suffix-tree"}},
+  "DefaultDNSBundle": "B",
+  "RuleRefreshCrontab": "@every 6h"
+}`)
+	f.Close()
+
+	want := []string{
+		"DNSBunch.A[0].EDNSClientSubnet.Subnet: unknown field",
+		"DebugHTTPAdress: unknown field",
+		"DNSBunch.A: has no DNSFilter with the same name",
+		"DNSBunch.A[0].Address: address 1.1.1.1: missing port in address",
+		"DNSFilter.B: has no DNSBunch with the same name",
+		"DefaultDNSBundle: DNSBunch B does not exist",
+		"HostsFile[0]: open ./missing-hosts: no such file or directory",
+	}
+	if got := Check(f.Name()); !reflect.DeepEqual(got, want) {
+		t.Errorf("got problems:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestCheck_Listeners(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{
+  "BindAddress": "127.0.0.1:53",
+  "Listeners": [
+    {"Address": "127.0.0.1:53", "Protocols": ["tcp"]},
+    {"Address": "0.0.0.0:853", "Protocols": ["tls", "quic"], "ACL": ["10.0.0.0/8", "!bad"]},
+    {"Address": "0.0.0.0:443", "Protocols": ["https"], "CertFile": "./missing.pem", "KeyFile": "./missing.key", "Path": "dns-query"}
+  ],
+  "DNSBunch": {"A": [{"Name": "a1", "Address": "1.1.1.1:53", "Protocol": "udp", "Timeout": 3}]},
+  "DNSFilter": {"A": {"Matcher": "suffix-tree"}}
+}`)
+	f.Close()
+
+	want := []string{
+		"Listeners[0].Address: tcp of 127.0.0.1:53 is already used by BindAddress",
+		"Listeners[1].Protocols: \"quic\" is not one of udp, tcp, tls, https",
+		"Listeners[1]: CertFile and KeyFile are required by tls and https",
+		"Listeners[1].ACL[1]: invalid IP address: bad",
+		"Listeners[2].CertFile: open ./missing.pem: no such file or directory",
+		"Listeners[2].KeyFile: open ./missing.key: no such file or directory",
+		"Listeners[2].Path: must start with /",
+	}
+	if got := Check(f.Name()); !reflect.DeepEqual(got, want) {
+		t.Errorf("got problems:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestConfig_RunAs(t *testing.T) {
+	if uid, gid, err := (&Config{}).RunAs(); uid != -1 || gid != -1 || err != nil {
+		t.Errorf("empty: %d %d %v", uid, gid, err)
+	}
+	if uid, gid, err := (&Config{User: "root"}).RunAs(); uid != 0 || gid != 0 || err != nil {
+		t.Errorf("root: %d %d %v", uid, gid, err)
+	}
+	if uid, gid, err := (&Config{User: "0", Group: "0"}).RunAs(); uid != 0 || gid != 0 || err != nil {
+		t.Errorf("numeric IDs: %d %d %v", uid, gid, err)
+	}
+	if _, _, err := (&Config{User: "no-such-user-of-smartdns"}).RunAs(); err == nil {
+		t.Error("unknown user is accepted")
+	}
+}
+
+func TestCheck_GroupWithoutUser(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{
+  "BindAddress": "127.0.0.1:53",
+  "Group": "0",
+  "DNSBunch": {"A": [{"Name": "a1", "Address": "1.1.1.1:53", "Protocol": "udp", "Timeout": 3}]},
+  "DNSFilter": {"A": {"Matcher": "suffix-tree"}}
+}`)
+	f.Close()
+
+	want := []string{"Group: requires User, privileges are only dropped to a user"}
+	if got := Check(f.Name()); !reflect.DeepEqual(got, want) {
+		t.Errorf("got problems:\n%q\nwant:\n%q", got, want)
+	}
+	if _, err := LoadConfig(f.Name()); err == nil {
+		t.Error("Group without User is loaded")
+	}
+}
+
+func TestCheck_TLSAddress(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{
+  "BindAddress": "127.0.0.1:53",
+  "DNSBunch": {"A": [
+    {"Name": "a1", "Address": "dns.google:853@8.8.8.8", "Protocol": "tcp-tls", "Timeout": 3},
+    {"Name": "a2", "Address": "1.1.1.1:853", "Protocol": "tcp-tls", "Timeout": 3},
+    {"Name": "a3", "Address": "dns.google:853@dns.google", "Protocol": "tcp-tls", "Timeout": 3},
+    {"Name": "a4", "Address": "dns.google@8.8.8.8", "Protocol": "tcp-tls", "Timeout": 3},
+    {"Name": "a5", "Address": "dns.google:853@8.8.8.8", "Protocol": "udp", "Timeout": 3}
+  ]},
+  "DNSFilter": {"A": {"Matcher": "suffix-tree"}}
+}`)
+	f.Close()
+
+	want := []string{
+		"DNSBunch.A[2].Address: invalid IP \"dns.google\" after @",
+		"DNSBunch.A[3].Address: address dns.google: missing port in address",
+		"DNSBunch.A[4].Address: invalid port \"853@8.8.8.8\"",
+	}
+	if got := Check(f.Name()); !reflect.DeepEqual(got, want) {
+		t.Errorf("got problems:\n%q\nwant:\n%q", got, want)
+	}
+}
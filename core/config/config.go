@@ -12,11 +12,14 @@ package config
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"io/ioutil"
-	"net"
 	"os"
+	"os/user"
+	"reflect"
 	"strconv"
 	"strings"
 
@@ -24,43 +27,144 @@ import (
 
 	"github.com/import-yuefeng/smartDNS/core/cache"
 	"github.com/import-yuefeng/smartDNS/core/common"
+	"github.com/import-yuefeng/smartDNS/core/dnstap"
 	"github.com/import-yuefeng/smartDNS/core/hosts"
 	"github.com/import-yuefeng/smartDNS/core/matcher"
 	"github.com/import-yuefeng/smartDNS/core/matcher/full"
+	"github.com/import-yuefeng/smartDNS/core/matcher/iptrie"
 	"github.com/import-yuefeng/smartDNS/core/matcher/mix"
 	"github.com/import-yuefeng/smartDNS/core/matcher/regex"
 	"github.com/import-yuefeng/smartDNS/core/matcher/suffix"
+	"github.com/import-yuefeng/smartDNS/core/querylog"
+	"github.com/import-yuefeng/smartDNS/core/subscription"
+	"github.com/import-yuefeng/smartDNS/core/zone"
 )
 
 type Config struct {
 	BindAddress           string
+	Listeners             []*common.Listener
 	DebugHTTPAddress      string
+	AdminToken            string
+	User                  string
+	Group                 string
 	IPv6UseAlternativeDNS bool
 	DefaultDNSBundle      string
-	HostsFile             string
+	PrivateReverseBundle  string
+	HostsFile             FileList
+	Include               FileList
 	MinimumTTL            int
 	DomainTTLFile         string
 	CacheCrontab          string
+	RuleRefreshCrontab    string
 	Dectector             string
 	CacheSize             int
 	RejectQType           []uint16
-	DomainTTLMap          map[string]uint32
+	DomainTTLRules        *common.TTLRules
 	Hosts                 *hosts.Hosts
 	Cache                 *cache.Cache
 	DNSFilter             map[string]*common.Filter
 	DNSBunch              map[string][]*common.DNSUpstream
+	Rewrite               *common.Rewrite
+	RebindingProtection   *common.Rebinding
+	LocalZones            []*zone.Config
+	Zones                 *zone.Zones
+	QueryLog              *querylog.Config
+	Dnstap                *dnstap.Config
+
+	ruleSources map[string]*ruleSource
+	// included are files merged by Include
+	included []string
 }
 
-// NewConfig will input configFile(json) path, output *Config stuct
+// ruleSource holds the subscriptions of a DNSFilter
+type ruleSource struct {
+	domain    *subscription.Source
+	ipNetwork *subscription.Source
+}
+
+// defaultRuleRefreshCrontab is used when filters have subscriptions but RuleRefreshCrontab is empty
+const defaultRuleRefreshCrontab = "@every 6h"
+
+// NewConfig will input configFile(json, yaml or toml) path, output *Config stuct
 func NewConfig(configFile string) *Config {
-	// call parseJson func to parse json file
-	config := parseJSON(configFile)
+	config, err := LoadConfig(configFile)
+	if err != nil {
+		log.Fatalf("%s", err)
+		os.Exit(1)
+	}
+	return config
+}
+
+// LoadConfig func is same as NewConfig, but it returns error instead of exiting, be used by reload
+func LoadConfig(configFile string) (*Config, error) {
+	// call parseSource func to parse config file
+	config, err := parseSource(configFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.DNSBunch) != len(config.DNSFilter) {
+		return nil, errors.New("DNSBunch != DNSFilter")
+	}
+	for name, ul := range config.DNSBunch {
+		if _, ok := config.DNSFilter[name]; !ok {
+			return nil, fmt.Errorf("DNSBunch %s has no DNSFilter", name)
+		}
+		for _, u := range ul {
+			if err := u.EDNSClientSubnet.Check(); err != nil {
+				return nil, fmt.Errorf("Invalid EDNSClientSubnet of %s/%s: %s", name, u.Name, err)
+			}
+			if u.EDNSClientSubnet.Enabled() {
+				warnECS(fmt.Sprintf("DNSBunch.%s[%s]", name, u.Name))
+			}
+		}
+	}
+	if _, ok := config.DNSBunch[config.DefaultDNSBundle]; config.DefaultDNSBundle != "" && !ok {
+		return nil, fmt.Errorf("DefaultDNSBundle %s does not exist", config.DefaultDNSBundle)
+	}
+	if _, ok := config.DNSBunch[config.PrivateReverseBundle]; config.PrivateReverseBundle != "" && !ok {
+		return nil, fmt.Errorf("PrivateReverseBundle %s does not exist", config.PrivateReverseBundle)
+	}
+
+	if config.BindAddress == "" && len(config.Listeners) == 0 {
+		return nil, errors.New("Neither BindAddress nor Listeners is set")
+	}
+	if config.Group != "" && config.User == "" {
+		return nil, errors.New("Group is set without User, privileges are only dropped to a user")
+	}
+	for _, l := range config.Listeners {
+		if err := l.Init(); err != nil {
+			return nil, err
+		}
+	}
 
-	config.DomainTTLMap = getDomainTTLMap(config.DomainTTLFile)
+	if err := config.QueryLog.Check(); err != nil {
+		return nil, err
+	}
+	if err := config.Dnstap.Check(); err != nil {
+		return nil, err
+	}
+
+	if config.Zones, err = zone.New(config.LocalZones); err != nil {
+		return nil, err
+	}
+
+	config.DomainTTLRules = getDomainTTLRules(config.DomainTTLFile)
 	// configure will load all DNS filter rule
-	for k := range config.DNSFilter {
-		config.DNSFilter[k].DomainList = initDomainMatcher(config.DNSFilter[k].DomainFile, config.DNSFilter[k].Matcher)
-		config.DNSFilter[k].IPNetworkList = getIPNetworkList(config.DNSFilter[k].IPNetworkFile)
+	config.ruleSources = make(map[string]*ruleSource)
+	for k, f := range config.DNSFilter {
+		switch f.BogusIPAction {
+		case "", common.BogusIPNXDomain, common.BogusIPFail:
+		default:
+			return nil, fmt.Errorf("Invalid BogusIPAction of DNSFilter %s: %s", k, f.BogusIPAction)
+		}
+		config.initFilter(k, f)
+	}
+	config.initRewrite()
+	if err := config.initRebinding(); err != nil {
+		return nil, err
+	}
+	if len(config.ruleSources) > 0 && config.RuleRefreshCrontab == "" {
+		config.RuleRefreshCrontab = defaultRuleRefreshCrontab
 	}
 
 	if config.MinimumTTL > 0 {
@@ -80,106 +184,250 @@ func NewConfig(configFile string) *Config {
 		log.Info("Cache is disabled")
 	}
 
-	h, err := hosts.New(config.HostsFile)
+	h, err := hosts.New(config.HostsFile...)
 	if err != nil {
 		log.Warnf("Failed to load hosts file: %s", err)
-	} else {
+	} else if h != nil {
 		config.Hosts = h
 		log.Info("Hosts file has been loaded successfully")
 	}
-	if len(config.DNSBunch) != len(config.DNSFilter) {
-		log.Fatalf("DNSBunch != DNSFilter")
-		os.Exit(1)
+	return config, nil
+}
+
+// warnECS func warn that EDNSClientSubnet policy enable of an upstream has no effect yet
+func warnECS(path string) {
+	log.Warnf("%s.EDNSClientSubnet: policy enable is not applied, queries are sent to upstreams without client subnet", path)
+}
+
+// AllListeners func return Listeners, with BindAddress first as a udp and tcp listener if it is set
+func (c *Config) AllListeners() []*common.Listener {
+	if c.BindAddress == "" {
+		return c.Listeners
+	}
+	l := &common.Listener{Address: c.BindAddress}
+	l.Init()
+	return append([]*common.Listener{l}, c.Listeners...)
+}
+
+// RunAs func return uid of User and gid of Group, the primary group of User is used if Group is empty
+// Numeric IDs are accepted, -1 is returned for a field that is empty. Group without User is refused by LoadConfig.
+func (c *Config) RunAs() (uid int, gid int, err error) {
+	uid, gid = -1, -1
+	if c.User != "" {
+		u, err := user.Lookup(c.User)
+		if err != nil {
+			if u, err = user.LookupId(c.User); err != nil {
+				return -1, -1, fmt.Errorf("Unknown user %s", c.User)
+			}
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+		gid, _ = strconv.Atoi(u.Gid)
+	}
+	if c.Group != "" {
+		g, err := user.LookupGroup(c.Group)
+		if err != nil {
+			if g, err = user.LookupGroupId(c.Group); err != nil {
+				return -1, -1, fmt.Errorf("Unknown group %s", c.Group)
+			}
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return uid, gid, nil
+}
+
+// initFilter func load domain and ip network list of filter, remote lists are preferred to local files
+func (c *Config) initFilter(name string, f *common.Filter) {
+	rs := new(ruleSource)
+
+	if f.DomainURL != "" {
+		rs.domain = subscription.NewSource(f.DomainURL, f.DomainChecksumURL, f.DomainFile)
+		if body, err := rs.domain.Load(); err != nil {
+			log.Errorf("Failed to load domain list of %s: %s", name, err)
+		} else {
+			f.DomainList = loadDomainMatcher(bytes.NewReader(body), f.DomainURL, f.Matcher)
+		}
+	} else {
+		f.DomainList = initDomainMatcher(f.DomainFile, f.Matcher)
+	}
+
+	if f.IPNetworkURL != "" {
+		rs.ipNetwork = subscription.NewSource(f.IPNetworkURL, f.IPNetworkChecksumURL, f.IPNetworkFile)
+		if body, err := rs.ipNetwork.Load(); err != nil {
+			log.Errorf("Failed to load IP network list of %s: %s", name, err)
+		} else {
+			f.IPNetworkList = loadIPNetworkList(bytes.NewReader(body), f.IPNetworkURL)
+		}
+	} else {
+		f.IPNetworkList = getIPNetworkList(f.IPNetworkFile)
+	}
+
+	if f.BogusIPFile != "" {
+		f.BogusIPList = getIPNetworkList(f.BogusIPFile)
+	}
+	if f.AntiPoisoning {
+		log.Infof("Anti-poisoning of %s is enabled (%s window)", name, f.AntiPoisoningTimeout())
+	}
+
+	if rs.domain != nil || rs.ipNetwork != nil {
+		c.ruleSources[name] = rs
 	}
-	return config
 }
 
-func parseJSON(path string) *Config {
-	// parseJSON Read file(json) convert to *Config
-	b, err := ioutil.ReadFile(path)
+// initRebinding func check mode and load allowlist of rebinding protection
+func (c *Config) initRebinding() error {
+	r := c.RebindingProtection
+	if r == nil {
+		return nil
+	}
+	switch r.Mode {
+	case "", common.RebindingOff:
+		return nil
+	case common.RebindingStrip, common.RebindingNXDomain:
+	default:
+		return fmt.Errorf("Invalid RebindingProtection Mode: %s", r.Mode)
+	}
+
+	if r.AllowList = initDomainMatcher(r.AllowFile, "suffix-tree"); r.AllowList == nil {
+		r.AllowList = getDomainMatcher("suffix-tree")
+	}
+	for _, d := range r.AllowDomains {
+		if err := r.AllowList.Insert(d); err != nil {
+			return fmt.Errorf("Invalid RebindingProtection AllowDomains %s: %s", d, err)
+		}
+	}
+	log.Infof("Rebinding protection is enabled (%s)", r.Mode)
+	return nil
+}
+
+// HasRuleSubscription func return true if any DNSFilter uses a remote list
+func (c *Config) HasRuleSubscription() bool {
+	return len(c.ruleSources) > 0
+}
+
+// RefreshRules func fetch all remote lists again and swap the changed ones into their filters
+func (c *Config) RefreshRules() {
+	for name, rs := range c.ruleSources {
+		f := c.DNSFilter[name]
+		if rs.domain != nil {
+			body, err := rs.domain.Fetch()
+			switch {
+			case err == subscription.ErrNotModified:
+				log.Debugf("Domain list of %s is not modified", name)
+			case err != nil:
+				log.Warnf("Failed to refresh domain list of %s: %s", name, err)
+			default:
+				f.SetDomainList(loadDomainMatcher(bytes.NewReader(body), rs.domain.URL, f.Matcher))
+			}
+		}
+		if rs.ipNetwork != nil {
+			body, err := rs.ipNetwork.Fetch()
+			switch {
+			case err == subscription.ErrNotModified:
+				log.Debugf("IP network list of %s is not modified", name)
+			case err != nil:
+				log.Warnf("Failed to refresh IP network list of %s: %s", name, err)
+			default:
+				f.SetIPNetworkList(loadIPNetworkList(bytes.NewReader(body), rs.ipNetwork.URL))
+			}
+		}
+	}
+}
+
+// FileList is decoded from a single path or an array of paths
+type FileList []string
+
+// UnmarshalJSON func accept "file" and ["file1", "file2"]
+func (l *FileList) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*l = nil
+		if s != "" {
+			*l = FileList{s}
+		}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(l))
+}
+
+func parseSource(path string) (*Config, error) {
+	// parseSource Read file(json, yaml or toml) convert to *Config
+	src, err := loadSource(path)
 	if err != nil {
-		log.Fatalf("Failed to read config file: %s", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("Failed to read config file: %s", err)
 	}
 
 	j := new(Config)
-	err = json.Unmarshal(b, j)
-	if err != nil {
-		log.Fatalf("Failed to parse config file: %s", err)
-		os.Exit(1)
+	if err := src.decode(j); err != nil {
+		return nil, fmt.Errorf("Failed to parse config file: %s", err)
 	}
+	j.included = src.included
 
-	return j
+	// unknown fields are often typos, they are reported as errors by -t
+	for _, f := range unknownFields("", src.raw, reflect.TypeOf(Config{})) {
+		log.Warnf("Unknown field in config file %s: %s", path, f)
+	}
+	for _, env := range src.unknownEnv {
+		log.Warnf("Unknown environment variable %s, it matches no top-level setting", env)
+	}
+	return j, nil
 }
 
-func getDomainTTLMap(file string) map[string]uint32 {
+func getDomainTTLRules(file string) *common.TTLRules {
+	rules := new(common.TTLRules)
 	if file == "" {
-		return map[string]uint32{}
+		return rules
 	}
 
 	f, err := os.Open(file)
 	if err != nil {
 		log.Errorf("Failed to open domain TTL file %s: %s", file, err)
-		return nil
+		return rules
 	}
 	defer f.Close()
 
 	successes := 0
 	failures := 0
-	var failedLines []string
-
-	dtl := map[string]uint32{}
+	lineNumber := 0
 
 	reader := bufio.NewReader(f)
 
 	for {
 		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err != io.EOF {
-				log.Errorf("Failed to read domain TTL file %s: %s", file, err)
-			} else {
-				log.Debugf("Reading domain TTL file %s reached EOF", file)
-			}
+		if err != nil && err != io.EOF {
+			log.Errorf("Failed to read domain TTL file %s: %s", file, err)
 			break
 		}
+		lineNumber++
 
-		if line != "" {
-			words := strings.Fields(line)
-			if len(words) > 1 {
-				tempInt64, err := strconv.ParseUint(words[1], 10, 32)
-				dtl[words[0]] = uint32(tempInt64)
-				if err != nil {
-					log.WithFields(log.Fields{"domain": words[0], "ttl": words[1]}).Warnf("Invalid TTL for domain %s: %s", words[0], words[1])
-					failures++
-					failedLines = append(failedLines, line)
-				}
-				successes++
-			} else {
-				failedLines = append(failedLines, line)
+		if words := strings.Fields(line); len(words) > 1 {
+			ttl, perr := strconv.ParseUint(words[1], 10, 32)
+			if perr == nil {
+				perr = rules.Add(words[0], uint32(ttl))
+			}
+			if perr != nil {
+				log.WithFields(log.Fields{"domain": words[0], "ttl": words[1]}).Warnf("Invalid rule in domain TTL file %s line %d: %s", file, lineNumber, perr)
 				failures++
+			} else {
+				successes++
 			}
+		} else if len(words) == 1 {
+			log.Warnf("Invalid rule in domain TTL file %s line %d: TTL is missing", file, lineNumber)
+			failures++
+		}
+
+		if err == io.EOF {
+			log.Debugf("Reading domain TTL file %s reached EOF", file)
+			break
 		}
 	}
 
-	if len(dtl) > 0 {
+	if successes > 0 {
 		log.Infof("Domain TTL file %s has been loaded with %d records (%d failed)", file, successes, failures)
-		if len(failedLines) > 0 {
-			log.Debugf("Failed lines (%s):", file)
-			for _, line := range failedLines {
-				log.Debug(line)
-			}
-		}
 	} else {
 		log.Warnf("No element has been loaded from domain TTL file: %s", file)
-		if len(failedLines) > 0 {
-			log.Debugf("Failed lines (%s):", file)
-			for _, line := range failedLines {
-				log.Debug(line)
-			}
-		}
 	}
 
-	return dtl
+	return rules
 }
 
 func getDomainMatcher(name string) (m matcher.Matcher) {
@@ -201,10 +449,8 @@ func getDomainMatcher(name string) (m matcher.Matcher) {
 }
 
 func initDomainMatcher(file string, name string) (m matcher.Matcher) {
-	m = getDomainMatcher(name)
-
 	if file == "" {
-		return
+		return getDomainMatcher(name)
 	}
 
 	f, err := os.Open(file)
@@ -214,23 +460,34 @@ func initDomainMatcher(file string, name string) (m matcher.Matcher) {
 	}
 	defer f.Close()
 
+	return loadDomainMatcher(f, file, name)
+}
+
+func loadDomainMatcher(r io.Reader, file string, name string) (m matcher.Matcher) {
+	m = getDomainMatcher(name)
+
 	lines := 0
-	reader := bufio.NewReader(f)
+	lineNumber := 0
+	reader := bufio.NewReader(r)
 
 	for {
 		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err != io.EOF {
-				log.Errorf("Failed to read domain file %s: %s", file, err)
-			} else {
-				log.Debugf("Reading domain file %s reached EOF", file)
-				break
-			}
+		if err != nil && err != io.EOF {
+			log.Errorf("Failed to read domain file %s: %s", file, err)
+			break
 		}
+		lineNumber++
 		line = strings.TrimSpace(line)
 		if line != "" {
-			_ = m.Insert(line)
-			lines++
+			if ierr := m.Insert(line); ierr != nil {
+				log.Warnf("Invalid rule in domain file %s line %d: %s", file, lineNumber, ierr)
+			} else {
+				lines++
+			}
+		}
+		if err == io.EOF {
+			log.Debugf("Reading domain file %s reached EOF", file)
+			break
 		}
 	}
 
@@ -243,9 +500,7 @@ func initDomainMatcher(file string, name string) (m matcher.Matcher) {
 	return
 }
 
-func getIPNetworkList(file string) []*net.IPNet {
-	ipNetList := make([]*net.IPNet, 0)
-
+func getIPNetworkList(file string) *iptrie.Trie {
 	f, err := os.Open(file)
 	if err != nil {
 		log.Errorf("Failed to open IP network file: %s", err)
@@ -253,36 +508,41 @@ func getIPNetworkList(file string) []*net.IPNet {
 	}
 	defer f.Close()
 
+	return loadIPNetworkList(f, file)
+}
+
+func loadIPNetworkList(r io.Reader, file string) *iptrie.Trie {
+	ipNetList := iptrie.New()
+
 	successes := 0
 	failures := 0
 	var failedLines []string
 
-	reader := bufio.NewReader(f)
+	reader := bufio.NewReader(r)
 	for {
 		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err != io.EOF {
-				log.Errorf("Failed to read IP network file %s: %s", file, err)
-			} else {
-				log.Debugf("Reading IP network file %s has reached EOF", file)
-			}
+		if err != nil && err != io.EOF {
+			log.Errorf("Failed to read IP network file %s: %s", file, err)
 			break
 		}
 
-		if line != "" {
-			_, ipNet, err := net.ParseCIDR(strings.TrimSuffix(line, "\n"))
-			if err != nil {
-				log.Errorf("Error parsing IP network CIDR %s: %s", line, err)
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			// CIDR, single address and range are accepted
+			if perr := ipNetList.Insert(line); perr != nil {
+				log.Errorf("Error parsing IP network %s: %s", line, perr)
 				failures++
 				failedLines = append(failedLines, line)
-				continue
+			} else {
+				successes++
 			}
-			ipNetList = append(ipNetList, ipNet)
-			successes++
+		}
+		if err == io.EOF {
+			log.Debugf("Reading IP network file %s has reached EOF", file)
+			break
 		}
 	}
 
-	if len(ipNetList) > 0 {
+	if ipNetList.Len() > 0 {
 		log.Infof("IP network file %s has been loaded with %d records", file, successes)
 		if failures > 0 {
 			log.Debugf("Failed lines (%s):", file)
@@ -0,0 +1,173 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/import-yuefeng/smartDNS/core/common"
+)
+
+// Diff func describe what changed between two configs, one line for each change
+func Diff(old *Config, new *Config) (changes []string) {
+	field := func(name string, a, b interface{}, note string) {
+		if !reflect.DeepEqual(a, b) {
+			changes = append(changes, fmt.Sprintf("%s: %v -> %v%s", name, a, b, note))
+		}
+	}
+	const restart = " (requires restart)"
+
+	field("BindAddress", old.BindAddress, new.BindAddress, restart)
+	if !reflect.DeepEqual(listenerKeys(old.Listeners), listenerKeys(new.Listeners)) {
+		changes = append(changes, "Listeners: changed"+restart)
+	}
+	field("DebugHTTPAddress", old.DebugHTTPAddress, new.DebugHTTPAddress, restart)
+	field("User", old.User, new.User, restart)
+	field("Group", old.Group, new.Group, restart)
+	field("CacheSize", old.CacheSize, new.CacheSize, restart)
+	field("CacheCrontab", old.CacheCrontab, new.CacheCrontab, restart)
+	if old.AdminToken != new.AdminToken {
+		changes = append(changes, "AdminToken: changed")
+	}
+	field("DefaultDNSBundle", old.DefaultDNSBundle, new.DefaultDNSBundle, "")
+	field("PrivateReverseBundle", old.PrivateReverseBundle, new.PrivateReverseBundle, "")
+	field("IPv6UseAlternativeDNS", old.IPv6UseAlternativeDNS, new.IPv6UseAlternativeDNS, "")
+	field("MinimumTTL", old.MinimumTTL, new.MinimumTTL, "")
+	field("HostsFile", old.HostsFile, new.HostsFile, "")
+	field("DomainTTLFile", old.DomainTTLFile, new.DomainTTLFile, "")
+	field("RuleRefreshCrontab", old.RuleRefreshCrontab, new.RuleRefreshCrontab, "")
+	field("RejectQType", old.RejectQType, new.RejectQType, "")
+	field("LocalZones", old.LocalZones, new.LocalZones, "")
+	field("QueryLog", old.QueryLog, new.QueryLog, "")
+	field("Dnstap", old.Dnstap, new.Dnstap, "")
+	if old.DomainTTLRules.Len() != new.DomainTTLRules.Len() {
+		changes = append(changes, fmt.Sprintf("DomainTTLRules: %d -> %d records", old.DomainTTLRules.Len(), new.DomainTTLRules.Len()))
+	}
+
+	var oldRewrite, newRewrite common.Rewrite
+	if old.Rewrite != nil {
+		oldRewrite = *old.Rewrite
+	}
+	if new.Rewrite != nil {
+		newRewrite = *new.Rewrite
+	}
+	var oldRebinding, newRebinding common.Rebinding
+	if old.RebindingProtection != nil {
+		oldRebinding = *old.RebindingProtection
+	}
+	if new.RebindingProtection != nil {
+		newRebinding = *new.RebindingProtection
+	}
+	field("RebindingProtection Mode", oldRebinding.Mode, newRebinding.Mode, "")
+	field("RebindingProtection AllowDomains", oldRebinding.AllowDomains, newRebinding.AllowDomains, "")
+	field("RebindingProtection AllowFile", oldRebinding.AllowFile, newRebinding.AllowFile, "")
+
+	field("Rewrite Finder", oldRewrite.Finder, newRewrite.Finder, "")
+	field("Rewrite AddressFile", oldRewrite.AddressFile, newRewrite.AddressFile, "")
+	field("Rewrite CNAMEFile", oldRewrite.CNAMEFile, newRewrite.CNAMEFile, "")
+	field("Rewrite BundleFile", oldRewrite.BundleFile, newRewrite.BundleFile, "")
+
+	for _, name := range unionKeys(old.DNSBunch, new.DNSBunch) {
+		o, inOld := old.DNSBunch[name]
+		n, inNew := new.DNSBunch[name]
+		switch {
+		case !inOld:
+			changes = append(changes, fmt.Sprintf("DNSBunch %s: added with %d upstreams", name, len(n)))
+		case !inNew:
+			changes = append(changes, fmt.Sprintf("DNSBunch %s: removed", name))
+		case !reflect.DeepEqual(o, n):
+			changes = append(changes, fmt.Sprintf("DNSBunch %s: upstreams changed", name))
+		}
+	}
+
+	for _, name := range unionKeys(old.DNSFilter, new.DNSFilter) {
+		o, inOld := old.DNSFilter[name]
+		n, inNew := new.DNSFilter[name]
+		switch {
+		case !inOld:
+			changes = append(changes, fmt.Sprintf("DNSFilter %s: added", name))
+		case !inNew:
+			changes = append(changes, fmt.Sprintf("DNSFilter %s: removed", name))
+		default:
+			field("DNSFilter "+name+" Matcher", o.Matcher, n.Matcher, "")
+			field("DNSFilter "+name+" DomainFile", o.DomainFile, n.DomainFile, "")
+			field("DNSFilter "+name+" DomainURL", o.DomainURL, n.DomainURL, "")
+			field("DNSFilter "+name+" IPNetworkFile", o.IPNetworkFile, n.IPNetworkFile, "")
+			field("DNSFilter "+name+" IPNetworkURL", o.IPNetworkURL, n.IPNetworkURL, "")
+			field("DNSFilter "+name+" BogusIPFile", o.BogusIPFile, n.BogusIPFile, "")
+			field("DNSFilter "+name+" BogusIPAction", o.BogusIPAction, n.BogusIPAction, "")
+			field("DNSFilter "+name+" AntiPoisoning", o.AntiPoisoning, n.AntiPoisoning, "")
+			field("DNSFilter "+name+" AntiPoisoningWindow", o.AntiPoisoningWindow, n.AntiPoisoningWindow, "")
+		}
+	}
+	return
+}
+
+// unionKeys func return sorted keys of two maps with string key
+func unionKeys(a interface{}, b interface{}) []string {
+	set := make(map[string]struct{})
+	for _, m := range []interface{}{a, b} {
+		for _, k := range reflect.ValueOf(m).MapKeys() {
+			set[k.String()] = struct{}{}
+		}
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WatchFiles func return local files that the config depends on, include itself
+// Files that are fallback copies of subscriptions are excluded, they are rewritten by refresh.
+func (c *Config) WatchFiles(configFile string) (files []string) {
+	add := func(f string) {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	add(configFile)
+	for _, f := range c.included {
+		add(f)
+	}
+	for _, f := range c.HostsFile {
+		add(f)
+	}
+	add(c.DomainTTLFile)
+	if c.RebindingProtection != nil {
+		add(c.RebindingProtection.AllowFile)
+	}
+	for _, z := range c.LocalZones {
+		add(z.File)
+	}
+	if c.Rewrite != nil {
+		add(c.Rewrite.AddressFile)
+		add(c.Rewrite.CNAMEFile)
+		add(c.Rewrite.BundleFile)
+	}
+	for _, f := range c.DNSFilter {
+		if f.DomainURL == "" {
+			add(f.DomainFile)
+		}
+		if f.IPNetworkURL == "" {
+			add(f.IPNetworkFile)
+		}
+		add(f.BogusIPFile)
+	}
+	return
+}
+
+// listenerKeys func return the settings of listeners, parsed ACL is left out
+func listenerKeys(listeners []*common.Listener) (keys []string) {
+	for _, l := range listeners {
+		keys = append(keys, fmt.Sprintf("%s %s %v %s %s %s %s %v %v", l.Name, l.Address, l.Protocols, l.CertFile, l.KeyFile, l.Path, l.Group, l.ACL, l.RejectQType))
+	}
+	return
+}
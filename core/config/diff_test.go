@@ -0,0 +1,80 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package config
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/import-yuefeng/smartDNS/core/common"
+	"github.com/import-yuefeng/smartDNS/core/zone"
+)
+
+func TestDiff(t *testing.T) {
+	old := &Config{
+		BindAddress:      ":53",
+		DefaultDNSBundle: "A",
+		AdminToken:       "secret",
+		DNSBunch: map[string][]*common.DNSUpstream{
+			"A": {{Name: "a1", Address: "1.1.1.1:53"}},
+			"B": {{Name: "b1", Address: "8.8.8.8:53"}},
+		},
+		DNSFilter: map[string]*common.Filter{"A": {Matcher: "suffix-tree"}, "B": {}},
+	}
+	if changes := Diff(old, old); len(changes) != 0 {
+		t.Errorf("same config has changes %q", changes)
+	}
+
+	new := &Config{
+		BindAddress:         ":5353",
+		DefaultDNSBundle:    "A",
+		AdminToken:          "changed",
+		RebindingProtection: &common.Rebinding{Mode: "strip"},
+		DNSBunch: map[string][]*common.DNSUpstream{
+			"A": {{Name: "a1", Address: "1.0.0.1:53"}},
+			"C": {{Name: "c1", Address: "9.9.9.9:53"}},
+		},
+		DNSFilter: map[string]*common.Filter{"A": {Matcher: "full-map"}, "C": {}},
+	}
+	want := []string{
+		"BindAddress: :53 -> :5353 (requires restart)",
+		"AdminToken: changed",
+		"RebindingProtection Mode:  -> strip",
+		"DNSBunch A: upstreams changed",
+		"DNSBunch B: removed",
+		"DNSBunch C: added with 1 upstreams",
+		"DNSFilter A Matcher: suffix-tree -> full-map",
+		"DNSFilter B: removed",
+		"DNSFilter C: added",
+	}
+	if got := Diff(old, new); !reflect.DeepEqual(got, want) {
+		t.Errorf("got changes:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestConfig_WatchFiles(t *testing.T) {
+	c := &Config{
+		HostsFile:           FileList{"/etc/hosts"},
+		included:            []string{"conf.d/a.json"},
+		DomainTTLFile:       "ttl.txt",
+		RebindingProtection: &common.Rebinding{AllowFile: "allow.txt"},
+		LocalZones:          []*zone.Config{{Origin: "lan.", File: "lan.zone"}},
+		Rewrite:             &common.Rewrite{CNAMEFile: "cname.txt"},
+		DNSFilter: map[string]*common.Filter{
+			"A": {DomainFile: "a.domain", IPNetworkFile: "a.ip", BogusIPFile: "bogus.txt"},
+			// fallback copies of subscriptions are rewritten by refresh
+			"B": {DomainURL: "https://example.com/b.domain", DomainFile: "b.domain", IPNetworkURL: "https://example.com/b.ip", IPNetworkFile: "b.ip"},
+		},
+	}
+	got := c.WatchFiles("config.json")
+	sort.Strings(got)
+	want := []string{"/etc/hosts", "a.domain", "a.ip", "allow.txt", "bogus.txt", "cname.txt", "conf.d/a.json", "config.json", "lan.zone", "ttl.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,159 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/import-yuefeng/smartDNS/core/finder"
+	"github.com/import-yuefeng/smartDNS/core/finder/full"
+	"github.com/import-yuefeng/smartDNS/core/finder/regex"
+	"github.com/import-yuefeng/smartDNS/core/finder/suffix"
+	"github.com/import-yuefeng/smartDNS/core/rule"
+)
+
+// initRewrite func load address, CNAME and bundle rewrite files
+func (c *Config) initRewrite() {
+	r := c.Rewrite
+	if r == nil {
+		return
+	}
+
+	r.AddressList = initRewriteFinder(r.AddressFile, r.Finder, "address", checkAddress)
+	r.CNAMEList = initRewriteFinder(r.CNAMEFile, r.Finder, "cname", checkCNAME)
+	r.BundleList = initRewriteFinder(r.BundleFile, r.Finder, "bundle", func(v string) (string, error) {
+		if _, ok := c.DNSBunch[v]; !ok {
+			return "", fmt.Errorf("DNSBunch %s does not exist", v)
+		}
+		return v, nil
+	})
+}
+
+func getDomainFinder(name string) finder.Finder {
+	switch name {
+	case "", "suffix-tree":
+		return suffix.New()
+	case "full-map":
+		return &full.Map{DataMap: make(map[string]string, 100)}
+	case "regex-list":
+		return &regex.List{}
+	default:
+		log.Warnf("Finder %s does not exist, using suffix-tree finder as default", name)
+		return suffix.New()
+	}
+}
+
+// initRewriteFinder func load rewrite file, every line is "domain value" or dnsmasq style "key=/domain/.../value",
+// value is checked and normalized by check before it is inserted
+func initRewriteFinder(file string, name string, key string, check func(string) (string, error)) finder.Finder {
+	if file == "" {
+		return nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		log.Errorf("Failed to open rewrite file %s: %s", file, err)
+		return nil
+	}
+	defer f.Close()
+
+	fd := getDomainFinder(name)
+	successes := 0
+	lineNumber := 0
+	reader := bufio.NewReader(f)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			log.Errorf("Failed to read rewrite file %s: %s", file, err)
+			break
+		}
+		lineNumber++
+
+		domains, value, perr := parseRewriteLine(line, key)
+		if perr == nil && len(domains) > 0 {
+			value, perr = check(value)
+		}
+		for i := 0; perr == nil && i < len(domains); i++ {
+			perr = fd.Insert(domains[i], value)
+		}
+		if perr != nil {
+			log.Warnf("Invalid rule in rewrite file %s line %d: %s", file, lineNumber, perr)
+		} else if len(domains) > 0 {
+			successes++
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	log.Infof("Rewrite file %s has been loaded with %d records (%s)", file, successes, fd.Name())
+	return fd
+}
+
+// parseRewriteLine func split line into domains and value, empty and comment lines return no domain
+func parseRewriteLine(line string, key string) (domains []string, value string, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, "", nil
+	}
+
+	if strings.HasPrefix(line, key+"=/") {
+		// dnsmasq: address=/example.com/example.net/1.2.3.4
+		parts := strings.Split(line[len(key)+2:], "/")
+		if len(parts) < 2 || parts[len(parts)-1] == "" {
+			return nil, "", fmt.Errorf("value is missing: %s", line)
+		}
+		for _, d := range parts[:len(parts)-1] {
+			if d != "" {
+				domains = append(domains, d)
+			}
+		}
+		if len(domains) == 0 {
+			return nil, "", fmt.Errorf("domain is missing: %s", line)
+		}
+		return domains, parts[len(parts)-1], nil
+	}
+
+	words := strings.Fields(line)
+	if len(words) < 2 {
+		return nil, "", fmt.Errorf("value is missing: %s", line)
+	}
+	return words[:1], strings.Join(words[1:], ","), nil
+}
+
+// checkAddress func validate comma separated IPs
+func checkAddress(v string) (string, error) {
+	var ips []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return "", fmt.Errorf("invalid IP address: %s", s)
+		}
+		ips = append(ips, ip.String())
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("IP address is missing")
+	}
+	return strings.Join(ips, ","), nil
+}
+
+// checkCNAME func normalize CNAME target
+func checkCNAME(v string) (string, error) {
+	return rule.NormalizeDomain(v)
+}
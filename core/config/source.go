@@ -0,0 +1,303 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// envPrefix is prefix of environment variables that override top-level settings, like SMARTDNS_CACHE_SIZE
+const envPrefix = "SMARTDNS_"
+
+// includeKeys are the only keys allowed in included files
+var includeKeys = []string{"DNSBunch", "DNSFilter"}
+
+// source is config file decoded into its JSON form, with included files merged and environment applied
+type source struct {
+	raw map[string]interface{}
+	// included are files merged into raw
+	included []string
+	// unknownEnv are SMARTDNS_ variables that match no top-level setting
+	unknownEnv []string
+}
+
+// loadSource func read config file and apply Include and environment overrides
+// JSON, YAML (.yaml, .yml) and TOML (.toml) are chosen by file extension.
+func loadSource(path string) (*source, error) {
+	raw, err := readSource(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &source{raw: raw}
+	if err := s.include(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	if err := s.applyEnv(os.Environ()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// decode func decode source into config
+func (s *source) decode(c *Config) error {
+	b, err := json.Marshal(s.raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, c)
+}
+
+// readSource func decode one file into map, YAML and TOML values are converted to their JSON form
+func readSource(path string) (map[string]interface{}, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		v = fromYAML(v)
+	case ".toml":
+		var m map[string]interface{}
+		if _, err := toml.Decode(string(b), &m); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		v = m
+	default:
+		if err := json.Unmarshal(b, &v); err != nil {
+			if serr, ok := err.(*json.SyntaxError); ok {
+				return nil, fmt.Errorf("%s: line %d: %s", path, bytes.Count(b[:serr.Offset], []byte("\n"))+1, err)
+			}
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		if v == nil {
+			// empty file
+			return make(map[string]interface{}), nil
+		}
+		return nil, fmt.Errorf("%s: top level must be a mapping of settings", path)
+	}
+	return m, nil
+}
+
+// fromYAML func convert maps decoded by yaml to map[string]interface{}, so they can be encoded as JSON
+func fromYAML(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = fromYAML(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = fromYAML(e)
+		}
+	}
+	return v
+}
+
+// include func merge DNSBunch and DNSFilter of files listed by Include, relative paths are resolved from dir
+// An entry of Include is a file, a glob pattern or a directory whose .json, .yaml, .yml and .toml files are used.
+func (s *source) include(dir string) error {
+	key, ok := findKey(s.raw, "Include")
+	if !ok {
+		return nil
+	}
+	var patterns []string
+	switch v := s.raw[key].(type) {
+	case string:
+		patterns = []string{v}
+	case []interface{}:
+		for _, e := range v {
+			p, ok := e.(string)
+			if !ok {
+				return fmt.Errorf("Include must be a path or a list of paths")
+			}
+			patterns = append(patterns, p)
+		}
+	case nil:
+	default:
+		return fmt.Errorf("Include must be a path or a list of paths")
+	}
+
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		files, err := includedFiles(pattern)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if err := s.merge(f); err != nil {
+				return err
+			}
+			s.included = append(s.included, f)
+		}
+	}
+	return nil
+}
+
+// includedFiles func return sorted config files of pattern
+func includedFiles(pattern string) ([]string, error) {
+	if fi, err := os.Stat(pattern); err == nil && fi.IsDir() {
+		var files []string
+		for _, ext := range []string{"*.json", "*.yaml", "*.yml", "*.toml"} {
+			matches, _ := filepath.Glob(filepath.Join(pattern, ext))
+			files = append(files, matches...)
+		}
+		sort.Strings(files)
+		return files, nil
+	}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Include %s: %s", pattern, err)
+	}
+	if len(files) == 0 && !strings.ContainsAny(pattern, "*?[") {
+		return nil, fmt.Errorf("Include %s does not exist", pattern)
+	}
+	return files, nil
+}
+
+// merge func add bundles and filters of file, a name defined twice is an error
+func (s *source) merge(file string) error {
+	frag, err := readSource(file)
+	if err != nil {
+		return err
+	}
+	for _, k := range sortedKeys(frag) {
+		name := ""
+		for _, allowed := range includeKeys {
+			if strings.EqualFold(k, allowed) {
+				name = allowed
+			}
+		}
+		if name == "" {
+			return fmt.Errorf("%s: only %s are allowed in included files, found %s", file, strings.Join(includeKeys, " and "), k)
+		}
+		items, ok := frag[k].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %s must be a mapping", file, k)
+		}
+
+		key, ok := findKey(s.raw, name)
+		if !ok {
+			key = name
+			s.raw[key] = make(map[string]interface{})
+		}
+		target, ok := s.raw[key].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be a mapping", name)
+		}
+		for _, item := range sortedKeys(items) {
+			if _, ok := target[item]; ok {
+				return fmt.Errorf("%s: %s %s is already defined", file, name, item)
+			}
+			target[item] = items[item]
+		}
+	}
+	return nil
+}
+
+// applyEnv func set top-level settings from SMARTDNS_ variables of environ
+// Underscores and case are ignored, so SMARTDNS_CACHE_SIZE and SMARTDNS_CacheSize both set CacheSize.
+// Lists like RejectQType and HostsFile are separated by comma.
+func (s *source) applyEnv(environ []string) error {
+	t := reflect.TypeOf(Config{})
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, envPrefix) {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			continue
+		}
+		env, value := kv[:i], kv[i+1:]
+
+		field, ok := jsonField(t, strings.Replace(env[len(envPrefix):], "_", "", -1))
+		if !ok {
+			s.unknownEnv = append(s.unknownEnv, env)
+			continue
+		}
+		v, err := envValue(field.Type, value)
+		if err != nil {
+			return fmt.Errorf("Invalid %s: %s", env, err)
+		}
+		for k := range s.raw {
+			if strings.EqualFold(k, field.Name) {
+				delete(s.raw, k)
+			}
+		}
+		s.raw[field.Name] = v
+	}
+	sort.Strings(s.unknownEnv)
+	return nil
+}
+
+// envValue func convert value of environment variable to JSON form of type t
+func envValue(t reflect.Type, value string) (interface{}, error) {
+	switch t.Kind() {
+	case reflect.String:
+		return value, nil
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		return strconv.Atoi(value)
+	case reflect.Bool:
+		return strconv.ParseBool(value)
+	case reflect.Slice:
+		var list []interface{}
+		for _, e := range strings.Split(value, ",") {
+			if e = strings.TrimSpace(e); e == "" {
+				continue
+			}
+			ev, err := envValue(t.Elem(), e)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, ev)
+		}
+		return list, nil
+	case reflect.Uint16:
+		return strconv.ParseUint(value, 10, 16)
+	}
+	return nil, fmt.Errorf("%s can't be set by environment variable", t)
+}
+
+// findKey func return key of m that equals name case-insensitively, like encoding/json matches fields
+func findKey(m map[string]interface{}, name string) (string, bool) {
+	if _, ok := m[name]; ok {
+		return name, true
+	}
+	for k := range m {
+		if strings.EqualFold(k, name) {
+			return k, true
+		}
+	}
+	return "", false
+}
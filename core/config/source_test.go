@@ -0,0 +1,143 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"config.yaml": `
+BindAddress: ":53"
+CacheSize: 100
+RejectQType: [255]
+Include: conf.d
+DNSBunch:
+  A:
+    - Name: a1
+      Address: 1.1.1.1:53
+      Protocol: udp
+      Timeout: 3
+DNSFilter:
+  A:
+    Matcher: suffix-tree
+`,
+		"conf.d/b.toml": `
+[[DNSBunch.B]]
+Name = "b1"
+Address = "8.8.8.8:53"
+Protocol = "tcp"
+Timeout = 5
+
+[DNSFilter.B]
+Matcher = "full-map"
+`,
+		"conf.d/c.json":     `{"DNSBunch": {"C": []}, "dnsfilter": {"C": {}}}`,
+		"conf.d/readme.txt": "ignored",
+	})
+
+	os.Setenv("SMARTDNS_BIND_ADDRESS", "127.0.0.1:5353")
+	os.Setenv("SMARTDNS_CACHESIZE", "42")
+	os.Setenv("SMARTDNS_REJECT_QTYPE", "28, 255")
+	os.Setenv("SMARTDNS_NO_SUCH_SETTING", "1")
+	defer func() {
+		for _, env := range []string{"SMARTDNS_BIND_ADDRESS", "SMARTDNS_CACHESIZE", "SMARTDNS_REJECT_QTYPE", "SMARTDNS_NO_SUCH_SETTING"} {
+			os.Unsetenv(env)
+		}
+	}()
+
+	src, err := loadSource(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := new(Config)
+	if err := src.decode(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.BindAddress != "127.0.0.1:5353" || c.CacheSize != 42 || !reflect.DeepEqual(c.RejectQType, []uint16{28, 255}) {
+		t.Errorf("environment not applied: %q %d %v", c.BindAddress, c.CacheSize, c.RejectQType)
+	}
+	if len(c.DNSBunch) != 3 || len(c.DNSFilter) != 3 {
+		t.Fatalf("got %d bundles and %d filters, want 3", len(c.DNSBunch), len(c.DNSFilter))
+	}
+	if b := c.DNSBunch["B"]; len(b) != 1 || b[0].Address != "8.8.8.8:53" || b[0].Timeout != 5 {
+		t.Errorf("bundle B of TOML fragment: %+v", b)
+	}
+	if c.DNSFilter["B"].Matcher != "full-map" {
+		t.Errorf("filter B matcher %q", c.DNSFilter["B"].Matcher)
+	}
+	wantIncluded := []string{filepath.Join(dir, "conf.d/b.toml"), filepath.Join(dir, "conf.d/c.json")}
+	if !reflect.DeepEqual(src.included, wantIncluded) {
+		t.Errorf("included %q, want %q", src.included, wantIncluded)
+	}
+	if !reflect.DeepEqual(src.unknownEnv, []string{"SMARTDNS_NO_SUCH_SETTING"}) {
+		t.Errorf("unknown environment %q", src.unknownEnv)
+	}
+	if f := unknownFields("", src.raw, reflect.TypeOf(Config{})); len(f) != 0 {
+		t.Errorf("unexpected unknown fields %q", f)
+	}
+}
+
+func TestLoadSource_Errors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"dup.json":       `{"Include": "dup.d", "DNSBunch": {"A": []}}`,
+		"dup.d/a.yaml":   "DNSBunch:\n  A: []\n",
+		"other.json":     `{"Include": ["other.d/*.toml"]}`,
+		"other.d/x.toml": `BindAddress = ":53"`,
+		"missing.json":   `{"Include": "missing.d"}`,
+		"syntax.json":    "{\n  \"BindAddress\": \":53\",\n}",
+		"env.json":       `{}`,
+	})
+
+	os.Setenv("SMARTDNS_CACHE_SIZE", "many")
+	_, err = loadSource(filepath.Join(dir, "env.json"))
+	os.Unsetenv("SMARTDNS_CACHE_SIZE")
+	if err == nil || !strings.Contains(err.Error(), "SMARTDNS_CACHE_SIZE") {
+		t.Errorf("invalid environment: got %v", err)
+	}
+
+	for file, want := range map[string]string{
+		"dup.json":     "DNSBunch A is already defined",
+		"other.json":   "only DNSBunch and DNSFilter are allowed in included files, found BindAddress",
+		"missing.json": "does not exist",
+		"syntax.json":  "line 3: invalid character",
+	} {
+		_, err := loadSource(filepath.Join(dir, file))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got error %v, want %q", file, err, want)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package cron
 
 import (
+	"sync"
+	"sync/atomic"
+
 	"github.com/import-yuefeng/smartDNS/core/cache"
 	"github.com/miekg/dns"
+	"github.com/robfig/cron"
 )
 
 type Task struct {
@@ -13,5 +17,26 @@ type CacheManager struct {
 	TaskChan chan bool
 	Cache    *cache.Cache
 	Interval string
-	TaskSum  int
+	// TaskSum is changed by concurrent queries, use atomic functions or Tasks to access it
+	TaskSum int32
+
+	cron     *cron.Cron
+	quit     chan struct{}
+	stopOnce sync.Once
+	tasks    sync.WaitGroup
+}
+
+// NewCacheManager func create new CacheManager struct object
+func NewCacheManager(c *cache.Cache, interval string) *CacheManager {
+	return &CacheManager{
+		TaskChan: make(chan bool, 1000),
+		Cache:    c,
+		Interval: interval,
+		quit:     make(chan struct{}),
+	}
+}
+
+// Tasks func return the number of pending cache update tasks
+func (worker *CacheManager) Tasks() int {
+	return int(atomic.LoadInt32(&worker.TaskSum))
 }
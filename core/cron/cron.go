@@ -7,6 +7,8 @@
 package cron
 
 import (
+	"context"
+
 	"github.com/robfig/cron"
 	log "github.com/sirupsen/logrus"
 
@@ -44,14 +46,37 @@ func (cacheManager *CacheManager) Sort(updateElemNum int) *list.List {
 	return updateList
 }
 
-func (cacheManager *CacheManager) Crontab() {
-	// i := 0
+// Crontab func start the cache update schedule, it returns error if Interval is not a valid spec
+func (cacheManager *CacheManager) Crontab() error {
 	c := cron.New()
 	spec := cacheManager.Interval
 
-	c.AddFunc(spec, cacheManager.AutoUpdate)
+	if err := c.AddFunc(spec, cacheManager.AutoUpdate); err != nil {
+		return err
+	}
 	c.Start()
+	cacheManager.cron = c
+	return nil
+}
 
-	select {}
+// Stop func stop cron, Handle and all pending timer tasks, it waits for running tasks until ctx is done
+func (cacheManager *CacheManager) Stop(ctx context.Context) error {
+	cacheManager.stopOnce.Do(func() {
+		close(cacheManager.quit)
+	})
+	if cacheManager.cron != nil {
+		cacheManager.cron.Stop()
+	}
 
+	done := make(chan struct{})
+	go func() {
+		cacheManager.tasks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
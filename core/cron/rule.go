@@ -0,0 +1,39 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cron
+
+import (
+	"github.com/robfig/cron"
+	log "github.com/sirupsen/logrus"
+)
+
+// RuleUpdater refresh subscribed rule lists periodically
+type RuleUpdater struct {
+	Interval string
+	Refresh  func()
+
+	cron *cron.Cron
+}
+
+// Crontab func start the refresh schedule, it returns error if Interval is not a valid spec
+func (updater *RuleUpdater) Crontab() error {
+	c := cron.New()
+	if err := c.AddFunc(updater.Interval, updater.Refresh); err != nil {
+		return err
+	}
+	c.Start()
+	updater.cron = c
+	log.Infof("Rule lists will be refreshed by crontab: %s", updater.Interval)
+	return nil
+}
+
+// Stop func stop the refresh schedule, a running refresh is not interrupted
+func (updater *RuleUpdater) Stop() {
+	if updater.cron != nil {
+		updater.cron.Stop()
+	}
+}
@@ -8,33 +8,52 @@ package cron
 
 import (
 	"math/rand"
+	"sync/atomic"
 	"time"
 
 	"github.com/import-yuefeng/smartDNS/core/cache"
 	"github.com/import-yuefeng/smartDNS/core/detector/ping"
+	"github.com/import-yuefeng/smartDNS/core/metrics"
 	"github.com/import-yuefeng/smartDNS/core/outbound/clients"
 	log "github.com/sirupsen/logrus"
 )
 
 func (worker *CacheManager) AddTask(expiration uint32, cacheMessage *clients.CacheMessage, fastMap *cache.FastMap, bundle map[string]*clients.RemoteClientBundle) {
-	if worker.TaskSum >= int(worker.Cache.Capacity()*2) {
-		log.Infof("Too many tasks! Task: %d\n", worker.TaskSum)
+	select {
+	case <-worker.quit:
+		return
+	default:
+	}
+	if n := atomic.AddInt32(&worker.TaskSum, 1); int(n) > worker.Cache.Capacity()*2 {
+		atomic.AddInt32(&worker.TaskSum, -1)
+		log.Infof("Too many tasks! Task: %d\n", n-1)
 		return
 	}
-	worker.TaskSum++
 	if expiration < 30 {
 		// Set minimum update time
 		rand.Seed(time.Now().Unix())
 		expiration += uint32(rand.Intn(100))
 	}
 	newTimer := time.NewTimer(time.Second * time.Duration(expiration))
+	worker.tasks.Add(1)
 	go func() {
-		<-newTimer.C
-		worker.TaskChan <- true
+		defer worker.tasks.Done()
+		select {
+		case <-newTimer.C:
+		case <-worker.quit:
+			newTimer.Stop()
+			return
+		}
+		select {
+		case worker.TaskChan <- true:
+		case <-worker.quit:
+			return
+		}
 		key := cache.Key(cacheMessage.ResponseMessage.Question[0])
 		if _, _, ok := worker.Cache.Search(key); !ok {
 			return
 		}
+		metrics.DetectorRuns.Inc()
 		TaskDetector := ping.NewDetector(cacheMessage.ResponseMessage, fastMap, bundle)
 		fastMapList := TaskDetector.Detect()
 		fastMap := TaskDetector.Sort(fastMapList)
@@ -43,6 +62,7 @@ func (worker *CacheManager) AddTask(expiration uint32, cacheMessage *clients.Cac
 			if success := worker.Cache.Update(key, fastMap); !success {
 				worker.Cache.Insert(key, cacheMessage.ResponseMessage, uint32(cacheMessage.MinimumTTL), cacheMessage.BundleName, cacheMessage.DomainName)
 			}
+			metrics.FastMapUpdates.Inc()
 			log.Infof("task: %s , time: %d expired\n", cacheMessage.ResponseMessage.Answer, expiration)
 			worker.AddTask(expiration, cacheMessage, fastMap, bundle)
 		} else {
@@ -57,13 +77,14 @@ func (worker *CacheManager) Handle() {
 	log.Info("Start CacheUpdate handle program\n")
 	for {
 		select {
+		case <-worker.quit:
+			log.Info("Stop CacheUpdate handle program")
+			return
 		case _, ok := <-worker.TaskChan:
 			if !ok {
-				break
-			} else {
-				log.Info("Now timer task: ", worker.TaskSum)
-				worker.TaskSum--
+				return
 			}
+			log.Info("Now timer task: ", atomic.AddInt32(&worker.TaskSum, -1)+1)
 		}
 	}
 
@@ -0,0 +1,256 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package dnstap emits dnstap messages over Frame Streams to a socket or a file.
+package dnstap
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/import-yuefeng/smartDNS/core/metrics"
+)
+
+// defaultBufferSize is count of messages waiting to be sent, messages are dropped when it is full
+const defaultBufferSize = 4096
+
+// writeTimeout detects a collector that stops reading
+const writeTimeout = 5 * time.Second
+
+// maxRetryDelay is the longest delay between reconnections to collector
+const maxRetryDelay = 30 * time.Second
+
+var dropped = metrics.NewCounterVec("smartdns_dnstap_dropped_total", "dnstap messages dropped because the collector is slow or down.")
+
+// Config is Dnstap in config file, one of Socket and File is required
+type Config struct {
+	// Socket is "unix:/path/to/socket" or "tcp:host:port"
+	Socket string
+	File   string
+	// Identity defaults to host name, Version defaults to "smartDNS"
+	Identity   string
+	Version    string
+	BufferSize int
+}
+
+// Tap sends messages in background, a nil Tap is valid and does nothing
+type Tap struct {
+	network  string
+	address  string
+	file     string
+	identity []byte
+	version  []byte
+
+	// lock guards messages against Emit after Close
+	lock     sync.RWMutex
+	closed   bool
+	messages chan *Message
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+// Check func validate config, nil config is valid
+func (c *Config) Check() error {
+	if c == nil {
+		return nil
+	}
+	if _, _, err := c.target(); err != nil {
+		return err
+	}
+	if c.BufferSize < 0 {
+		return fmt.Errorf("Dnstap BufferSize must not be negative")
+	}
+	return nil
+}
+
+func (c *Config) target() (network string, address string, err error) {
+	switch {
+	case c.Socket != "" && c.File != "":
+		return "", "", fmt.Errorf("Dnstap Socket and File can not be used together")
+	case c.File != "":
+		return "file", c.File, nil
+	case strings.HasPrefix(c.Socket, "unix:"):
+		return "unix", strings.TrimPrefix(c.Socket, "unix:"), nil
+	case strings.HasPrefix(c.Socket, "tcp:"):
+		return "tcp", strings.TrimPrefix(c.Socket, "tcp:"), nil
+	}
+	return "", "", fmt.Errorf("Invalid Dnstap Socket %s, it must be unix:/path or tcp:host:port", c.Socket)
+}
+
+// New func start sending to collector, nil is returned if c is nil
+func New(c *Config) (*Tap, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if err := c.Check(); err != nil {
+		return nil, err
+	}
+	network, address, _ := c.target()
+
+	t := &Tap{network: network, address: address, quit: make(chan struct{}), done: make(chan struct{})}
+	t.identity = []byte(c.Identity)
+	if c.Identity == "" {
+		hostname, _ := os.Hostname()
+		t.identity = []byte(hostname)
+	}
+	t.version = []byte(c.Version)
+	if c.Version == "" {
+		t.version = []byte("smartDNS")
+	}
+	size := c.BufferSize
+	if size == 0 {
+		size = defaultBufferSize
+	}
+	t.messages = make(chan *Message, size)
+
+	if network == "file" {
+		f, err := os.OpenFile(address, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			return nil, err
+		}
+		go t.runFile(f)
+	} else {
+		go t.runSocket()
+	}
+	log.Infof("dnstap messages are sent to %s %s", network, address)
+	return t, nil
+}
+
+// Emit func queue m without blocking, m is dropped if the queue is full
+func (t *Tap) Emit(m *Message) {
+	if t == nil {
+		return
+	}
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.messages <- m:
+	default:
+		dropped.Inc()
+	}
+}
+
+// Enabled func return true if messages are sent, callers skip building messages otherwise
+func (t *Tap) Enabled() bool {
+	return t != nil
+}
+
+// Close func send queued messages and close the stream, it gives up after writeTimeout
+func (t *Tap) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.lock.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.messages)
+	}
+	t.lock.Unlock()
+	select {
+	case <-t.done:
+	case <-time.After(writeTimeout):
+		close(t.quit)
+		<-t.done
+	}
+	return nil
+}
+
+func (t *Tap) runFile(f *os.File) {
+	defer close(t.done)
+	defer f.Close()
+	fw := newFrameWriter(f, false)
+	if err := fw.open(); err != nil {
+		log.Warnf("Failed to write dnstap file %s: %s", t.address, err)
+		return
+	}
+	if err := t.copy(fw, nil); err != nil {
+		log.Warnf("Failed to write dnstap file %s: %s", t.address, err)
+		return
+	}
+	fw.close()
+}
+
+// runSocket func connect to collector and send messages, it reconnects after errors until Close
+func (t *Tap) runSocket() {
+	defer close(t.done)
+	delay := time.Second
+	for {
+		conn, err := net.DialTimeout(t.network, t.address, handshakeTimeout)
+		if err == nil {
+			conn.SetDeadline(time.Now().Add(handshakeTimeout))
+			fw := newFrameWriter(conn, true)
+			if err = fw.open(); err == nil {
+				log.Infof("dnstap collector %s is connected", t.address)
+				delay = time.Second
+				if err = t.copy(fw, conn); err == nil {
+					conn.SetDeadline(time.Now().Add(writeTimeout))
+					fw.close()
+					conn.Close()
+					return
+				}
+			}
+			conn.Close()
+		}
+		log.Warnf("dnstap collector %s failed, retry in %s: %s", t.address, delay, err)
+
+		// messages are dropped while collector is down, so queries never wait
+		retry := time.After(delay)
+	wait:
+		for {
+			select {
+			case _, ok := <-t.messages:
+				if !ok {
+					return
+				}
+				dropped.Inc()
+			case <-retry:
+				break wait
+			case <-t.quit:
+				return
+			}
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// copy func write messages until queue is closed, buffer is flushed when queue is empty
+func (t *Tap) copy(fw *frameWriter, conn net.Conn) error {
+	for {
+		var m *Message
+		var ok bool
+		select {
+		case m, ok = <-t.messages:
+		case <-t.quit:
+			return io.ErrClosedPipe
+		}
+		if !ok {
+			return nil
+		}
+		if conn != nil {
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		}
+		if err := fw.write(m.marshal(t.identity, t.version)); err != nil {
+			return err
+		}
+		if len(t.messages) == 0 {
+			if err := fw.flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
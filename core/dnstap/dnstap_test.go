@@ -0,0 +1,179 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package dnstap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// decode func parse protocol buffers fields of b, values of varint and fixed32 fields are uint64, others []byte
+func decode(t *testing.T, b []byte) map[int]interface{} {
+	fields := make(map[int]interface{})
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			fields[int(key>>3)], b = v, b[n:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			fields[int(key>>3)], b = b[n:n+int(l)], b[n+int(l):]
+		case 5:
+			fields[int(key>>3)], b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	return fields
+}
+
+func TestMessage_Marshal(t *testing.T) {
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	qb, _ := q.Pack()
+	now := time.Unix(1567350245, 123456789)
+	m := &Message{
+		Type:         ClientQuery,
+		QueryAddr:    &net.UDPAddr{IP: net.ParseIP("192.168.1.2"), Port: 5353},
+		ResponseAddr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 53},
+		QueryTime:    now,
+		QueryMessage: qb,
+	}
+
+	d := decode(t, m.marshal([]byte("host"), []byte("smartDNS")))
+	if string(d[1].([]byte)) != "host" || string(d[2].([]byte)) != "smartDNS" || d[15].(uint64) != 1 {
+		t.Fatalf("envelope: %v", d)
+	}
+	msg := decode(t, d[14].([]byte))
+	want := map[int]interface{}{
+		1: uint64(ClientQuery), 2: uint64(familyINET), 3: uint64(protocolUDP),
+		6: uint64(5353), 7: uint64(53), 8: uint64(1567350245), 9: uint64(123456789),
+	}
+	for k, v := range want {
+		if msg[k] != v {
+			t.Errorf("field %d = %v, want %v", k, msg[k], v)
+		}
+	}
+	if !bytes.Equal(msg[4].([]byte), []byte{192, 168, 1, 2}) || !bytes.Equal(msg[10].([]byte), qb) {
+		t.Errorf("address or query message: %v", msg)
+	}
+	if _, ok := msg[14]; ok {
+		t.Error("query has no response message")
+	}
+}
+
+// collector func accept one Frame Streams connection and return data frames received before STOP
+func collector(t *testing.T, l net.Listener) <-chan [][]byte {
+	ch := make(chan [][]byte, 1)
+	go func() {
+		var frames [][]byte
+		defer func() { ch <- frames }()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if typ, types, err := readControl(conn); err != nil || typ != controlReady || len(types) != 1 || types[0] != contentType {
+			t.Errorf("READY: %d %v %v", typ, types, err)
+			return
+		}
+		fw := newFrameWriter(conn, false)
+		fw.writeControl(controlAccept, true)
+		fw.flush()
+		if typ, _, err := readControl(conn); err != nil || typ != controlStart {
+			t.Errorf("START: %d %v", typ, err)
+			return
+		}
+		for {
+			var l [4]byte
+			if _, err := io.ReadFull(conn, l[:]); err != nil {
+				t.Error(err)
+				return
+			}
+			n := binary.BigEndian.Uint32(l[:])
+			if n == 0 {
+				// escape of STOP
+				var head [4]byte
+				io.ReadFull(conn, head[:])
+				rest := make([]byte, binary.BigEndian.Uint32(head[:]))
+				io.ReadFull(conn, rest)
+				fw.writeControl(controlFinish, false)
+				fw.flush()
+				return
+			}
+			frame := make([]byte, n)
+			if _, err := io.ReadFull(conn, frame); err != nil {
+				t.Error(err)
+				return
+			}
+			frames = append(frames, frame)
+		}
+	}()
+	return ch
+}
+
+func TestTap_Socket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dnstap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dnstap.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	frames := collector(t, l)
+
+	tap, err := New(&Config{Socket: "unix:" + path, Identity: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, typ := range []MessageType{ClientQuery, ForwarderQuery, ForwarderResponse, ClientResponse} {
+		tap.Emit(&Message{Type: typ, QueryTime: time.Now()})
+	}
+	tap.Close()
+	tap.Emit(&Message{Type: ClientQuery})
+
+	select {
+	case got := <-frames:
+		if len(got) != 4 {
+			t.Fatalf("collector got %d frames", len(got))
+		}
+		msg := decode(t, decode(t, got[2])[14].([]byte))
+		if msg[1].(uint64) != uint64(ForwarderResponse) {
+			t.Errorf("third message type = %v", msg[1])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("collector timeout")
+	}
+}
+
+func TestConfig_Check(t *testing.T) {
+	for _, c := range []*Config{
+		{},
+		{Socket: "/run/dnstap.sock"},
+		{Socket: "unix:/run/dnstap.sock", File: "dnstap.fstrm"},
+		{File: "dnstap.fstrm", BufferSize: -1},
+	} {
+		if c.Check() == nil {
+			t.Errorf("%+v should be invalid", c)
+		}
+	}
+}
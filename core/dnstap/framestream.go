@@ -0,0 +1,156 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package dnstap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// contentType of Frame Streams, collectors reject other types
+const contentType = "protobuf:dnstap.Dnstap"
+
+// control frame types of Frame Streams
+const (
+	controlAccept = 0x01
+	controlStart  = 0x02
+	controlStop   = 0x03
+	controlReady  = 0x04
+	controlFinish = 0x05
+)
+
+// controlFieldContentType is the only field of control frames
+const controlFieldContentType = 0x01
+
+// handshakeTimeout limits READY/ACCEPT exchange with collector
+const handshakeTimeout = 5 * time.Second
+
+// frameWriter writes data frames, it is used by one goroutine
+type frameWriter struct {
+	w  *bufio.Writer
+	rw io.ReadWriter
+	// bidirectional streams (sockets) do READY/ACCEPT handshake and wait for FINISH
+	bidirectional bool
+}
+
+func newFrameWriter(rw io.ReadWriter, bidirectional bool) *frameWriter {
+	return &frameWriter{w: bufio.NewWriter(rw), rw: rw, bidirectional: bidirectional}
+}
+
+// open func do handshake and send START
+func (f *frameWriter) open() error {
+	if f.bidirectional {
+		if err := f.writeControl(controlReady, true); err != nil {
+			return err
+		}
+		if err := f.w.Flush(); err != nil {
+			return err
+		}
+		typ, types, err := readControl(f.rw)
+		if err != nil {
+			return err
+		}
+		if typ != controlAccept {
+			return fmt.Errorf("Frame Streams: expect ACCEPT, got control frame %d", typ)
+		}
+		accepted := false
+		for _, t := range types {
+			accepted = accepted || t == contentType
+		}
+		if !accepted {
+			return fmt.Errorf("Frame Streams: collector does not accept %s", contentType)
+		}
+	}
+	if err := f.writeControl(controlStart, true); err != nil {
+		return err
+	}
+	return f.w.Flush()
+}
+
+// write func write one data frame to buffer
+func (f *frameWriter) write(b []byte) error {
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(b)))
+	if _, err := f.w.Write(l[:]); err != nil {
+		return err
+	}
+	_, err := f.w.Write(b)
+	return err
+}
+
+func (f *frameWriter) flush() error {
+	return f.w.Flush()
+}
+
+// close func send STOP, and wait for FINISH of bidirectional stream
+func (f *frameWriter) close() error {
+	if err := f.writeControl(controlStop, false); err != nil {
+		return err
+	}
+	if err := f.w.Flush(); err != nil {
+		return err
+	}
+	if f.bidirectional {
+		if _, _, err := readControl(f.rw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeControl func write escape, length and control frame
+func (f *frameWriter) writeControl(typ uint32, withContentType bool) error {
+	var frame bytes.Buffer
+	binary.Write(&frame, binary.BigEndian, typ)
+	if withContentType {
+		binary.Write(&frame, binary.BigEndian, uint32(controlFieldContentType))
+		binary.Write(&frame, binary.BigEndian, uint32(len(contentType)))
+		frame.WriteString(contentType)
+	}
+	var head [8]byte
+	binary.BigEndian.PutUint32(head[4:], uint32(frame.Len()))
+	if _, err := f.w.Write(head[:]); err != nil {
+		return err
+	}
+	_, err := f.w.Write(frame.Bytes())
+	return err
+}
+
+// readControl func read one control frame, it returns control type and content types
+func readControl(r io.Reader) (typ uint32, types []string, err error) {
+	var head [8]byte
+	if _, err = io.ReadFull(r, head[:]); err != nil {
+		return
+	}
+	if binary.BigEndian.Uint32(head[:4]) != 0 {
+		return 0, nil, fmt.Errorf("Frame Streams: expect control frame")
+	}
+	l := binary.BigEndian.Uint32(head[4:])
+	if l < 4 || l > 512 {
+		return 0, nil, fmt.Errorf("Frame Streams: invalid control frame length %d", l)
+	}
+	frame := make([]byte, l)
+	if _, err = io.ReadFull(r, frame); err != nil {
+		return
+	}
+	typ = binary.BigEndian.Uint32(frame)
+	for rest := frame[4:]; len(rest) >= 8; {
+		field, n := binary.BigEndian.Uint32(rest), binary.BigEndian.Uint32(rest[4:])
+		if uint32(len(rest)-8) < n {
+			return 0, nil, fmt.Errorf("Frame Streams: invalid control field length %d", n)
+		}
+		if field == controlFieldContentType {
+			types = append(types, string(rest[8:8+n]))
+		}
+		rest = rest[8+n:]
+	}
+	return typ, types, nil
+}
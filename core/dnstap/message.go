@@ -0,0 +1,151 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package dnstap
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// MessageType is dnstap.Message.Type
+type MessageType uint64
+
+// Types of messages emitted by smartDNS
+const (
+	ClientQuery       MessageType = 5
+	ClientResponse    MessageType = 6
+	ForwarderQuery    MessageType = 7
+	ForwarderResponse MessageType = 8
+)
+
+// values of dnstap.SocketFamily and dnstap.SocketProtocol
+const (
+	familyINET  = 1
+	familyINET6 = 2
+	protocolUDP = 1
+	protocolTCP = 2
+)
+
+// dnstapTypeMessage is dnstap.Dnstap.Type MESSAGE
+const dnstapTypeMessage = 1
+
+// Message is dnstap.Message, Query is the side that sends the query (client, or smartDNS when forwarding)
+type Message struct {
+	Type            MessageType
+	QueryAddr       net.Addr
+	ResponseAddr    net.Addr
+	QueryTime       time.Time
+	ResponseTime    time.Time
+	QueryMessage    []byte
+	ResponseMessage []byte
+}
+
+// marshal func encode m in dnstap.Dnstap envelope
+func (m *Message) marshal(identity []byte, version []byte) []byte {
+	var msg protoBuffer
+	msg.varint(1, uint64(m.Type))
+
+	qIP, qPort, tcp := splitAddr(m.QueryAddr)
+	rIP, rPort, rTCP := splitAddr(m.ResponseAddr)
+	ip := qIP
+	if ip == nil {
+		ip = rIP
+	}
+	if ip != nil {
+		if ip.To4() != nil {
+			msg.varint(2, familyINET)
+		} else {
+			msg.varint(2, familyINET6)
+		}
+		if tcp || rTCP {
+			msg.varint(3, protocolTCP)
+		} else {
+			msg.varint(3, protocolUDP)
+		}
+	}
+	if qIP != nil {
+		msg.bytes(4, ipBytes(qIP))
+		msg.varint(6, uint64(qPort))
+	}
+	if rIP != nil {
+		msg.bytes(5, ipBytes(rIP))
+		msg.varint(7, uint64(rPort))
+	}
+	if !m.QueryTime.IsZero() {
+		msg.varint(8, uint64(m.QueryTime.Unix()))
+		msg.fixed32(9, uint32(m.QueryTime.Nanosecond()))
+	}
+	if m.QueryMessage != nil {
+		msg.bytes(10, m.QueryMessage)
+	}
+	if !m.ResponseTime.IsZero() {
+		msg.varint(12, uint64(m.ResponseTime.Unix()))
+		msg.fixed32(13, uint32(m.ResponseTime.Nanosecond()))
+	}
+	if m.ResponseMessage != nil {
+		msg.bytes(14, m.ResponseMessage)
+	}
+
+	var d protoBuffer
+	if len(identity) > 0 {
+		d.bytes(1, identity)
+	}
+	if len(version) > 0 {
+		d.bytes(2, version)
+	}
+	d.bytes(14, msg)
+	d.varint(15, dnstapTypeMessage)
+	return d
+}
+
+func splitAddr(addr net.Addr) (ip net.IP, port int, tcp bool) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP, a.Port, false
+	case *net.TCPAddr:
+		return a.IP, a.Port, true
+	}
+	return nil, 0, false
+}
+
+// ipBytes func return 4 bytes for IPv4, dnstap readers expect the length of the family
+func ipBytes(ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
+
+// protoBuffer encodes protocol buffers fields, only the wire types used by dnstap are supported
+type protoBuffer []byte
+
+func (b *protoBuffer) uvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	*b = append(*b, buf[:n]...)
+}
+
+func (b *protoBuffer) key(field int, wireType int) {
+	b.uvarint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (b *protoBuffer) varint(field int, v uint64) {
+	b.key(field, 0)
+	b.uvarint(v)
+}
+
+func (b *protoBuffer) bytes(field int, v []byte) {
+	b.key(field, 2)
+	b.uvarint(uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *protoBuffer) fixed32(field int, v uint32) {
+	b.key(field, 5)
+	*b = append(*b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
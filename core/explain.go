@@ -0,0 +1,55 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package core
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/import-yuefeng/smartDNS/core/config"
+	"github.com/import-yuefeng/smartDNS/core/outbound"
+)
+
+// Explain func load config, resolve name once through a dispatcher without listeners and write the trace to w
+func Explain(w io.Writer, configFilePath string, name string, qtype string) error {
+	query, err := NewQuery(name, qtype)
+	if err != nil {
+		return err
+	}
+	conf, err := config.LoadConfig(configFilePath)
+	if err != nil {
+		return err
+	}
+
+	dispatcher := newDispatcher(conf, nil, false, nil)
+	resp, info := dispatcher.Explain(query, "127.0.0.1")
+	outbound.WriteExplain(w, query, resp, info)
+	return nil
+}
+
+// NewQuery func create a recursive query of name, qtype is a type name like "AAAA" and defaults to A
+func NewQuery(name string, qtype string) (*dns.Msg, error) {
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	t := dns.TypeA
+	if qtype != "" {
+		var ok bool
+		if t, ok = dns.StringToType[strings.ToUpper(qtype)]; !ok {
+			return nil, fmt.Errorf("unknown query type %s", qtype)
+		}
+	}
+	if _, ok := dns.IsDomainName(name); !ok {
+		return nil, fmt.Errorf("invalid name %s", name)
+	}
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(name), t)
+	return query, nil
+}
@@ -6,17 +6,23 @@
 
 package full
 
+import "github.com/import-yuefeng/smartDNS/core/rule"
+
 type Map struct {
 	DataMap map[string]string
 }
 
 func (m *Map) Insert(k string, v string) error {
-	m.DataMap[k] = v
+	d, err := rule.NormalizeDomain(k)
+	if err != nil {
+		return err
+	}
+	m.DataMap[d] = v
 	return nil
 }
 
 func (m *Map) Get(k string) string {
-	return m.DataMap[k]
+	return m.DataMap[rule.NormalizeName(k)]
 }
 
 func (m *Map) Name() string {
@@ -6,22 +6,35 @@
 
 package regex
 
-import "github.com/import-yuefeng/smartDNS/core/common"
+import (
+	"sync"
 
+	"github.com/import-yuefeng/smartDNS/core/rule"
+)
+
+// List returns the value of the first regex (in insertion order) that matches
 type List struct {
-	RegexMap map[string]string
+	sync.RWMutex
+
+	patterns rule.RegexSet
+	values   []string
 }
 
 func (r *List) Insert(k string, v string) error {
-	r.RegexMap[k] = v
+	r.Lock()
+	defer r.Unlock()
+	if err := r.patterns.Add(k); err != nil {
+		return err
+	}
+	r.values = append(r.values, v)
 	return nil
 }
 
 func (r *List) Get(str string) string {
-	for k, v := range r.RegexMap {
-		if common.IsDomainMatchRule(k, str) {
-			return v
-		}
+	r.RLock()
+	defer r.RUnlock()
+	if i := r.patterns.Find(rule.NormalizeName(str)); i >= 0 {
+		return r.values[i]
 	}
 	return ""
 }
@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2019 shawn1m. All rights reserved.
+ * Use of this source code is governed by The MIT License (MIT) that can be
+ * found in the LICENSE file..
+ */
+
+package suffix
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/import-yuefeng/smartDNS/core/rule"
+)
+
+// Key syntax of Insert:
+//
+//	example.com        example.com and all subdomains
+//	*.example.com      subdomains of example.com only
+//	full:example.com   example.com only
+//
+// Get returns the value of the most specific key, exact keys beat the others on the same name.
+type node struct {
+	children map[string]*node
+
+	domain, wildcard, full          string
+	hasDomain, hasWildcard, hasFull bool
+}
+
+// Tree is a reversed-label trie, it is not safe for concurrent Insert, replace the whole Tree to update it
+type Tree struct {
+	root node
+}
+
+func New() *Tree {
+	return new(Tree)
+}
+
+func (t *Tree) Insert(k string, v string) error {
+	k = strings.TrimSpace(k)
+	full := strings.HasPrefix(k, "full:")
+	if full {
+		k = k[len("full:"):]
+	}
+	wildcard := !full && strings.HasPrefix(k, "*.")
+	if wildcard {
+		k = k[len("*."):]
+	}
+
+	d, err := rule.NormalizeDomain(k)
+	if err != nil {
+		return err
+	}
+
+	n := &t.root
+	for end := len(d); ; {
+		start := strings.LastIndexByte(d[:end], '.') + 1
+		label := d[start:end]
+		if label == "" {
+			return errors.New("empty label in domain " + d)
+		}
+		child, ok := n.children[label]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[string]*node)
+			}
+			child = new(node)
+			n.children[label] = child
+		}
+		n = child
+		if start == 0 {
+			break
+		}
+		end = start - 1
+	}
+
+	switch {
+	case full:
+		n.full, n.hasFull = v, true
+	case wildcard:
+		n.wildcard, n.hasWildcard = v, true
+	default:
+		n.domain, n.hasDomain = v, true
+	}
+	return nil
+}
+
+func (t *Tree) Get(k string) string {
+	d := rule.NormalizeName(k)
+	if d == "" {
+		return ""
+	}
+
+	var v string
+	n := &t.root
+	for end := len(d); ; {
+		start := strings.LastIndexByte(d[:end], '.') + 1
+		if n = n.children[d[start:end]]; n == nil {
+			return v
+		}
+		if start == 0 {
+			// all labels are consumed
+			switch {
+			case n.hasFull:
+				return n.full
+			case n.hasDomain:
+				return n.domain
+			}
+			return v
+		}
+		switch {
+		case n.hasWildcard:
+			v = n.wildcard
+		case n.hasDomain:
+			v = n.domain
+		}
+		end = start - 1
+	}
+}
+
+func (t *Tree) Name() string {
+	return "suffix-tree"
+}
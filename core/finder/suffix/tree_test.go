@@ -0,0 +1,60 @@
+/*
+ * Copyright (c) 2019 shawn1m. All rights reserved.
+ * Use of this source code is governed by The MIT License (MIT) that can be
+ * found in the LICENSE file..
+ */
+
+package suffix
+
+import "testing"
+
+func TestTree_Get(t *testing.T) {
+	tree := New()
+	for k, v := range map[string]string{
+		"example.com":         "1",
+		"*.a.example.com":     "2",
+		"full:b.example.com":  "3",
+		"c.b.example.com":     "4",
+		"*.google.com":        "5",
+		"full:www.google.com": "6",
+		"Example.ORG.":        "7",
+		"full:x.wild.net":     "8",
+		"*.wild.net":          "9",
+		"wild.net":            "10",
+	} {
+		if err := tree.Insert(k, v); err != nil {
+			t.Fatalf("Insert(%s): %s", k, err)
+		}
+	}
+
+	cases := map[string]string{
+		"example.com":       "1",
+		"www.example.com":   "1",
+		"a.example.com":     "1",
+		"x.a.example.com":   "2",
+		"b.example.com":     "3",
+		"x.b.example.com":   "1",
+		"c.b.example.com":   "4",
+		"x.c.b.example.com": "4",
+		"google.com":        "",
+		"mail.google.com":   "5",
+		"www.google.com":    "6",
+		"WWW.EXAMPLE.ORG.":  "7",
+		"wild.net":          "10",
+		"y.wild.net":        "9",
+		"x.wild.net":        "8",
+		"com":               "",
+		"":                  "",
+	}
+	for k, want := range cases {
+		if got := tree.Get(k); got != want {
+			t.Errorf("Get(%q) = %q, want %q", k, got, want)
+		}
+	}
+
+	for _, bad := range []string{"", "full:", "*.", "a..com"} {
+		if err := tree.Insert(bad, "x"); err == nil {
+			t.Errorf("Insert(%q) should fail", bad)
+		}
+	}
+}
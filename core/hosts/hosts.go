@@ -6,44 +6,65 @@
 package hosts
 
 import (
+	"fmt"
 	"net"
 	"os"
-	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/import-yuefeng/smartDNS/core/rule"
 )
 
-// Hosts represents a file containing hosts_sample
+// Hosts represents files containing hosts_sample, entries of later files are merged into earlier ones.
+// Hosts is not changed after New, a new Hosts is created when files are reloaded
 type Hosts struct {
-	hl       *hostsLines
-	filePath string
+	hl        *hostsLines
+	filePaths []string
 }
 
-func New(path string) (*Hosts, error) {
-	if path == "" {
+// New func load hosts files, a file that fails to open is logged and skipped,
+// error is returned only if none of files is loaded
+func New(paths ...string) (*Hosts, error) {
+	if len(paths) == 0 {
 		return nil, nil
 	}
 
-	h := &Hosts{filePath: path}
-	if err := h.loadHostEntries(); err != nil {
-		return nil, err
+	h := &Hosts{hl: newHostsLines(), filePaths: paths}
+	loaded := 0
+	var lastErr error
+	for _, path := range paths {
+		if err := h.loadHostEntries(path); err != nil {
+			log.Warnf("Failed to load hosts file %s: %s", path, err)
+			lastErr = err
+			continue
+		}
+		loaded++
+	}
+	if loaded == 0 {
+		return nil, fmt.Errorf("no hosts file is loaded: %s", lastErr)
 	}
 
 	return h, nil
 }
 
-// todo: use finder implementation
-func (h *Hosts) Find(name string) (ipv4List []net.IP, ipv6List []net.IP) {
-	name = strings.TrimSuffix(name, ".")
-	return h.hl.FindHosts(name)
+// Find func return addresses and TTL of name, exact entries are preferred to wildcard entries
+func (h *Hosts) Find(name string) (ipv4List []net.IP, ipv6List []net.IP, ttl uint32) {
+	return h.hl.FindHosts(rule.NormalizeName(name))
+}
+
+// FindPTR func return name and TTL of reverse address, e.g. 1.0.168.192.in-addr.arpa.
+func (h *Hosts) FindPTR(reverse string) (name string, ttl uint32) {
+	return h.hl.FindPTR(rule.NormalizeName(reverse))
 }
 
-func (h *Hosts) loadHostEntries() error {
-	f, err := os.Open(h.filePath)
+func (h *Hosts) loadHostEntries(path string) error {
+	f, err := os.Open(path)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	h.hl = newHostsLineList(f)
+	h.hl.load(f, path)
 
 	return nil
 }
@@ -0,0 +1,74 @@
+// Copyright (c) 2015 Jan Broer. All rights reserved.
+// Use of this source code is governed by The MIT License (MIT) that can be
+// found in the LICENSE file.
+
+package hosts
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+const sample = `# comment
+127.0.0.1	localhost myhost	# trailing comment
+192.168.1.2 nas.lan NAS ttl=60
+fd00::2 nas.lan
+10.0.0.1 *.wild.lan
+10.0.0.2 x.wild.lan
+10.0.0.3 *.a.wild.lan
+10.0.0.4 multi.lan ttl=300
+10.0.0.5 multi.lan ttl=30
+bad line
+1.2.3.4`
+
+func TestHosts_Find(t *testing.T) {
+	hl := newHostsLines()
+	hl.load(strings.NewReader(sample), "sample")
+	h := &Hosts{hl: hl}
+
+	cases := []struct {
+		name string
+		ipv4 string
+		ipv6 string
+		ttl  uint32
+	}{
+		{"localhost", "127.0.0.1", "", defaultTTL},
+		{"MyHost.", "127.0.0.1", "", defaultTTL},
+		{"nas.lan", "192.168.1.2", "fd00::2", 60},
+		{"nas", "192.168.1.2", "", 60},
+		{"axnas.lan", "", "", 0},
+		{"wild.lan", "", "", 0},
+		{"y.wild.lan", "10.0.0.1", "", defaultTTL},
+		{"z.y.wild.lan", "10.0.0.1", "", defaultTTL},
+		{"x.wild.lan", "10.0.0.2", "", defaultTTL},
+		{"b.a.wild.lan", "10.0.0.3", "", defaultTTL},
+		{"multi.lan", "10.0.0.4,10.0.0.5", "", 30},
+	}
+	for _, c := range cases {
+		ipv4, ipv6, ttl := h.Find(c.name)
+		if join(ipv4) != c.ipv4 || join(ipv6) != c.ipv6 || ttl != c.ttl {
+			t.Errorf("Find(%s) = %v %v %d, want %s %s %d", c.name, ipv4, ipv6, ttl, c.ipv4, c.ipv6, c.ttl)
+		}
+	}
+
+	ptr := map[string]string{
+		"1.0.0.127.in-addr.arpa.":   "localhost",
+		"2.1.168.192.in-addr.arpa.": "nas.lan",
+		"2.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa.": "nas.lan",
+		"1.0.0.10.in-addr.arpa.": "",
+	}
+	for reverse, want := range ptr {
+		if got, _ := h.FindPTR(reverse); got != want {
+			t.Errorf("FindPTR(%s) = %s, want %s", reverse, got, want)
+		}
+	}
+}
+
+func join(list []net.IP) string {
+	var s []string
+	for _, ip := range list {
+		s = append(s, ip.String())
+	}
+	return strings.Join(s, ",")
+}
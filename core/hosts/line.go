@@ -6,129 +6,207 @@ package hosts
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/miekg/dns"
 	log "github.com/sirupsen/logrus"
 
-	"github.com/import-yuefeng/smartDNS/core/common"
+	"github.com/import-yuefeng/smartDNS/core/rule"
 )
 
+// defaultTTL is used when the line has no ttl=N word
+const defaultTTL = 3600
+
 type hostsLine struct {
-	domain string
-	ip     net.IP
-	ipv6   bool
+	ip    net.IP
+	names []string
+	ttl   uint32
+}
+
+// hostsEntry is addresses of one name
+type hostsEntry struct {
+	ipv4 []net.IP
+	ipv6 []net.IP
+	ttl  uint32
+}
+
+// ptrEntry is the name of one address, the first name seen for the address is used
+type ptrEntry struct {
+	name string
+	ttl  uint32
 }
 
 type hostsLines struct {
-	data []*hostsLine
-	hash map[string]struct{}
+	exact    map[string]*hostsEntry
+	wildcard map[string]*hostsEntry
+	ptr      map[string]*ptrEntry
 }
 
-func newHostsLineList(r io.Reader) *hostsLines {
-	resultLines := new(hostsLines)
-	resultLines.hash = make(map[string]struct{})
+func newHostsLines() *hostsLines {
+	return &hostsLines{
+		exact:    make(map[string]*hostsEntry),
+		wildcard: make(map[string]*hostsEntry),
+		ptr:      make(map[string]*ptrEntry),
+	}
+}
 
-	defer log.Debugf("%s took %s", "Load hosts", time.Since(time.Now()))
+// load func read hosts file, bad lines are logged with line number and skipped
+func (hl *hostsLines) load(r io.Reader, file string) {
+	start := time.Now()
+	defer func() { log.Debugf("%s took %s", "Load hosts "+file, time.Since(start)) }()
 
+	lineNumber := 0
 	reader := bufio.NewReader(r)
 	for {
 		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err != io.EOF {
-				log.Errorf("Error reading hosts file: %s", err)
-			} else {
-				log.Debug("Reading hosts file reached EOF")
-			}
+		if err != nil && err != io.EOF {
+			log.Errorf("Error reading hosts file %s: %s", file, err)
 			break
 		}
+		lineNumber++
 
-		if host := parseLine(line); host != nil {
-			if err := resultLines.add(host); err != nil {
-				log.Warnf("Bad formatted hosts file line: %s", err)
-			}
+		host, perr := parseLine(line)
+		if perr != nil {
+			log.Warnf("Bad formatted hosts file %s line %d: %s", file, lineNumber, perr)
+		} else if host != nil {
+			hl.add(host)
 		}
-	}
 
-	return resultLines
-}
-
-func (hl *hostsLines) FindHosts(name string) (ipv4List []net.IP, ipv6List []net.IP) {
-	for _, h := range hl.data {
-		if common.IsDomainMatchRule(h.domain, name) {
-			log.WithFields(log.Fields{
-				"question": name,
-				"domain":   h.domain,
-				"ip":       h.ip,
-			}).Debug("Matched")
-			if h.ip.To4() != nil {
-				ipv4List = append(ipv4List, h.ip)
-			} else {
-				ipv6List = append(ipv6List, h.ip)
-			}
+		if err == io.EOF {
+			log.Debugf("Reading hosts file %s reached EOF", file)
+			break
 		}
 	}
-	return
 }
 
-func (hl *hostsLines) add(h *hostsLine) error {
-	if _, ok := hl.hash[h.domain]; !ok {
-		hl.data = append(hl.data, h)
-		hl.hash[h.domain] = struct{}{}
-	} else {
-		log.Warnf("Duplicate entry for host %s in hosts file, ignored value: %s", h.domain, h.ip.String())
+// FindHosts func return addresses of exact entry, or of the most specific wildcard entry
+func (hl *hostsLines) FindHosts(name string) (ipv4List []net.IP, ipv6List []net.IP, ttl uint32) {
+	e, ok := hl.exact[name]
+	for i := 0; !ok && i < len(name); i++ {
+		if name[i] == '.' {
+			e, ok = hl.wildcard[name[i+1:]]
+		}
+	}
+	if !ok {
+		return nil, nil, 0
 	}
-	return nil
+	log.WithFields(log.Fields{
+		"question": name,
+		"ipv4":     e.ipv4,
+		"ipv6":     e.ipv6,
+	}).Debug("Matched")
+	return e.ipv4, e.ipv6, e.ttl
 }
 
-func parseLine(line string) *hostsLine {
-	if len(line) == 0 {
-		return nil
+// FindPTR func return name of reverse address like 1.0.168.192.in-addr.arpa
+func (hl *hostsLines) FindPTR(reverse string) (name string, ttl uint32) {
+	if p, ok := hl.ptr[reverse]; ok {
+		return p.name, p.ttl
 	}
+	return "", 0
+}
 
-	// Parse leading # for disabled lines
-	if line[0:1] == "#" {
-		return nil
-	}
+func (hl *hostsLines) add(h *hostsLine) {
+	for _, name := range h.names {
+		index := hl.exact
+		wildcard := strings.HasPrefix(name, "*.")
+		if wildcard {
+			index, name = hl.wildcard, name[2:]
+		}
 
-	// Parse other #s for actual comments
-	line = strings.Split(line, "#")[0]
+		e, ok := index[name]
+		if !ok {
+			e = &hostsEntry{ttl: h.ttl}
+			index[name] = e
+		} else if h.ttl < e.ttl {
+			// lines of one name share the answer, the lowest TTL of them is used
+			e.ttl = h.ttl
+		}
+		if containsIP(e.ipv4, h.ip) || containsIP(e.ipv6, h.ip) {
+			log.Warnf("Duplicate entry for host %s in hosts file, ignored value: %s", name, h.ip.String())
+			continue
+		}
+		if h.ip.To4() != nil {
+			e.ipv4 = append(e.ipv4, h.ip)
+		} else {
+			e.ipv6 = append(e.ipv6, h.ip)
+		}
 
-	// Replace tabs and multispaces with single spaces throughout
-	line = strings.Replace(line, "\t", " ", -1)
-	for strings.Contains(line, "  ") {
-		line = strings.Replace(line, "  ", " ", -1)
+		if !wildcard {
+			if reverse, err := dns.ReverseAddr(h.ip.String()); err == nil {
+				reverse = strings.TrimSuffix(reverse, ".")
+				if p, ok := hl.ptr[reverse]; !ok {
+					hl.ptr[reverse] = &ptrEntry{name: name, ttl: h.ttl}
+				} else if p.name == name && h.ttl < p.ttl {
+					p.ttl = h.ttl
+				}
+			}
+		}
 	}
+}
 
-	line = strings.TrimSpace(line)
+func containsIP(list []net.IP, ip net.IP) bool {
+	for _, i := range list {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
 
-	// Break line into words
-	words := strings.Split(line, " ")
+// parseLine func parse "ip name [alias...] [ttl=N] [# comment]", empty and comment lines return nil
+func parseLine(line string) (*hostsLine, error) {
+	// Parse #s for comments
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = line[:i]
+	}
 
-	if len(words) < 2 {
-		return nil
+	// Break line into words, tabs and multispaces are separators
+	words := strings.Fields(line)
+	if len(words) == 0 {
+		return nil, nil
 	}
-	for i, word := range words {
-		words[i] = strings.TrimSpace(word)
+	if len(words) < 2 {
+		return nil, fmt.Errorf("name is missing: %s", line)
 	}
-	// Separate the first bit (the ip) from the other bits (the domains)
-	a, h := words[0], words[1]
 
-	ip := net.ParseIP(a)
+	// Separate the first bit (the ip) from the other bits (the domains)
+	ip := net.ParseIP(words[0])
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address: %s", words[0])
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
 
-	var isIPv6 bool
+	h := &hostsLine{ip: ip, ttl: defaultTTL}
+	for _, word := range words[1:] {
+		if strings.HasPrefix(word, "ttl=") {
+			ttl, err := strconv.ParseUint(word[len("ttl="):], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid TTL: %s", word)
+			}
+			h.ttl = uint32(ttl)
+			continue
+		}
 
-	switch {
-	case ip.To4() != nil:
-		isIPv6 = false
-	case ip.To16() != nil:
-		isIPv6 = true
-	default:
-		log.Warnf("Invalid IP address found in hosts file: %s", a)
-		return nil
+		wildcard := strings.HasPrefix(word, "*.")
+		name, err := rule.NormalizeDomain(strings.TrimPrefix(word, "*."))
+		if err != nil {
+			return nil, err
+		}
+		if wildcard {
+			name = "*." + name
+		}
+		h.names = append(h.names, name)
 	}
-
-	return &hostsLine{h, ip, isIPv6}
+	if len(h.names) == 0 {
+		return nil, fmt.Errorf("name is missing: %s", line)
+	}
+	return h, nil
 }
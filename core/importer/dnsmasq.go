@@ -0,0 +1,366 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package importer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/import-yuefeng/smartDNS/core/common"
+)
+
+// DefaultBundle is the bundle of dnsmasq servers without domain
+const DefaultBundle = "Default"
+
+// dnsmasq holds options collected from dnsmasq config files
+type dnsmasq struct {
+	r *Result
+
+	// servers are upstreams without domain
+	servers []string
+	// domainServers are upstreams of domains, in the order domains appear
+	domains       []string
+	domainServers map[string][]string
+	// addresses are values of address=/domain/..., in the order domains appear
+	addressDomains []string
+	addresses      map[string][]string
+	bogus          []string
+	hosts          []string
+
+	listenAddress string
+	port          string
+	noHosts       bool
+	noResolv      bool
+	resolvFile    string
+	unsupported   map[string]bool
+	visited       map[string]bool
+}
+
+// Dnsmasq func convert dnsmasq config, conf-file and conf-dir are followed, rule files are written to dir
+// server= without domain becomes the Default bundle. Domains of server=/domain/... are grouped by their servers,
+// each group is a bundle with its domain list. address= becomes Rewrite AddressFile, bogus-nxdomain= BogusIPFile.
+func Dnsmasq(path string, dir string) (*Result, error) {
+	m := &dnsmasq{
+		r:             newResult(dir),
+		domainServers: make(map[string][]string),
+		addresses:     make(map[string][]string),
+		unsupported:   make(map[string]bool),
+		visited:       make(map[string]bool),
+		port:          "53",
+		resolvFile:    "/etc/resolv.conf",
+	}
+	if err := m.parseFile(path); err != nil {
+		return nil, err
+	}
+	m.convert()
+	return m.r, nil
+}
+
+// parseFile func read options of a dnsmasq config file
+func (m *dnsmasq) parseFile(path string) error {
+	if m.visited[path] {
+		return nil
+	}
+	m.visited[path] = true
+
+	lines, err := readLines(path)
+	if err != nil {
+		return err
+	}
+	for i, line := range lines {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value := line, ""
+		if j := strings.Index(line, "="); j >= 0 {
+			key, value = strings.TrimSpace(line[:j]), strings.TrimSpace(line[j+1:])
+		}
+		if err := m.option(key, value, filepath.Dir(path)); err != nil {
+			return fmt.Errorf("%s:%d: %s", path, i+1, err)
+		}
+	}
+	return nil
+}
+
+// option func apply one option, options that have no equivalent are reported once
+func (m *dnsmasq) option(key string, value string, dir string) error {
+	switch key {
+	case "server", "local":
+		return m.server(value)
+	case "address":
+		m.address(value)
+	case "bogus-nxdomain":
+		m.bogus = append(m.bogus, value)
+	case "addn-hosts":
+		m.hosts = append(m.hosts, value)
+	case "no-hosts":
+		m.noHosts = true
+	case "no-resolv":
+		m.noResolv = true
+	case "resolv-file":
+		m.resolvFile = value
+	case "listen-address":
+		if m.listenAddress != "" {
+			m.r.warnf("listen-address=%s: only the first listen address %s is used", value, m.listenAddress)
+			return nil
+		}
+		m.listenAddress = strings.Split(value, ",")[0]
+	case "port":
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("invalid port %q", value)
+		}
+		m.port = value
+	case "cache-size":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid cache-size %q", value)
+		}
+		m.r.Config.CacheSize = n
+	case "min-cache-ttl":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid min-cache-ttl %q", value)
+		}
+		m.r.Config.MinimumTTL = n
+	case "conf-file", "servers-file":
+		return m.parseFile(resolvePath(value, dir))
+	case "conf-dir":
+		return m.confDir(value, dir)
+	default:
+		if !m.unsupported[key] {
+			m.unsupported[key] = true
+			m.r.warnf("%s: option is not supported, ignored", key)
+		}
+	}
+	return nil
+}
+
+// server func add server=[/domain/...]address[#port]
+func (m *dnsmasq) server(value string) error {
+	if !strings.HasPrefix(value, "/") {
+		addr, err := m.serverAddress(value)
+		if err != nil {
+			return err
+		}
+		m.servers = appendNew(m.servers, addr)
+		return nil
+	}
+
+	parts := strings.Split(value, "/")
+	domains, value := parts[1:len(parts)-1], parts[len(parts)-1]
+	switch value {
+	case "":
+		m.r.warnf("server=/%s/: names answered only from hosts are not supported, ignored", strings.Join(domains, "/"))
+		return nil
+	case "#":
+		m.r.warnf("server=/%s/#: names already use the Default bundle unless another list matches", strings.Join(domains, "/"))
+		return nil
+	}
+	addr, err := m.serverAddress(value)
+	if err != nil {
+		return err
+	}
+	for _, d := range domains {
+		if d == "" {
+			continue
+		}
+		if _, ok := m.domainServers[d]; !ok {
+			m.domains = append(m.domains, d)
+		}
+		m.domainServers[d] = appendNew(m.domainServers[d], addr)
+	}
+	return nil
+}
+
+// serverAddress func convert address[#port][@source] of dnsmasq to host:port
+func (m *dnsmasq) serverAddress(value string) (string, error) {
+	if i := strings.Index(value, "@"); i >= 0 {
+		m.r.warnf("server %s: source address or interface is ignored", value)
+		value = value[:i]
+	}
+	host, port := value, "53"
+	if i := strings.Index(value, "#"); i >= 0 {
+		host, port = value[:i], value[i+1:]
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid server address %q", value)
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		return "", fmt.Errorf("invalid server port %q", value)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// address func add address=/domain/.../ip, # is the unspecified address of both families
+func (m *dnsmasq) address(value string) {
+	parts := strings.Split(value, "/")
+	if len(parts) < 3 || parts[0] != "" {
+		m.r.warnf("address=%s: invalid format, ignored", value)
+		return
+	}
+	domains, ip := parts[1:len(parts)-1], parts[len(parts)-1]
+	switch {
+	case ip == "":
+		m.r.warnf("address=%s: NXDOMAIN answers are not supported, ignored", value)
+		return
+	case ip == "#":
+		ip = "0.0.0.0,::"
+	case net.ParseIP(ip) == nil:
+		m.r.warnf("address=%s: invalid address, ignored", value)
+		return
+	}
+	for _, d := range domains {
+		if d == "" {
+			continue
+		}
+		if _, ok := m.addresses[d]; !ok {
+			m.addressDomains = append(m.addressDomains, d)
+		}
+		m.addresses[d] = appendNew(m.addresses[d], ip)
+	}
+}
+
+// confDir func read files of conf-dir=dir[,.ext...][,*.ext...]
+// Extensions with * are the only ones read, the others are skipped like dnsmasq does.
+func (m *dnsmasq) confDir(value string, dir string) error {
+	parts := strings.Split(value, ",")
+	confDir := resolvePath(parts[0], dir)
+	var include, exclude []string
+	for _, p := range parts[1:] {
+		if strings.HasPrefix(p, "*") {
+			include = append(include, p[1:])
+		} else {
+			exclude = append(exclude, p)
+		}
+	}
+
+	files, err := ioutil.ReadDir(confDir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") ||
+			(strings.HasPrefix(name, "#") && strings.HasSuffix(name, "#")) ||
+			hasSuffix(name, exclude) || (len(include) > 0 && !hasSuffix(name, include)) {
+			continue
+		}
+		if err := m.parseFile(filepath.Join(confDir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convert func build config and rule files from collected options
+func (m *dnsmasq) convert() {
+	c := m.r.Config
+	c.BindAddress = net.JoinHostPort(m.listenAddress, m.port)
+	if !m.noHosts {
+		c.HostsFile = append(c.HostsFile, "/etc/hosts")
+	}
+	c.HostsFile = append(c.HostsFile, m.hosts...)
+
+	servers := m.servers
+	if len(servers) == 0 && !m.noResolv {
+		servers = m.resolvServers()
+	}
+	if len(servers) > 0 {
+		c.DNSBunch[DefaultBundle] = upstreams(servers)
+		c.DNSFilter[DefaultBundle] = &Filter{Matcher: "suffix-tree"}
+		c.DefaultDNSBundle = DefaultBundle
+	} else {
+		m.r.warnf("server: no server without domain, names that match no domain list are not answered")
+	}
+
+	// domains with the same servers share a bundle
+	var groups []string
+	groupDomains := make(map[string][]string)
+	for _, d := range m.domains {
+		key := strings.Join(m.domainServers[d], ",")
+		if _, ok := groupDomains[key]; !ok {
+			groups = append(groups, key)
+		}
+		groupDomains[key] = append(groupDomains[key], d)
+	}
+	for i, key := range groups {
+		name := fmt.Sprintf("Server-%d", i+1)
+		c.DNSBunch[name] = upstreams(strings.Split(key, ","))
+		c.DNSFilter[name] = &Filter{
+			Matcher:    "suffix-tree",
+			DomainFile: m.r.addFile(fmt.Sprintf("server-%d_domain.txt", i+1), groupDomains[key]),
+		}
+	}
+
+	if len(m.bogus) > 0 {
+		bogusFile := m.r.addFile("bogus_ip.txt", m.bogus)
+		for _, f := range c.DNSFilter {
+			f.BogusIPFile = bogusFile
+		}
+	}
+
+	if len(m.addressDomains) > 0 {
+		var lines []string
+		for _, d := range m.addressDomains {
+			lines = append(lines, d+" "+strings.Join(m.addresses[d], ","))
+		}
+		c.Rewrite = &Rewrite{AddressFile: m.r.addFile("address.conf", lines)}
+	}
+}
+
+// resolvServers func return nameservers of resolv-file, they are used when there is no server= without domain
+func (m *dnsmasq) resolvServers() (servers []string) {
+	lines, err := readLines(m.resolvFile)
+	if err != nil {
+		m.r.warnf("resolv-file: %s", err)
+		return nil
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		ip := net.ParseIP(fields[1])
+		if ip == nil || ip.IsLoopback() {
+			// dnsmasq itself usually listens on loopback
+			continue
+		}
+		servers = appendNew(servers, net.JoinHostPort(fields[1], "53"))
+	}
+	return
+}
+
+// upstreams func return udp upstreams named by their address
+func upstreams(addresses []string) []*common.DNSUpstream {
+	var list []*common.DNSUpstream
+	for _, addr := range addresses {
+		list = append(list, &common.DNSUpstream{Name: addr, Address: addr, Protocol: "udp", Timeout: 6})
+	}
+	return list
+}
+
+func appendNew(list []string, s string) []string {
+	for _, e := range list {
+		if e == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+func hasSuffix(name string, suffixes []string) bool {
+	for _, s := range suffixes {
+		if strings.HasSuffix(name, s) {
+			return true
+		}
+	}
+	return false
+}
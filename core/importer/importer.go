@@ -0,0 +1,155 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package importer converts overture and dnsmasq configs to smartDNS config and rule files.
+package importer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/import-yuefeng/smartDNS/core/common"
+)
+
+// ConfigFile is the name of the config written by Write
+const ConfigFile = "config.json"
+
+// Config is the part of smartDNS config that importers produce
+type Config struct {
+	BindAddress           string
+	DebugHTTPAddress      string `json:",omitempty"`
+	IPv6UseAlternativeDNS bool   `json:",omitempty"`
+	DefaultDNSBundle      string
+	HostsFile             []string `json:",omitempty"`
+	MinimumTTL            int      `json:",omitempty"`
+	DomainTTLFile         string   `json:",omitempty"`
+	CacheSize             int      `json:",omitempty"`
+	RejectQType           []uint16 `json:",omitempty"`
+	DNSBunch              map[string][]*common.DNSUpstream
+	DNSFilter             map[string]*Filter
+	Rewrite               *Rewrite `json:",omitempty"`
+}
+
+// Filter is DNSFilter of Config
+type Filter struct {
+	Matcher       string
+	DomainFile    string `json:",omitempty"`
+	IPNetworkFile string `json:",omitempty"`
+	BogusIPFile   string `json:",omitempty"`
+}
+
+// Rewrite is Rewrite of Config
+type Rewrite struct {
+	AddressFile string
+}
+
+// Result is a converted config, the rule files it refers to and what could not be converted
+type Result struct {
+	Config *Config
+	// Files are rule files by path, one rule per line
+	Files    map[string][]string
+	Warnings []string
+
+	dir string
+}
+
+func newResult(dir string) *Result {
+	return &Result{
+		Config: &Config{
+			DNSBunch:  make(map[string][]*common.DNSUpstream),
+			DNSFilter: make(map[string]*Filter),
+		},
+		Files: make(map[string][]string),
+		dir:   dir,
+	}
+}
+
+// warnf func record something of the source config that is not converted
+func (r *Result) warnf(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// addFile func add a rule file written to the output directory, and return its path
+func (r *Result) addFile(name string, lines []string) string {
+	path := filepath.Join(r.dir, name)
+	r.Files[path] = lines
+	return path
+}
+
+// Write func write config and rule files, existing files are only replaced if overwrite is true
+func (r *Result) Write(overwrite bool) (string, error) {
+	configFile := filepath.Join(r.dir, ConfigFile)
+	if !overwrite {
+		for _, f := range append(sortedFiles(r.Files), configFile) {
+			if _, err := os.Stat(f); err == nil {
+				return "", fmt.Errorf("%s already exists", f)
+			}
+		}
+	}
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return "", err
+	}
+	for _, f := range sortedFiles(r.Files) {
+		if err := ioutil.WriteFile(f, []byte(joinLines(r.Files[f])), 0644); err != nil {
+			return "", err
+		}
+	}
+	b, err := json.MarshalIndent(r.Config, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return configFile, ioutil.WriteFile(configFile, append(b, '\n'), 0644)
+}
+
+func sortedFiles(files map[string][]string) []string {
+	var names []string
+	for f := range files {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// readLines func return trimmed lines of file
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSpace(scanner.Text()))
+	}
+	return lines, scanner.Err()
+}
+
+// resolvePath func return path as it is if it exists, otherwise relative to dir of the source config
+func resolvePath(path string, dir string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
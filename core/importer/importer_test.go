@@ -0,0 +1,183 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package importer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/import-yuefeng/smartDNS/core/config"
+)
+
+func hasWarning(r *Result, s string) bool {
+	for _, w := range r.Warnings {
+		if strings.Contains(w, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestOverture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "importer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"overture.json": `{
+  "BindAddress": ":53",
+  "PrimaryDNS": [{"Name": "DNSPod", "Address": "119.29.29.29:53", "Protocol": "udp", "Timeout": 6,
+    "EDNSClientSubnet": {"Policy": "auto", "ExternalIP": "1.2.3.4", "NoCookie": true}}],
+  "AlternativeDNS": [
+    {"Name": "OpenDNS", "Address": "208.67.222.222:443", "Protocol": "tcp", "Timeout": 6},
+    {"Name": "DoH", "Address": "https://dns.example/dns-query", "Protocol": "https", "Timeout": 6}],
+  "IPNetworkFile": "./ip_network",
+  "DomainFile": "./gfwlist",
+  "DomainBase64Decode": true,
+  "HostsFile": "./hosts",
+  "RejectQType": [255]
+}`,
+		"ip_network": "1.0.1.0/24\n",
+		// base64 of "[AutoProxy]\n! comment\n||google.com\n.twitter.com\n@@||example.cn\n|http://foo.example.org/path\n/regex/\n"
+		"gfwlist": "W0F1dG9Qcm94eV0KISBjb21tZW50Cnx8Z29vZ2xlLmNvbQoudHdpdHRlci5jb20KQEB8fGV4YW1wbGUuY24KfGh0dHA6Ly9mb28uZXhhbXBsZS5vcmcvcGF0aAovcmVnZXgvCg==",
+		"hosts":   "127.0.0.1 localhost\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := filepath.Join(dir, "out")
+
+	r, err := Overture(filepath.Join(dir, "overture.json"), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := r.Config
+	if c.DefaultDNSBundle != AlternativeBundle {
+		t.Errorf("DefaultDNSBundle %q", c.DefaultDNSBundle)
+	}
+	if len(c.DNSBunch[AlternativeBundle]) != 1 || !hasWarning(r, "protocol https") {
+		t.Errorf("https upstream is not skipped: %v %q", c.DNSBunch[AlternativeBundle], r.Warnings)
+	}
+	if ecs := c.DNSBunch[PrimaryBundle][0].EDNSClientSubnet; ecs == nil || ecs.Policy != "enable" || ecs.ExternalIP != "1.2.3.4" || !ecs.NoCookie || !hasWarning(r, "client subnet") {
+		t.Errorf("EDNSClientSubnet of primary %+v", ecs)
+	}
+	wantFilters := map[string]*Filter{
+		PrimaryBundle:     {Matcher: "suffix-tree", IPNetworkFile: filepath.Join(out, "primary_ip_network.txt")},
+		AlternativeBundle: {Matcher: "suffix-tree", DomainFile: filepath.Join(out, "alternative_domain.txt")},
+	}
+	if !reflect.DeepEqual(c.DNSFilter, wantFilters) {
+		t.Errorf("got filters %+v %+v", c.DNSFilter[PrimaryBundle], c.DNSFilter[AlternativeBundle])
+	}
+	if got := r.Files[filepath.Join(out, "alternative_domain.txt")]; !reflect.DeepEqual(got, []string{"google.com", "twitter.com", "foo.example.org"}) {
+		t.Errorf("decoded gfwlist %q", got)
+	}
+	if !reflect.DeepEqual(c.HostsFile, []string{filepath.Join(dir, "hosts")}) {
+		t.Errorf("HostsFile %q", c.HostsFile)
+	}
+
+	configFile, err := r.Write(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := config.Check(configFile); len(problems) != 0 {
+		t.Errorf("converted config has problems: %q", problems)
+	}
+	if _, err := r.Write(false); err == nil {
+		t.Error("existing files are replaced without overwrite")
+	}
+}
+
+func TestDnsmasq(t *testing.T) {
+	dir, err := ioutil.TempDir("", "importer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "dnsmasq.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"dnsmasq.conf": `# upstreams
+no-resolv
+no-hosts
+server=8.8.8.8
+server=1.1.1.1#5353
+listen-address=127.0.0.1
+port=5353
+cache-size=1000
+dhcp-range=192.168.0.50,192.168.0.150,12h
+address=/ads.example.com/0.0.0.0
+address=/ads.example.com/::
+address=/block.example/#
+bogus-nxdomain=1.2.3.4
+conf-dir=dnsmasq.d,*.conf
+`,
+		"dnsmasq.d/china.conf": "server=/baidu.com/qq.com/114.114.114.114\nserver=/taobao.com/114.114.114.114\n" +
+			"server=/corp/10.0.0.1\nserver=/corp/10.0.0.2\nserver=/local/\n",
+		"dnsmasq.d/old.bak": "server=9.9.9.9\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := filepath.Join(dir, "out")
+
+	r, err := Dnsmasq(filepath.Join(dir, "dnsmasq.conf"), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := r.Config
+	if c.BindAddress != "127.0.0.1:5353" || c.CacheSize != 1000 || c.DefaultDNSBundle != DefaultBundle || len(c.HostsFile) != 0 {
+		t.Errorf("got %q %d %q %q", c.BindAddress, c.CacheSize, c.DefaultDNSBundle, c.HostsFile)
+	}
+	var addresses []string
+	for _, u := range c.DNSBunch[DefaultBundle] {
+		addresses = append(addresses, u.Address)
+	}
+	if !reflect.DeepEqual(addresses, []string{"8.8.8.8:53", "1.1.1.1:5353"}) {
+		t.Errorf("default servers %q", addresses)
+	}
+	if len(c.DNSBunch) != 3 || len(c.DNSBunch["Server-2"]) != 2 {
+		t.Errorf("got bundles %v", c.DNSBunch)
+	}
+	if got := r.Files[filepath.Join(out, "server-1_domain.txt")]; !reflect.DeepEqual(got, []string{"baidu.com", "qq.com", "taobao.com"}) {
+		t.Errorf("domains of Server-1 %q", got)
+	}
+	if got := r.Files[filepath.Join(out, "address.conf")]; !reflect.DeepEqual(got, []string{"ads.example.com 0.0.0.0,::", "block.example 0.0.0.0,::"}) {
+		t.Errorf("address rewrites %q", got)
+	}
+	if c.DNSFilter["Server-1"].BogusIPFile != filepath.Join(out, "bogus_ip.txt") {
+		t.Errorf("BogusIPFile %q", c.DNSFilter["Server-1"].BogusIPFile)
+	}
+	if !hasWarning(r, "dhcp-range") || !hasWarning(r, "server=/local/") {
+		t.Errorf("warnings %q", r.Warnings)
+	}
+
+	configFile, err := r.Write(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := config.Check(configFile); len(problems) != 0 {
+		t.Errorf("converted config has problems: %q", problems)
+	}
+
+	if _, err := Dnsmasq(filepath.Join(dir, "dnsmasq.d/china.conf"), out); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "bad.conf"), []byte("server=not-an-address\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Dnsmasq(filepath.Join(dir, "bad.conf"), out); err == nil || !strings.Contains(err.Error(), "bad.conf:1") {
+		t.Errorf("invalid server: got %v", err)
+	}
+}
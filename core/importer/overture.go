@@ -0,0 +1,277 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package importer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/import-yuefeng/smartDNS/core/common"
+)
+
+// Bundle names of converted overture configs
+const (
+	PrimaryBundle     = "Primary"
+	AlternativeBundle = "Alternative"
+)
+
+// overtureConfig covers overture 1.3 to 1.6, DomainFile, IPNetworkFile and HostsFile changed from paths to objects
+type overtureConfig struct {
+	BindAddress                 string
+	DebugHTTPAddress            string
+	DohEnabled                  bool
+	PrimaryDNS                  []*overtureUpstream
+	AlternativeDNS              []*overtureUpstream
+	OnlyPrimaryDNS              bool
+	IPv6UseAlternativeDNS       bool
+	AlternativeDNSConcurrent    bool
+	WhenPrimaryDNSAnswerNoneUse string
+	IPNetworkFile               json.RawMessage
+	DomainFile                  json.RawMessage
+	DomainBase64Decode          bool
+	HostsFile                   json.RawMessage
+	MinimumTTL                  int
+	DomainTTLFile               string
+	CacheSize                   int
+	CacheRedisUrl               string
+	RejectQType                 []uint16
+}
+
+type overtureUpstream struct {
+	Name             string
+	Address          string
+	Protocol         string
+	SOCKS5Address    string
+	Timeout          int
+	EDNSClientSubnet *struct {
+		Policy     string
+		ExternalIP string
+		NoCookie   bool
+	}
+}
+
+// overtureFiles is DomainFile and IPNetworkFile of overture 1.4 and later
+type overtureFiles struct {
+	Primary            string
+	Alternative        string
+	Matcher            string
+	PrimaryMatcher     string
+	AlternativeMatcher string
+}
+
+// Overture func convert overture config, rule files are written to dir
+// Upstreams become the Primary and Alternative bundles. Domain lists go to DNSFilter of their bundle, the primary
+// IP network list to the Primary filter. Queries matching neither go to Alternative like overture does when the
+// answer of primary DNS is not in the list, or to Primary if OnlyPrimaryDNS is set.
+func Overture(path string, dir string) (*Result, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	oc := new(overtureConfig)
+	if err := json.Unmarshal(b, oc); err != nil {
+		return nil, fmt.Errorf("Failed to parse overture config %s: %s", path, err)
+	}
+	srcDir := filepath.Dir(path)
+
+	r := newResult(dir)
+	c := r.Config
+	c.BindAddress = oc.BindAddress
+	c.DebugHTTPAddress = oc.DebugHTTPAddress
+	c.IPv6UseAlternativeDNS = oc.IPv6UseAlternativeDNS
+	c.MinimumTTL = oc.MinimumTTL
+	c.DomainTTLFile = resolvePath(oc.DomainTTLFile, srcDir)
+	c.CacheSize = oc.CacheSize
+	c.RejectQType = oc.RejectQType
+
+	if oc.DohEnabled {
+		r.warnf("DohEnabled: DNS over HTTPS server is not supported")
+	}
+	if oc.CacheRedisUrl != "" {
+		r.warnf("CacheRedisUrl: redis cache is not supported, the memory cache is used")
+	}
+	if oc.AlternativeDNSConcurrent {
+		r.warnf("AlternativeDNSConcurrent: all bundles are always queried concurrently when no domain list matches")
+	}
+	if oc.WhenPrimaryDNSAnswerNoneUse != "" {
+		r.warnf("WhenPrimaryDNSAnswerNoneUse: answers without addresses fall back to DefaultDNSBundle")
+	}
+
+	// domain and IP network files, as paths of overture 1.3 or objects of later versions
+	var domains, networks overtureFiles
+	domains.Matcher = "full-map"
+	var domainPath string
+	if json.Unmarshal(oc.DomainFile, &domainPath) == nil {
+		// overture 1.3 only has the alternative domain list, matched by suffix
+		domains = overtureFiles{Alternative: domainPath, Matcher: "suffix-tree"}
+	} else if len(oc.DomainFile) > 0 {
+		if err := json.Unmarshal(oc.DomainFile, &domains); err != nil {
+			return nil, fmt.Errorf("Invalid DomainFile: %s", err)
+		}
+	}
+	var networkPath string
+	if json.Unmarshal(oc.IPNetworkFile, &networkPath) == nil {
+		networks.Primary = networkPath
+	} else if len(oc.IPNetworkFile) > 0 {
+		if err := json.Unmarshal(oc.IPNetworkFile, &networks); err != nil {
+			return nil, fmt.Errorf("Invalid IPNetworkFile: %s", err)
+		}
+	}
+
+	var hostsPath string
+	if json.Unmarshal(oc.HostsFile, &hostsPath) != nil && len(oc.HostsFile) > 0 {
+		var hosts struct{ HostsFile, Finder string }
+		if err := json.Unmarshal(oc.HostsFile, &hosts); err != nil {
+			return nil, fmt.Errorf("Invalid HostsFile: %s", err)
+		}
+		hostsPath = hosts.HostsFile
+	}
+	if hostsPath != "" {
+		c.HostsFile = []string{resolvePath(hostsPath, srcDir)}
+	}
+
+	c.DefaultDNSBundle = AlternativeBundle
+	if oc.OnlyPrimaryDNS {
+		c.DefaultDNSBundle = PrimaryBundle
+	}
+	sides := []struct {
+		bundle    string
+		upstreams []*overtureUpstream
+		domain    string
+		matcher   string
+		network   string
+	}{
+		{PrimaryBundle, oc.PrimaryDNS, domains.Primary, domains.PrimaryMatcher, networks.Primary},
+		{AlternativeBundle, oc.AlternativeDNS, domains.Alternative, domains.AlternativeMatcher, networks.Alternative},
+	}
+	for _, side := range sides {
+		if oc.OnlyPrimaryDNS && side.bundle == AlternativeBundle {
+			break
+		}
+		var upstreams []*common.DNSUpstream
+		for i, u := range side.upstreams {
+			if u := r.overtureUpstream(fmt.Sprintf("%sDNS[%d]", side.bundle, i), u); u != nil {
+				upstreams = append(upstreams, u)
+			}
+		}
+		if len(upstreams) == 0 {
+			r.warnf("%sDNS: no upstream, bundle %s is not created", side.bundle, side.bundle)
+			if c.DefaultDNSBundle == side.bundle {
+				c.DefaultDNSBundle = ""
+			}
+			continue
+		}
+		c.DNSBunch[side.bundle] = upstreams
+
+		matcher := side.matcher
+		if matcher == "" {
+			matcher = domains.Matcher
+		}
+		f := &Filter{Matcher: matcher}
+		if matcher == "final" {
+			// final matches every name, the bundle answers everything no other list matches
+			f.Matcher = "suffix-tree"
+			c.DefaultDNSBundle = side.bundle
+		} else if side.domain != "" {
+			f.DomainFile = r.copyRules(strings.ToLower(side.bundle)+"_domain.txt", resolvePath(side.domain, srcDir), oc.DomainBase64Decode)
+		}
+		if side.network != "" {
+			f.IPNetworkFile = r.copyRules(strings.ToLower(side.bundle)+"_ip_network.txt", resolvePath(side.network, srcDir), false)
+		}
+		c.DNSFilter[side.bundle] = f
+	}
+	if c.DefaultDNSBundle == "" {
+		r.warnf("DefaultDNSBundle: no bundle to answer names that match no list")
+	}
+	return r, nil
+}
+
+// overtureUpstream func convert an upstream, nil if its protocol is not supported
+func (r *Result) overtureUpstream(path string, u *overtureUpstream) *common.DNSUpstream {
+	if u.Protocol == "https" {
+		r.warnf("%s: protocol https of %s is not supported, upstream is skipped", path, u.Name)
+		return nil
+	}
+	upstream := &common.DNSUpstream{
+		Name:          u.Name,
+		Address:       u.Address,
+		Protocol:      u.Protocol,
+		SOCKS5Address: u.SOCKS5Address,
+		Timeout:       u.Timeout,
+	}
+	if upstream.Protocol == "" {
+		upstream.Protocol = "udp"
+	}
+	if upstream.Timeout == 0 {
+		upstream.Timeout = 6
+	}
+	if ecs := u.EDNSClientSubnet; ecs != nil {
+		switch ecs.Policy {
+		case "", "disable":
+		case "auto", "manual":
+			r.warnf("%s: EDNSClientSubnet is kept in config, but queries are sent to upstreams without client subnet", path)
+			upstream.EDNSClientSubnet = &common.EDNSClientSubnet{Policy: common.ECSEnable, ExternalIP: ecs.ExternalIP}
+		default:
+			r.warnf("%s: unknown EDNSClientSubnet policy %q is ignored", path, ecs.Policy)
+		}
+		if ecs.NoCookie {
+			if upstream.EDNSClientSubnet == nil {
+				upstream.EDNSClientSubnet = &common.EDNSClientSubnet{Policy: common.ECSDisable}
+			}
+			upstream.EDNSClientSubnet.NoCookie = true
+		}
+	}
+	return upstream
+}
+
+// copyRules func copy a rule file of source config to the output directory, and return the new path
+// Base64 files are decoded as gfwlist and only plain domains are kept.
+func (r *Result) copyRules(name string, src string, isBase64 bool) string {
+	lines, err := readLines(src)
+	if err != nil {
+		r.warnf("%s: %s, rule file is skipped", name, err)
+		return ""
+	}
+	if isBase64 {
+		b, err := base64.StdEncoding.DecodeString(strings.Join(lines, ""))
+		if err != nil {
+			r.warnf("%s: failed to decode base64 of %s: %s, rule file is skipped", name, src, err)
+			return ""
+		}
+		lines = gfwlistDomains(strings.Split(string(b), "\n"))
+	}
+	return r.addFile(name, lines)
+}
+
+// gfwlistDomains func extract domains of gfwlist rules, exceptions, regular expressions and URL patterns are dropped
+func gfwlistDomains(rules []string) (domains []string) {
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if rule == "" || strings.HasPrefix(rule, "!") || strings.HasPrefix(rule, "[") ||
+			strings.HasPrefix(rule, "@@") || strings.HasPrefix(rule, "/") {
+			continue
+		}
+		for _, prefix := range []string{"||", "|https://", "|http://", "https://", "http://", "."} {
+			rule = strings.TrimPrefix(rule, prefix)
+		}
+		if i := strings.IndexAny(rule, "/:^"); i >= 0 {
+			rule = rule[:i]
+		}
+		rule = strings.ToLower(rule)
+		if strings.ContainsAny(rule, "*%|") || !strings.Contains(rule, ".") || seen[rule] {
+			continue
+		}
+		seen[rule] = true
+		domains = append(domains, rule)
+	}
+	return
+}
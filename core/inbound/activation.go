@@ -0,0 +1,118 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package inbound
+
+import (
+	"net"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/import-yuefeng/smartDNS/core/common"
+	"github.com/import-yuefeng/smartDNS/core/systemd"
+)
+
+// inheritedSocket is a socket passed by systemd socket activation, it is used by the listener it matches
+type inheritedSocket struct {
+	name     string
+	listener net.Listener
+	conn     net.PacketConn
+	used     bool
+}
+
+// inheritSockets func convert sockets passed by systemd to listeners and packet connections
+func inheritSockets() (inherited []*inheritedSocket) {
+	for _, s := range systemd.Sockets() {
+		is := &inheritedSocket{name: s.Name}
+		var err error
+		if is.listener, err = net.FileListener(s.File); err != nil {
+			is.listener = nil
+			if is.conn, err = net.FilePacketConn(s.File); err != nil {
+				log.Warnf("Socket %s passed by systemd is neither a stream nor a datagram socket: %s", s.Name, err)
+			}
+		}
+		// the socket is duplicated by net
+		s.File.Close()
+		if is.listener != nil || is.conn != nil {
+			inherited = append(inherited, is)
+		}
+	}
+	return
+}
+
+// addr func return local address of the socket
+func (is *inheritedSocket) addr() net.Addr {
+	if is.listener != nil {
+		return is.listener.Addr()
+	}
+	return is.conn.LocalAddr()
+}
+
+// matches func return true if the socket has the name of listener, or is bound to its address
+func (is *inheritedSocket) matches(conf *common.Listener) bool {
+	if is.name == conf.Name {
+		return true
+	}
+	want, err := net.ResolveTCPAddr("tcp", conf.Address)
+	if err != nil {
+		return false
+	}
+	var ip net.IP
+	var port int
+	switch a := is.addr().(type) {
+	case *net.TCPAddr:
+		ip, port = a.IP, a.Port
+	case *net.UDPAddr:
+		ip, port = a.IP, a.Port
+	default:
+		return false
+	}
+	if port != want.Port {
+		return false
+	}
+	unspecified := func(ip net.IP) bool { return ip == nil || ip.IsUnspecified() }
+	return ip.Equal(want.IP) || (unspecified(ip) && unspecified(want.IP))
+}
+
+// takeListener func return the unused inherited stream socket of conf, nil if there is none
+func (s *Server) takeListener(conf *common.Listener) net.Listener {
+	for _, is := range s.inherited {
+		if !is.used && is.listener != nil && is.matches(conf) {
+			is.used = true
+			log.Infof("Listener %s uses stream socket %s passed by systemd", conf.Name, is.name)
+			return is.listener
+		}
+	}
+	return nil
+}
+
+// takePacketConn func return the unused inherited datagram socket of conf, nil if there is none
+func (s *Server) takePacketConn(conf *common.Listener) net.PacketConn {
+	for _, is := range s.inherited {
+		if !is.used && is.conn != nil && is.matches(conf) {
+			is.used = true
+			log.Infof("Listener %s uses datagram socket %s passed by systemd", conf.Name, is.name)
+			return is.conn
+		}
+	}
+	return nil
+}
+
+// closeUnusedSockets func close inherited sockets that match no listener
+func (s *Server) closeUnusedSockets() {
+	for _, is := range s.inherited {
+		if is.used {
+			continue
+		}
+		log.Warnf("Socket %s (%s) passed by systemd matches no listener, it is closed", is.name, is.addr())
+		if is.listener != nil {
+			is.listener.Close()
+		} else {
+			is.conn.Close()
+		}
+	}
+	s.inherited = nil
+}
@@ -0,0 +1,200 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package inbound
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/import-yuefeng/smartDNS/core/metrics"
+)
+
+// startTime is shown as uptime by dashboard
+var startTime = time.Now()
+
+// dashboardTopSize is count of names in top lists of dashboard
+const dashboardTopSize = 20
+
+// dashboardCacheLimit bounds entries returned by one cache search
+const dashboardCacheLimit = 500
+
+type dashboardStats struct {
+	Uptime        float64              `json:"uptime"`
+	Queries       float64              `json:"queries"`
+	Blocked       float64              `json:"blocked"`
+	CacheHits     float64              `json:"cache_hits"`
+	CacheMisses   float64              `json:"cache_misses"`
+	CacheSize     int                  `json:"cache_size"`
+	CacheCapacity int                  `json:"cache_capacity"`
+	TopQueried    []metrics.TopEntry   `json:"top_queried"`
+	TopBlocked    []metrics.TopEntry   `json:"top_blocked"`
+	Bundles       []*dashboardBundle   `json:"bundles"`
+	Upstreams     []*dashboardUpstream `json:"upstreams"`
+}
+
+type dashboardBundle struct {
+	Name     string  `json:"name"`
+	Requests float64 `json:"requests"`
+	// Latency is average in milliseconds of upstream responses
+	Latency   float64 `json:"latency"`
+	responses uint64
+}
+
+type dashboardUpstream struct {
+	Bundle    string  `json:"bundle"`
+	Name      string  `json:"name"`
+	Address   string  `json:"address"`
+	Active    bool    `json:"active"`
+	Requests  float64 `json:"requests"`
+	Errors    float64 `json:"errors"`
+	Responses uint64  `json:"responses"`
+	// Latency is average in milliseconds of responses
+	Latency float64 `json:"latency"`
+}
+
+// Dashboard func serve web page of dashboard, the page polls DashboardStats
+func (s *Server) Dashboard(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, dashboardHTML)
+}
+
+// DashboardStats func serve counters as JSON, the page computes rates from two polls
+func (s *Server) DashboardStats(w http.ResponseWriter, req *http.Request) {
+	dispatcher := s.Dispatcher()
+	stats := &dashboardStats{
+		Uptime:      time.Since(startTime).Seconds(),
+		Queries:     metrics.Queries.Total(),
+		Blocked:     metrics.BlockedQueries.Total(),
+		CacheHits:   metrics.CacheHits.Total(),
+		CacheMisses: metrics.CacheMisses.Total(),
+		TopQueried:  metrics.TopQueried.List(dashboardTopSize),
+		TopBlocked:  metrics.TopBlocked.List(dashboardTopSize),
+	}
+	if dispatcher.Cache != nil {
+		stats.CacheSize, stats.CacheCapacity = dispatcher.Cache.Size(), dispatcher.Cache.Capacity()
+	}
+
+	// upstreams of current dispatcher are active, the others are disabled or removed by reload
+	upstreams := make(map[string]*dashboardUpstream)
+	upstream := func(bundle, name string) *dashboardUpstream {
+		key := bundle + "\xff" + name
+		if u, ok := upstreams[key]; ok {
+			return u
+		}
+		u := &dashboardUpstream{Bundle: bundle, Name: name}
+		upstreams[key] = u
+		return u
+	}
+	for bundle, ul := range dispatcher.DNSBunch {
+		for _, du := range ul {
+			u := upstream(bundle, du.Name)
+			u.Address, u.Active = du.Address, true
+		}
+	}
+	for _, sample := range metrics.UpstreamRequests.Samples() {
+		upstream(sample.Labels[0], sample.Labels[1]).Requests = sample.Value
+	}
+	for _, sample := range metrics.UpstreamErrors.Samples() {
+		upstream(sample.Labels[0], sample.Labels[1]).Errors = sample.Value
+	}
+
+	bundles := make(map[string]*dashboardBundle)
+	bundle := func(name string) *dashboardBundle {
+		if b, ok := bundles[name]; ok {
+			return b
+		}
+		b := &dashboardBundle{Name: name}
+		bundles[name] = b
+		return b
+	}
+	for name := range dispatcher.DNSBunch {
+		bundle(name)
+	}
+	for _, sample := range metrics.BundleRequests.Samples() {
+		bundle(sample.Labels[0]).Requests = sample.Value
+	}
+	for _, sample := range metrics.UpstreamDuration.Samples() {
+		u := upstream(sample.Labels[0], sample.Labels[1])
+		u.Responses = sample.Count
+		if sample.Count > 0 {
+			u.Latency = sample.Value * 1000 / float64(sample.Count)
+		}
+		// average of bundle is summed up first, and divided below
+		b := bundle(sample.Labels[0])
+		b.Latency += sample.Value * 1000
+		b.responses += sample.Count
+	}
+
+	for _, b := range bundles {
+		if b.responses > 0 {
+			b.Latency /= float64(b.responses)
+		}
+		stats.Bundles = append(stats.Bundles, b)
+	}
+	sort.Slice(stats.Bundles, func(i, j int) bool { return stats.Bundles[i].Name < stats.Bundles[j].Name })
+	for _, u := range upstreams {
+		stats.Upstreams = append(stats.Upstreams, u)
+	}
+	sort.Slice(stats.Upstreams, func(i, j int) bool {
+		a, b := stats.Upstreams[i], stats.Upstreams[j]
+		if a.Bundle != b.Bundle {
+			return a.Bundle < b.Bundle
+		}
+		return a.Name < b.Name
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// DashboardCache func serve cached entries whose name contains q as JSON
+func (s *Server) DashboardCache(w http.ResponseWriter, req *http.Request) {
+	c := s.Dispatcher().Cache
+	if c == nil {
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, "error: cache not enabled")
+		return
+	}
+	limit := dashboardCacheLimit
+	if n, err := strconv.Atoi(req.URL.Query().Get("limit")); err == nil && n > 0 && n < limit {
+		limit = n
+	}
+
+	type entry struct {
+		Name    string   `json:"name"`
+		Type    string   `json:"type"`
+		TTL     int      `json:"ttl"`
+		Rcode   string   `json:"rcode"`
+		Bundle  string   `json:"bundle"`
+		Answers []string `json:"answers"`
+	}
+	rs := []entry{}
+	for _, e := range c.Entries(strings.ToLower(req.URL.Query().Get("q")), limit) {
+		r := entry{
+			Name:    e.Question.Name,
+			Type:    metrics.QType(e.Question.Qtype),
+			TTL:     int(time.Until(e.Expiration).Seconds()),
+			Rcode:   metrics.Rcode(e.Rcode),
+			Bundle:  e.Bundle,
+			Answers: []string{},
+		}
+		for _, rr := range e.Answer {
+			r.Answers = append(r.Answers, dns.TypeToString[rr.Header().Rrtype]+" "+rr.String()[len(rr.Header().String()):])
+		}
+		rs = append(rs, r)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rs)
+}
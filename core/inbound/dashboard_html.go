@@ -0,0 +1,235 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package inbound
+
+// dashboardHTML is the page served by /dashboard, it has no external assets
+// It polls /dashboard/stats, searches /dashboard/cache, resolves by /explain and flushes by admin API.
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>smartDNS</title>
+<style>
+body { font: 14px/1.4 -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 0; background: #f4f5f7; color: #222; }
+header { background: #24292e; color: #fff; padding: 10px 20px; display: flex; align-items: center; justify-content: space-between; }
+header h1 { font-size: 18px; margin: 0; }
+main { padding: 16px 20px; display: grid; grid-template-columns: repeat(auto-fit, minmax(460px, 1fr)); gap: 16px; }
+section { background: #fff; border-radius: 4px; box-shadow: 0 1px 2px rgba(0,0,0,.1); padding: 12px 16px; overflow-x: auto; }
+section.wide { grid-column: 1 / -1; }
+h2 { font-size: 15px; margin: 0 0 8px; }
+.cards { display: flex; flex-wrap: wrap; gap: 24px; }
+.card b { display: block; font-size: 22px; }
+.card span { color: #666; font-size: 12px; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 3px 8px 3px 0; border-bottom: 1px solid #eee; vertical-align: top; }
+th { color: #666; font-weight: normal; font-size: 12px; }
+td.num, th.num { text-align: right; }
+.dot { display: inline-block; width: 8px; height: 8px; border-radius: 50%; margin-right: 6px; }
+.ok { background: #2da44e; } .warn { background: #d4a72c; } .bad { background: #cf222e; } .off { background: #aaa; }
+input, select, button { font: inherit; padding: 3px 6px; }
+pre { background: #f6f8fa; padding: 8px; margin: 8px 0 0; white-space: pre-wrap; }
+canvas { width: 100%; height: 60px; }
+.muted { color: #888; }
+.error { color: #cf222e; }
+#cache td { white-space: pre-line; }
+</style>
+</head>
+<body>
+<header>
+<h1>smartDNS</h1>
+<label class="muted">Admin token <input id="token" type="password" size="16"></label>
+</header>
+<main>
+<section class="wide">
+<div class="cards">
+<div class="card"><b id="qps">-</b><span>queries/s</span></div>
+<div class="card"><b id="queries">-</b><span>queries</span></div>
+<div class="card"><b id="hit">-</b><span>cache hit ratio (total <span id="hitTotal">-</span>)</span></div>
+<div class="card"><b id="cacheSize">-</b><span>cache entries</span></div>
+<div class="card"><b id="blocked">-</b><span>blocked queries</span></div>
+<div class="card"><b id="uptime">-</b><span>uptime</span></div>
+</div>
+<canvas id="chart" width="1200" height="60"></canvas>
+</section>
+<section><h2>Top queried domains</h2><table id="topQueried"></table></section>
+<section><h2>Top blocked domains</h2><table id="topBlocked"></table></section>
+<section><h2>Bundles</h2><table id="bundles"></table></section>
+<section><h2>Upstreams</h2><table id="upstreams"></table></section>
+<section class="wide">
+<h2>Test resolve</h2>
+<form id="resolve">
+<input id="name" placeholder="www.example.com" size="40" required>
+<select id="type"><option>A</option><option>AAAA</option><option>CNAME</option><option>MX</option><option>TXT</option><option>NS</option><option>PTR</option><option>SRV</option><option>SOA</option></select>
+<button>Resolve</button>
+</form>
+<pre id="explain" hidden></pre>
+</section>
+<section class="wide">
+<h2>Cache</h2>
+<form id="search">
+<input id="q" placeholder="search name" size="40">
+<button>Search</button>
+<button type="button" id="flushAll">Flush all</button>
+<span id="cacheMsg" class="muted"></span>
+</form>
+<table id="cache"></table>
+</section>
+</main>
+<script>
+"use strict";
+var prev = null, points = [];
+var $ = function (id) { return document.getElementById(id); };
+
+function el(tag, text, cls) {
+  var e = document.createElement(tag);
+  if (text !== undefined) e.textContent = text;
+  if (cls) e.className = cls;
+  return e;
+}
+
+function fill(table, head, rows) {
+  table.textContent = "";
+  var tr = el("tr");
+  head.forEach(function (h) { tr.appendChild(el("th", h[0], h[1])); });
+  table.appendChild(tr);
+  if (rows.length === 0) {
+    var td = el("td", "none", "muted");
+    td.colSpan = head.length;
+    table.appendChild(el("tr")).appendChild(td);
+  }
+  rows.forEach(function (row) {
+    var tr = el("tr");
+    row.forEach(function (c, i) {
+      var td = c instanceof Node ? el("td") : el("td", c, head[i][1]);
+      if (c instanceof Node) td.appendChild(c);
+      tr.appendChild(td);
+    });
+    table.appendChild(tr);
+  });
+}
+
+function pct(a, b) { return b > 0 ? (100 * a / b).toFixed(1) + "%" : "-"; }
+function ms(v) { return v > 0 ? v.toFixed(1) + " ms" : "-"; }
+function duration(s) {
+  var d = Math.floor(s / 86400), h = Math.floor(s % 86400 / 3600), m = Math.floor(s % 3600 / 60);
+  return d > 0 ? d + "d " + h + "h" : h > 0 ? h + "h " + m + "m" : m + "m " + Math.floor(s % 60) + "s";
+}
+
+function token() { return $("token").value; }
+$("token").value = sessionStorage.getItem("smartdns-token") || "";
+$("token").onchange = function () { sessionStorage.setItem("smartdns-token", token()); };
+
+function request(method, url) {
+  var headers = {};
+  if (token()) headers.Authorization = "Bearer " + token();
+  return fetch(url, { method: method, headers: headers }).then(function (r) {
+    return r.text().then(function (body) {
+      if (!r.ok) throw new Error(body || r.statusText);
+      return body;
+    });
+  });
+}
+
+function drawChart() {
+  var c = $("chart"), ctx = c.getContext("2d"), max = Math.max.apply(null, points.concat([1]));
+  ctx.clearRect(0, 0, c.width, c.height);
+  ctx.strokeStyle = "#0969da";
+  ctx.lineWidth = 2;
+  ctx.beginPath();
+  points.forEach(function (v, i) {
+    var x = c.width * i / 59, y = c.height - 4 - (c.height - 8) * v / max;
+    if (i === 0) ctx.moveTo(x, y); else ctx.lineTo(x, y);
+  });
+  ctx.stroke();
+}
+
+function update(s) {
+  var now = Date.now();
+  var hits = s.cache_hits, misses = s.cache_misses;
+  if (prev) {
+    var qps = (s.queries - prev.s.queries) * 1000 / (now - prev.t);
+    $("qps").textContent = qps.toFixed(1);
+    points.push(qps);
+    if (points.length > 60) points.shift();
+    drawChart();
+    $("hit").textContent = pct(hits - prev.s.cache_hits, hits - prev.s.cache_hits + misses - prev.s.cache_misses);
+  }
+  $("queries").textContent = s.queries;
+  $("hitTotal").textContent = pct(hits, hits + misses);
+  $("cacheSize").textContent = s.cache_capacity > 0 ? s.cache_size + " / " + s.cache_capacity : "disabled";
+  $("blocked").textContent = s.blocked;
+  $("uptime").textContent = duration(s.uptime);
+
+  var top = function (l) { return (l || []).map(function (e) { return [e.name, e.count]; }); };
+  fill($("topQueried"), [["Domain"], ["Queries", "num"]], top(s.top_queried));
+  fill($("topBlocked"), [["Domain"], ["Queries", "num"]], top(s.top_blocked));
+  fill($("bundles"), [["Bundle"], ["Requests", "num"], ["Avg latency", "num"]], (s.bundles || []).map(function (b) {
+    return [b.name, b.requests, ms(b.latency)];
+  }));
+
+  var last = {};
+  if (prev) (prev.s.upstreams || []).forEach(function (u) { last[u.bundle + "/" + u.name] = u; });
+  fill($("upstreams"), [["Upstream"], ["Address"], ["Requests", "num"], ["Errors", "num"], ["Avg latency", "num"]], (s.upstreams || []).map(function (u) {
+    // health is judged by errors since last poll, or in total before the second poll
+    var p = last[u.bundle + "/" + u.name] || { requests: 0, errors: 0 };
+    var req = u.requests - p.requests, err = u.errors - p.errors;
+    if (!prev) { req = u.requests; err = u.errors; }
+    var state = !u.active ? "off" : req === 0 ? "ok" : err / req < 0.1 ? "ok" : err / req < 0.5 ? "warn" : "bad";
+    var name = el("span");
+    name.appendChild(el("span", undefined, "dot " + state));
+    name.appendChild(document.createTextNode(u.bundle + " / " + u.name + (u.active ? "" : " (disabled)")));
+    return [name, u.address || "-", u.requests, u.errors + " (" + pct(u.errors, u.requests) + ")", ms(u.latency)];
+  }));
+  prev = { s: s, t: now };
+}
+
+function poll() {
+  fetch("/dashboard/stats").then(function (r) { return r.json(); }).then(update).catch(function () {}).then(function () {
+    setTimeout(poll, 2000);
+  });
+}
+
+function search() {
+  var msg = $("cacheMsg");
+  return fetch("/dashboard/cache?q=" + encodeURIComponent($("q").value.toLowerCase())).then(function (r) {
+    return r.ok ? r.json() : r.text().then(function (t) { throw new Error(t); });
+  }).then(function (list) {
+    fill($("cache"), [["Name"], ["Type"], ["TTL", "num"], ["Rcode"], ["Bundle"], ["Answers"], [""]], list.map(function (e) {
+      var flush = el("button", "Flush");
+      flush.onclick = function () {
+        request("DELETE", "/admin/cache?name=" + encodeURIComponent(e.name) + "&type=" + encodeURIComponent(e.type)).then(search).catch(function (err) {
+          msg.textContent = err.message;
+        });
+      };
+      return [e.name, e.type, e.ttl, e.rcode, e.bundle || "-", e.answers.join("\n"), flush];
+    }));
+    msg.textContent = list.length + " entries";
+  }).catch(function (err) { msg.textContent = err.message; });
+}
+
+$("search").onsubmit = function (ev) { ev.preventDefault(); search(); };
+$("flushAll").onclick = function () {
+  if (!confirm("Flush the whole cache?")) return;
+  request("DELETE", "/admin/cache").then(search).catch(function (err) { $("cacheMsg").textContent = err.message; });
+};
+$("resolve").onsubmit = function (ev) {
+  ev.preventDefault();
+  var out = $("explain");
+  out.hidden = false;
+  out.textContent = "...";
+  fetch("/explain?name=" + encodeURIComponent($("name").value) + "&type=" + $("type").value).then(function (r) {
+    return r.text();
+  }).then(function (t) { out.textContent = t; });
+};
+
+poll();
+search();
+</script>
+</body>
+</html>
+`
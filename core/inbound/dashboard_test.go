@@ -0,0 +1,113 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package inbound
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/import-yuefeng/smartDNS/core/cache"
+	"github.com/import-yuefeng/smartDNS/core/common"
+	"github.com/import-yuefeng/smartDNS/core/metrics"
+	"github.com/import-yuefeng/smartDNS/core/outbound"
+)
+
+func TestServer_DashboardStats(t *testing.T) {
+	// bundle names are not used by other tests, metrics are global
+	d := &outbound.Dispatcher{DNSBunch: map[string][]*common.DNSUpstream{
+		"dash-a": {{Name: "up1", Address: "10.0.0.1:53"}},
+		"dash-b": {},
+	}}
+	s := NewServer(nil, "", d, nil)
+	metrics.UpstreamDuration.Observe(0.010, "dash-a", "up1")
+	metrics.UpstreamDuration.Observe(0.030, "dash-a", "up1")
+	// upstreams removed by reload or disabled by admin API are kept, but not active
+	metrics.UpstreamDuration.Observe(0.060, "dash-a", "gone")
+	metrics.UpstreamRequests.Inc("dash-a", "gone")
+
+	w := httptest.NewRecorder()
+	s.DashboardStats(w, httptest.NewRequest(http.MethodGet, "/dashboard/stats", nil))
+	var stats dashboardStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+
+	latency := make(map[string]float64)
+	for _, b := range stats.Bundles {
+		latency[b.Name] = b.Latency
+	}
+	if _, ok := latency["dash-b"]; !ok {
+		t.Error("bundle without responses is not listed")
+	}
+	if l := latency["dash-a"]; math.Abs(l-100.0/3) > 1e-6 {
+		t.Errorf("latency of dash-a is %f ms, want average of all its responses", l)
+	}
+
+	upstreams := make(map[string]*dashboardUpstream)
+	for _, u := range stats.Upstreams {
+		if u.Bundle == "dash-a" {
+			upstreams[u.Name] = u
+		}
+	}
+	if u := upstreams["up1"]; u == nil || !u.Active || u.Address != "10.0.0.1:53" || u.Responses != 2 || math.Abs(u.Latency-20) > 1e-6 {
+		t.Errorf("unexpected up1: %+v", u)
+	}
+	if u := upstreams["gone"]; u == nil || u.Active || u.Requests != 1 || math.Abs(u.Latency-60) > 1e-6 {
+		t.Errorf("unexpected gone: %+v", u)
+	}
+}
+
+func TestServer_DashboardCache(t *testing.T) {
+	s := NewServer(nil, "", &outbound.Dispatcher{}, nil)
+	w := httptest.NewRecorder()
+	s.DashboardCache(w, httptest.NewRequest(http.MethodGet, "/dashboard/cache", nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("cache disabled: got status %d", w.Code)
+	}
+
+	c := cache.New(16)
+	for _, name := range []string{"www.example.com.", "mail.example.com.", "www.example.net."} {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		rr, _ := dns.NewRR(name + " 300 IN A 10.0.0.1")
+		m.Answer = append(m.Answer, rr)
+		c.Insert(cache.Key(m.Question[0]), m, 300, "dash", name)
+	}
+	s.SetDispatcher(&outbound.Dispatcher{Cache: c}, nil)
+
+	for url, want := range map[string]int{
+		"/dashboard/cache":                        3,
+		"/dashboard/cache?q=EXAMPLE.COM":          2,
+		"/dashboard/cache?q=example.com&limit=1":  1,
+		"/dashboard/cache?q=example.com&limit=-1": 2,
+		"/dashboard/cache?q=nothing":              0,
+	} {
+		w := httptest.NewRecorder()
+		s.DashboardCache(w, httptest.NewRequest(http.MethodGet, url, nil))
+		var entries []struct {
+			Name    string   `json:"name"`
+			Bundle  string   `json:"bundle"`
+			Answers []string `json:"answers"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&entries); err != nil {
+			t.Fatalf("%s: %s", url, err)
+		}
+		if len(entries) != want {
+			t.Errorf("%s: got %d entries, want %d", url, len(entries), want)
+		}
+		for _, e := range entries {
+			if e.Bundle != "dash" || len(e.Answers) != 1 || e.Answers[0] != "A 10.0.0.1" {
+				t.Errorf("%s: unexpected entry %+v", url, e)
+			}
+		}
+	}
+}
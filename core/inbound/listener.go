@@ -0,0 +1,238 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package inbound
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/import-yuefeng/smartDNS/core/common"
+)
+
+// dohContentType is the media type of DNS over HTTPS messages (RFC 8484)
+const dohContentType = "application/dns-message"
+
+// listener serves queries received on one address and protocol of a Listener
+type listener struct {
+	s        *Server
+	conf     *common.Listener
+	protocol string
+}
+
+// dohServer is the HTTP server of a DNS over HTTPS listener
+type dohServer struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+// listen func bind every protocol of conf, the servers are started by Start
+func (s *Server) listen(conf *common.Listener) error {
+	var tlsConfig *tls.Config
+	if conf.Serves(common.ListenTLS) || conf.Serves(common.ListenHTTPS) {
+		cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			return fmt.Errorf("Failed to load certificate of listener %s: %s", conf.Name, err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	for _, protocol := range conf.Protocols {
+		h := &listener{s: s, conf: conf, protocol: protocol}
+		mux := dns.NewServeMux()
+		mux.Handle(".", h)
+
+		switch protocol {
+		case common.ListenUDP:
+			pc := s.takePacketConn(conf)
+			if pc == nil {
+				var err error
+				if pc, err = net.ListenPacket("udp", conf.Address); err != nil {
+					return fmt.Errorf("Listening on udp %s failed: %s", conf.Address, err)
+				}
+			}
+			s.dnsServers = append(s.dnsServers, &dns.Server{PacketConn: pc, Handler: mux})
+		case common.ListenTCP:
+			l, err := s.listenStream(conf, protocol)
+			if err != nil {
+				return err
+			}
+			s.dnsServers = append(s.dnsServers, &dns.Server{Listener: l, Handler: mux})
+		case common.ListenTLS:
+			l, err := s.listenStream(conf, protocol)
+			if err != nil {
+				return err
+			}
+			s.dnsServers = append(s.dnsServers, &dns.Server{Listener: tls.NewListener(l, tlsConfig), Handler: mux})
+		case common.ListenHTTPS:
+			l, err := s.listenStream(conf, protocol)
+			if err != nil {
+				return err
+			}
+			httpMux := http.NewServeMux()
+			httpMux.Handle(conf.Path, h)
+			s.dohServers = append(s.dohServers, &dohServer{
+				server:   &http.Server{Handler: httpMux, TLSConfig: tlsConfig.Clone()},
+				listener: l,
+			})
+		default:
+			return fmt.Errorf("Unknown protocol %s of listener %s", protocol, conf.Name)
+		}
+	}
+	return nil
+}
+
+// listenStream func return the inherited stream socket of conf, or bind a new one
+func (s *Server) listenStream(conf *common.Listener, protocol string) (net.Listener, error) {
+	if l := s.takeListener(conf); l != nil {
+		return l, nil
+	}
+	l, err := net.Listen("tcp", conf.Address)
+	if err != nil {
+		return nil, fmt.Errorf("Listening on %s %s failed: %s", protocol, conf.Address, err)
+	}
+	return l, nil
+}
+
+// Close func close sockets bound by Listen, it is used instead of Shutdown if Serve is not called
+func (s *Server) Close() {
+	s.closeListeners()
+}
+
+// closeListeners func close sockets bound by Listen when starting fails before serving
+func (s *Server) closeListeners() {
+	for _, ds := range s.dnsServers {
+		if ds.PacketConn != nil {
+			ds.PacketConn.Close()
+		}
+		if ds.Listener != nil {
+			ds.Listener.Close()
+		}
+	}
+	for _, ds := range s.dohServers {
+		ds.listener.Close()
+	}
+	if s.httpListener != nil {
+		s.httpListener.Close()
+	}
+	s.dnsServers, s.dohServers, s.httpListener = nil, nil, nil
+	s.closeUnusedSockets()
+}
+
+// ServeHTTP func answer DNS over HTTPS query, sent by GET with base64url "dns" parameter or by POST body
+func (l *listener) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var b []byte
+	var err error
+	switch req.Method {
+	case http.MethodGet:
+		b, err = base64.RawURLEncoding.DecodeString(req.URL.Query().Get("dns"))
+	case http.MethodPost:
+		if req.Header.Get("Content-Type") != dohContentType {
+			http.Error(w, "content type must be "+dohContentType, http.StatusUnsupportedMediaType)
+			return
+		}
+		b, err = ioutil.ReadAll(io.LimitReader(req.Body, dns.MaxMsgSize))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := new(dns.Msg)
+	if err == nil {
+		err = q.Unpack(b)
+	}
+	if err != nil || len(q.Question) == 0 {
+		http.Error(w, "invalid DNS message", http.StatusBadRequest)
+		return
+	}
+
+	dw := &dohWriter{remote: remoteAddr(req)}
+	if addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		dw.local = addr
+	}
+	l.ServeDNS(dw, q)
+	if dw.msg == nil {
+		// dropped by RejectQType
+		http.Error(w, "query dropped", http.StatusForbidden)
+		return
+	}
+
+	out, err := dw.msg.Pack()
+	if err != nil {
+		log.Warnf("Failed to pack DNS over HTTPS response: %s", err)
+		http.Error(w, "failed to pack response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", dohContentType)
+	w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(minTTL(dw.msg))))
+	w.Write(out)
+}
+
+// remoteAddr func return client address of HTTP request as TCP address
+func remoteAddr(req *http.Request) net.Addr {
+	if addr, err := net.ResolveTCPAddr("tcp", req.RemoteAddr); err == nil {
+		return addr
+	}
+	return &net.TCPAddr{}
+}
+
+// minTTL func return the smallest TTL of answer and authority records, 0 if there is none
+func minTTL(m *dns.Msg) uint32 {
+	var ttl uint32
+	first := true
+	for _, rrs := range [][]dns.RR{m.Answer, m.Ns} {
+		for _, rr := range rrs {
+			if first || rr.Header().Ttl < ttl {
+				ttl, first = rr.Header().Ttl, false
+			}
+		}
+	}
+	return ttl
+}
+
+// dohWriter keeps the response of a DNS over HTTPS query, it is written by ServeHTTP
+type dohWriter struct {
+	local  net.Addr
+	remote net.Addr
+	msg    *dns.Msg
+}
+
+func (w *dohWriter) LocalAddr() net.Addr {
+	if w.local == nil {
+		return &net.TCPAddr{}
+	}
+	return w.local
+}
+
+func (w *dohWriter) RemoteAddr() net.Addr { return w.remote }
+
+func (w *dohWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+func (w *dohWriter) Write(b []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return 0, err
+	}
+	w.msg = m
+	return len(b), nil
+}
+
+func (w *dohWriter) Close() error        { return nil }
+func (w *dohWriter) TsigStatus() error   { return nil }
+func (w *dohWriter) TsigTimersOnly(bool) {}
+func (w *dohWriter) Hijack()             {}
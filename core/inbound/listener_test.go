@@ -0,0 +1,177 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package inbound
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/import-yuefeng/smartDNS/core/common"
+	"github.com/import-yuefeng/smartDNS/core/hosts"
+	"github.com/import-yuefeng/smartDNS/core/metrics"
+	"github.com/import-yuefeng/smartDNS/core/outbound"
+)
+
+func newTestListener(t *testing.T, conf *common.Listener) *listener {
+	f, err := ioutil.TempFile("", "hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("10.0.0.1 nas.lan\n")
+	f.Close()
+
+	h, err := hosts.New(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.Init(); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer([]*common.Listener{conf}, "", &outbound.Dispatcher{Hosts: h}, nil)
+	return &listener{s: s, conf: conf, protocol: common.ListenHTTPS}
+}
+
+func dohRequest(l *listener, method string, qtype uint16, remote string) (*httptest.ResponseRecorder, *dns.Msg) {
+	q := new(dns.Msg)
+	q.SetQuestion("nas.lan.", qtype)
+	b, _ := q.Pack()
+
+	var req *http.Request
+	if method == http.MethodGet {
+		req = httptest.NewRequest(method, "/dns-query?dns="+base64.RawURLEncoding.EncodeToString(b), nil)
+	} else {
+		req = httptest.NewRequest(method, "/dns-query", bytes.NewReader(b))
+		req.Header.Set("Content-Type", dohContentType)
+	}
+	req.RemoteAddr = remote
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, req)
+
+	resp := new(dns.Msg)
+	if resp.Unpack(w.Body.Bytes()) != nil {
+		resp = nil
+	}
+	return w, resp
+}
+
+func TestListener_DoH(t *testing.T) {
+	l := newTestListener(t, &common.Listener{
+		Address:     "127.0.0.1:443",
+		Protocols:   []string{common.ListenHTTPS},
+		ACL:         []string{"192.168.0.0/16", "!192.168.9.0/24"},
+		RejectQType: []uint16{dns.TypeANY},
+	})
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		w, resp := dohRequest(l, method, dns.TypeA, "192.168.1.2:50000")
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != dohContentType {
+			t.Fatalf("%s: status %d, content type %q", method, w.Code, w.Header().Get("Content-Type"))
+		}
+		if resp == nil || len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "10.0.0.1" {
+			t.Errorf("%s: unexpected answer %v", method, resp)
+		}
+		if w.Header().Get("Cache-Control") != "max-age=3600" {
+			t.Errorf("%s: Cache-Control %q", method, w.Header().Get("Cache-Control"))
+		}
+	}
+
+	for _, remote := range []string{"10.1.1.1:50000", "192.168.9.9:50000"} {
+		if _, resp := dohRequest(l, http.MethodGet, dns.TypeA, remote); resp == nil || resp.Rcode != dns.RcodeRefused {
+			t.Errorf("%s: query is not refused by ACL: %v", remote, resp)
+		}
+	}
+	if w, _ := dohRequest(l, http.MethodGet, dns.TypeANY, "192.168.1.2:50000"); w.Code != http.StatusForbidden {
+		t.Errorf("rejected query type: status %d", w.Code)
+	}
+	if w, _ := dohRequest(l, http.MethodPut, dns.TypeA, "192.168.1.2:50000"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT: status %d", w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dns-query?dns=AAAA", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid message: status %d", w.Code)
+	}
+
+	var handled uint64
+	for _, sample := range metrics.QueryDuration.Samples() {
+		if sample.Labels[0] == l.conf.Name && sample.Labels[1] == common.ListenHTTPS {
+			handled = sample.Count
+		}
+	}
+	if handled != 5 {
+		t.Errorf("handling time of %d queries is observed, want 5", handled)
+	}
+}
+
+func TestServer_InheritedSockets(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unused, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(nil, "", nil, nil)
+	s.inherited = []*inheritedSocket{
+		{name: "tcp", listener: tcp},
+		{name: "udp", conn: udp},
+		{name: "dot", listener: named},
+		{name: "unused", conn: unused},
+	}
+	plain := &common.Listener{Address: tcp.Addr().String()}
+	plain.Init()
+	if l := s.takeListener(plain); l != tcp {
+		t.Errorf("stream socket is not matched by address: %v", l)
+	}
+	if l := s.takeListener(plain); l != nil {
+		t.Errorf("stream socket is taken twice")
+	}
+	dot := &common.Listener{Name: "dot", Address: "0.0.0.0:853"}
+	dot.Init()
+	if l := s.takeListener(dot); l != named {
+		t.Errorf("stream socket is not matched by name: %v", l)
+	}
+	wildcard := &common.Listener{Address: ":" + strconv.Itoa(udp.LocalAddr().(*net.UDPAddr).Port)}
+	wildcard.Init()
+	if pc := s.takePacketConn(wildcard); pc != nil {
+		t.Errorf("socket bound to 127.0.0.1 is matched by wildcard address")
+	}
+	udpListener := &common.Listener{Address: udp.LocalAddr().String()}
+	udpListener.Init()
+	if pc := s.takePacketConn(udpListener); pc != udp {
+		t.Errorf("datagram socket is not matched by address: %v", pc)
+	}
+
+	s.closeUnusedSockets()
+	if _, err := unused.WriteTo([]byte{0}, udp.LocalAddr()); err == nil {
+		t.Error("unused socket is not closed")
+	}
+	tcp.Close()
+	udp.Close()
+	named.Close()
+}
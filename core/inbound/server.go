@@ -8,41 +8,130 @@
 package inbound
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/miekg/dns"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/import-yuefeng/smartDNS/core/common"
+	"github.com/import-yuefeng/smartDNS/core/dnstap"
+	"github.com/import-yuefeng/smartDNS/core/metrics"
 	"github.com/import-yuefeng/smartDNS/core/outbound"
+	"github.com/import-yuefeng/smartDNS/core/querylog"
 )
 
 type Server struct {
-	bindAddress      string
+	sync.RWMutex
+
+	listeners        []*common.Listener
 	debugHttpAddress string
-	dispatcher       outbound.Dispatcher
+	dispatcher       *outbound.Dispatcher
 	rejectQType      []uint16
+	queryLog         *querylog.Logger
+	admin            http.Handler
+
+	// inherited are sockets passed by systemd socket activation, they are taken by matching listeners
+	inherited  []*inheritedSocket
+	dnsServers []*dns.Server
+	// dohServers serve DNS over HTTPS listeners
+	dohServers []*dohServer
+	// httpListener is bound by Listen and served by httpServer
+	httpListener net.Listener
+	httpServer   *http.Server
+	errCh        chan error
 }
 
-// NewServer func create new Server struct object
-func NewServer(bindAddress string, debugHTTPAddress string, dispatcher outbound.Dispatcher, rejectQType []uint16) *Server {
+// NewServer func create new Server struct object, listeners must be initialized
+func NewServer(listeners []*common.Listener, debugHTTPAddress string, dispatcher *outbound.Dispatcher, rejectQType []uint16) *Server {
 	return &Server{
-		bindAddress:      bindAddress,
+		listeners:        listeners,
 		debugHttpAddress: debugHTTPAddress,
 		dispatcher:       dispatcher,
 		rejectQType:      rejectQType,
+		errCh:            make(chan error, 1),
 	}
 }
 
+// Dispatcher func return the dispatcher currently used by the server
+func (s *Server) Dispatcher() *outbound.Dispatcher {
+	s.RLock()
+	defer s.RUnlock()
+	return s.dispatcher
+}
+
+// SetDispatcher func replace dispatcher and rejectQType, queries in flight keep using the old dispatcher
+func (s *Server) SetDispatcher(dispatcher *outbound.Dispatcher, rejectQType []uint16) {
+	s.Lock()
+	s.dispatcher, s.rejectQType = dispatcher, rejectQType
+	s.Unlock()
+}
+
+// SetQueryLog func replace query log, the old one is returned to be closed by caller
+func (s *Server) SetQueryLog(l *querylog.Logger) *querylog.Logger {
+	s.Lock()
+	defer s.Unlock()
+	old := s.queryLog
+	s.queryLog = l
+	return old
+}
+
+// SetAdminHandler func set the handler of /admin/ debug endpoints, they are not found if it is nil
+func (s *Server) SetAdminHandler(h http.Handler) {
+	s.Lock()
+	s.admin = h
+	s.Unlock()
+}
+
+func (s *Server) serveAdmin(w http.ResponseWriter, req *http.Request) {
+	s.RLock()
+	admin := s.admin
+	s.RUnlock()
+	if admin == nil {
+		http.NotFound(w, req)
+		return
+	}
+	admin.ServeHTTP(w, req)
+}
+
+// Explain func resolve name and type of request parameters and write the trace of each stage
+func (s *Server) Explain(w http.ResponseWriter, req *http.Request) {
+	name, qtype := req.URL.Query().Get("name"), req.URL.Query().Get("type")
+	t := dns.TypeA
+	if qtype != "" {
+		var ok bool
+		if t, ok = dns.StringToType[strings.ToUpper(qtype)]; !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, "error: unknown type "+qtype)
+			return
+		}
+	}
+	if _, ok := dns.IsDomainName(name); name == "" || !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "error: invalid name")
+		return
+	}
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(name), t)
+
+	inboundIP, _, _ := net.SplitHostPort(req.RemoteAddr)
+	resp, info := s.Dispatcher().Explain(query, inboundIP)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	outbound.WriteExplain(w, query, resp, info)
+}
+
 // DumpCache func be used debug
 func (s *Server) DumpCache(w http.ResponseWriter, req *http.Request) {
-	if s.dispatcher.Cache == nil {
+	dispatcher := s.Dispatcher()
+	if dispatcher.Cache == nil {
 		io.WriteString(w, "error: cache not enabled")
 		return
 	}
@@ -66,7 +155,7 @@ func (s *Server) DumpCache(w http.ResponseWriter, req *http.Request) {
 		nobody = false
 	}
 
-	rs, l := s.dispatcher.Cache.Dump(nobody)
+	rs, l := dispatcher.Cache.Dump(nobody)
 	body := make(map[string][]*answer)
 
 	for k, es := range rs {
@@ -88,7 +177,7 @@ func (s *Server) DumpCache(w http.ResponseWriter, req *http.Request) {
 	res := response{
 		Body:     body,
 		Length:   l,
-		Capacity: s.dispatcher.Cache.Capacity(),
+		Capacity: dispatcher.Cache.Capacity(),
 	}
 
 	responseBytes, err := json.Marshal(&res)
@@ -100,52 +189,191 @@ func (s *Server) DumpCache(w http.ResponseWriter, req *http.Request) {
 	io.WriteString(w, string(responseBytes))
 }
 
-//Run func bind smartDNS listen port and address
-func (s *Server) Run() {
-	mux := dns.NewServeMux()
-	mux.Handle(".", s)
+// Start func bind smartDNS listen port and address, it returns after all listeners are ready
+func (s *Server) Start() error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+// Listen func bind listeners and debug HTTP address without serving, it is followed by Serve
+// Privileges needed by ports below 1024 can be dropped between the two calls.
+func (s *Server) Listen() error {
+	s.inherited = inheritSockets()
+	for _, conf := range s.listeners {
+		if err := s.listen(conf); err != nil {
+			s.closeListeners()
+			return err
+		}
+	}
+	s.closeUnusedSockets()
 
-	wg := new(sync.WaitGroup)
-	wg.Add(2)
+	if s.debugHttpAddress != "" {
+		hl, err := net.Listen("tcp", s.debugHttpAddress)
+		if err != nil {
+			s.closeListeners()
+			return fmt.Errorf("Listening on debug HTTP address failed: %s", err)
+		}
+		s.httpListener = hl
+	}
+	return nil
+}
 
-	log.Infof("smartDNS is listening on %s", s.bindAddress)
+// Serve func serve listeners bound by Listen, it returns after all listeners are ready
+func (s *Server) Serve() error {
+	if s.httpListener != nil {
+		httpMux := http.NewServeMux()
+		httpMux.HandleFunc("/cache", s.DumpCache)
+		httpMux.HandleFunc("/metrics", metrics.Handler)
+		httpMux.HandleFunc("/explain", s.Explain)
+		httpMux.HandleFunc("/admin/", s.serveAdmin)
+		httpMux.HandleFunc("/dashboard", s.Dashboard)
+		httpMux.HandleFunc("/dashboard/stats", s.DashboardStats)
+		httpMux.HandleFunc("/dashboard/cache", s.DashboardCache)
+		s.registerMetrics()
+		// pprof handlers are registered to http.DefaultServeMux by importing net/http/pprof
+		httpMux.Handle("/debug/pprof/", http.DefaultServeMux)
+		s.httpServer = &http.Server{Handler: httpMux}
+		go func(hl net.Listener) {
+			if err := s.httpServer.Serve(hl); err != nil && err != http.ErrServerClosed {
+				s.fail(fmt.Errorf("Debug HTTP server failed: %s", err))
+			}
+		}(s.httpListener)
+	}
 
-	for _, p := range [2]string{"tcp", "udp"} {
-		go func(p string) {
-			err := dns.ListenAndServe(s.bindAddress, p, mux)
-			if err != nil {
-				log.Fatalf("Listening on port %s failed: %s", p, err)
-				os.Exit(1)
+	for _, ds := range s.dohServers {
+		go func(ds *dohServer) {
+			if err := ds.server.ServeTLS(ds.listener, "", ""); err != nil && err != http.ErrServerClosed {
+				s.fail(fmt.Errorf("DNS over HTTPS server failed: %s", err))
 			}
-		}(p)
+		}(ds)
 	}
 
-	if s.debugHttpAddress != "" {
-		http.HandleFunc("/cache", s.DumpCache)
-		wg.Add(1)
-		go http.ListenAndServe(s.debugHttpAddress, nil)
+	started := make(chan error, len(s.dnsServers))
+	for _, ds := range s.dnsServers {
+		ds.NotifyStartedFunc = func() { started <- nil }
+		go func(ds *dns.Server) {
+			if err := ds.ActivateAndServe(); err != nil {
+				err = fmt.Errorf("DNS server failed: %s", err)
+				select {
+				case started <- err:
+				default:
+				}
+				s.fail(err)
+			}
+		}(ds)
+	}
+	for range s.dnsServers {
+		if err := <-started; err != nil {
+			s.Shutdown(context.Background())
+			return err
+		}
+	}
+
+	for _, conf := range s.listeners {
+		log.Infof("smartDNS is listening on %s (%s)", conf.Address, strings.Join(conf.Protocols, ", "))
+	}
+	return nil
+}
+
+// Shutdown func stop listeners and wait for queries in flight until ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	var firstErr error
+	for _, ds := range s.dnsServers {
+		if err := ds.ShutdownContext(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, ds := range s.dohServers {
+		if err := ds.server.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if s.httpServer != nil {
+		if err := s.httpServer.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Errors func return the channel that receives errors of listeners after Start
+func (s *Server) Errors() <-chan error {
+	return s.errCh
+}
+
+func (s *Server) fail(err error) {
+	log.Error(err)
+	select {
+	case s.errCh <- err:
+	default:
 	}
-	// Process will obstruct procesing require
-	wg.Wait()
 }
 
-func (s *Server) ServeDNS(w dns.ResponseWriter, q *dns.Msg) {
+// ServeDNS func answer query received by the listener
+func (l *listener) ServeDNS(w dns.ResponseWriter, q *dns.Msg) {
+	s := l.s
 	inboundIP, _, _ := net.SplitHostPort(w.RemoteAddr().String())
 	// require ip addr
 	log.Debugf("Question from %s: %s", inboundIP, q.Question[0].String())
 
-	for _, qt := range s.rejectQType {
+	start := time.Now()
+	defer func() {
+		metrics.QueryDuration.Observe(time.Since(start).Seconds(), l.conf.Name, l.protocol)
+	}()
+	s.RLock()
+	dispatcher, rejectQType, queryLog := s.dispatcher, s.rejectQType, s.queryLog
+	s.RUnlock()
+	if len(l.conf.RejectQType) > 0 {
+		rejectQType = l.conf.RejectQType
+	}
+	emit(dispatcher.Tap, dnstap.ClientQuery, w, q, start, nil)
+
+	if !l.conf.Allowed(net.ParseIP(inboundIP)) {
+		log.Debugf("Refused query from %s by ACL of listener %s", inboundIP, l.conf.Name)
+		rcode := metrics.Rcode(dns.RcodeRefused)
+		l.countQuery(q, rcode, false)
+		queryLog.Log(l.newLogEntry(q, inboundIP, start, nil, nil, rcode))
+		m := new(dns.Msg)
+		m.SetRcode(q, dns.RcodeRefused)
+		w.WriteMsg(m)
+		emit(dispatcher.Tap, dnstap.ClientResponse, w, q, start, m)
+		return
+	}
+
+	for _, qt := range rejectQType {
 		if isQuestionType(q, qt) {
+			l.countQuery(q, "DROPPED", true)
+			queryLog.Log(l.newLogEntry(q, inboundIP, start, nil, nil, "DROPPED"))
 			return
 		}
 	}
 
-	responseMessage := s.dispatcher.Exchange(q, inboundIP)
+	if isQuestionType(q, dns.TypeAXFR) || isQuestionType(q, dns.TypeIXFR) {
+		isStream := l.protocol == common.ListenTCP || l.protocol == common.ListenTLS
+		rcode := metrics.Rcode(s.transferZone(w, q, dispatcher, inboundIP, isStream))
+		l.countQuery(q, rcode, false)
+		queryLog.Log(l.newLogEntry(q, inboundIP, start, nil, &outbound.QueryInfo{Reason: outbound.ReasonZone}, rcode))
+		return
+	}
+
+	responseMessage, info := dispatcher.ExchangeWithInfo(q, inboundIP)
 
 	if responseMessage == nil {
-		dns.HandleFailed(w, q)
+		rcode := metrics.Rcode(dns.RcodeServerFailure)
+		l.countQuery(q, rcode, false)
+		queryLog.Log(l.newLogEntry(q, inboundIP, start, nil, info, rcode))
+		m := new(dns.Msg)
+		m.SetRcode(q, dns.RcodeServerFailure)
+		w.WriteMsg(m)
+		emit(dispatcher.Tap, dnstap.ClientResponse, w, q, start, m)
 		return
 	}
+	rcode := metrics.Rcode(responseMessage.Rcode)
+	l.countQuery(q, rcode, info != nil && info.Blocked)
+	queryLog.Log(l.newLogEntry(q, inboundIP, start, responseMessage, info, rcode))
+	defer emit(dispatcher.Tap, dnstap.ClientResponse, w, q, start, responseMessage)
 
 	err := w.WriteMsg(responseMessage)
 	if err != nil {
@@ -155,3 +383,126 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, q *dns.Msg) {
 }
 
 func isQuestionType(q *dns.Msg, qt uint16) bool { return q.Question[0].Qtype == qt }
+
+// emit func send dnstap message of client query or response, resp is nil for query
+func emit(tap *dnstap.Tap, typ dnstap.MessageType, w dns.ResponseWriter, q *dns.Msg, queryTime time.Time, resp *dns.Msg) {
+	if !tap.Enabled() {
+		return
+	}
+	m := &dnstap.Message{Type: typ, QueryAddr: w.RemoteAddr(), ResponseAddr: w.LocalAddr(), QueryTime: queryTime}
+	m.QueryMessage, _ = q.Pack()
+	if resp != nil {
+		m.ResponseTime = time.Now()
+		m.ResponseMessage, _ = resp.Pack()
+	}
+	tap.Emit(m)
+}
+
+// newLogEntry func create query log entry, resp and info may be nil
+func newLogEntry(q *dns.Msg, inboundIP string, start time.Time, resp *dns.Msg, info *outbound.QueryInfo, rcode string) *querylog.Entry {
+	e := &querylog.Entry{
+		Time:    start,
+		Client:  inboundIP,
+		Name:    q.Question[0].Name,
+		Type:    metrics.QType(q.Question[0].Qtype),
+		Rcode:   rcode,
+		Latency: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if info != nil {
+		e.Bundle, e.Upstream, e.Reason, e.Cache, e.Coalesced = info.Bundle, info.Upstream, info.Reason, info.Cache, info.Coalesced
+	}
+	if resp != nil {
+		for _, rr := range resp.Answer {
+			// "A 1.2.3.4", header fields other than type are left out
+			e.Answers = append(e.Answers, metrics.QType(rr.Header().Rrtype)+" "+rr.String()[len(rr.Header().String()):])
+		}
+	}
+	return e
+}
+
+// countQuery func count query by listener, protocol, query type and rcode of the answer, and rank its name
+func (l *listener) countQuery(q *dns.Msg, rcode string, blocked bool) {
+	metrics.Queries.Inc(l.conf.Name, l.protocol, metrics.QType(q.Question[0].Qtype), rcode)
+
+	name := strings.ToLower(strings.TrimSuffix(q.Question[0].Name, "."))
+	metrics.TopQueried.Inc(name)
+	if blocked {
+		metrics.BlockedQueries.Inc()
+		metrics.TopBlocked.Inc(name)
+	}
+}
+
+// newLogEntry func create query log entry with the group of listener
+func (l *listener) newLogEntry(q *dns.Msg, inboundIP string, start time.Time, resp *dns.Msg, info *outbound.QueryInfo, rcode string) *querylog.Entry {
+	e := newLogEntry(q, inboundIP, start, resp, info, rcode)
+	e.Group = l.conf.Group
+	return e
+}
+
+// registerMetrics func register gauges read from the current dispatcher, so they follow reloads
+func (s *Server) registerMetrics() {
+	metrics.NewGaugeFunc("smartdns_cache_size", "Entries in cache.", func() float64 {
+		if c := s.Dispatcher().Cache; c != nil {
+			return float64(c.Size())
+		}
+		return 0
+	})
+	metrics.NewGaugeFunc("smartdns_cache_capacity", "Capacity of cache.", func() float64 {
+		if c := s.Dispatcher().Cache; c != nil {
+			return float64(c.Capacity())
+		}
+		return 0
+	})
+	metrics.NewGaugeFunc("smartdns_timer_tasks", "Pending cache update tasks of the fastest IP detector.", func() float64 {
+		if t := s.Dispatcher().CacheTimer; t != nil {
+			return float64(t.Tasks())
+		}
+		return 0
+	})
+	metrics.NewCounterFunc("smartdns_coalesced_queries_total", "Queries answered by an identical lookup in flight.", func() float64 {
+		return float64(outbound.CoalescedQueries())
+	})
+}
+
+// axfrChunkSize is count of records in one message of zone transfer
+const axfrChunkSize = 100
+
+// transferZone func send local zone to allowed secondary over TCP or TLS, IXFR is answered with full zone
+// It returns rcode of the transfer.
+func (s *Server) transferZone(w dns.ResponseWriter, q *dns.Msg, dispatcher *outbound.Dispatcher, inboundIP string, isStream bool) int {
+	z := dispatcher.Zones.Get(q.Question[0].Name)
+	if z == nil || !isStream || !z.AllowTransfer(net.ParseIP(inboundIP)) {
+		log.Warnf("Refused zone transfer of %s to %s", q.Question[0].Name, inboundIP)
+		m := new(dns.Msg)
+		m.SetRcode(q, dns.RcodeRefused)
+		w.WriteMsg(m)
+		return dns.RcodeRefused
+	}
+
+	ch := make(chan *dns.Envelope)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- new(dns.Transfer).Out(w, q, ch)
+	}()
+
+	rrs := z.Transfer()
+	for i := 0; i < len(rrs); i += axfrChunkSize {
+		end := i + axfrChunkSize
+		if end > len(rrs) {
+			end = len(rrs)
+		}
+		select {
+		case ch <- &dns.Envelope{RR: rrs[i:end]}:
+		case err := <-errCh:
+			log.Warnf("Zone transfer of %s to %s failed: %s", z.Origin, inboundIP, err)
+			return dns.RcodeServerFailure
+		}
+	}
+	close(ch)
+	if err := <-errCh; err != nil {
+		log.Warnf("Zone transfer of %s to %s failed: %s", z.Origin, inboundIP, err)
+		return dns.RcodeServerFailure
+	}
+	log.Infof("Zone %s has been transferred to %s", z.Origin, inboundIP)
+	return dns.RcodeSuccess
+}
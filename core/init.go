@@ -11,38 +11,229 @@
 package core
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+
 	"github.com/import-yuefeng/smartDNS/core/config"
 	"github.com/import-yuefeng/smartDNS/core/cron"
+	"github.com/import-yuefeng/smartDNS/core/dnstap"
 	"github.com/import-yuefeng/smartDNS/core/inbound"
 	"github.com/import-yuefeng/smartDNS/core/outbound"
+	"github.com/import-yuefeng/smartDNS/core/querylog"
+	"github.com/import-yuefeng/smartDNS/core/systemd"
 )
 
-// InitServer func Initiate the server with config file
-func InitServer(configFilePath string, smart *bool) {
-	conf := config.NewConfig(configFilePath)
+// Server is a smartDNS instance, it can be embedded in other programs
+type Server struct {
+	sync.Mutex
+
+	configPath  string
+	smart       bool
+	conf        *config.Config
+	inbound     *inbound.Server
+	queryLog    *querylog.Logger
+	tap         *dnstap.Tap
+	cacheTimer  *cron.CacheManager
+	ruleUpdater *cron.RuleUpdater
+	overrides   overrides
+
+	// reloadLock keeps reloads one at a time, the embedded lock is only held to swap config in
+	reloadLock sync.Mutex
+
+	errors  <-chan error
+	done    chan struct{}
+	signals chan os.Signal
+	watcher *fsnotify.Watcher
+	// rewatch is signaled after every successful reload, files of the new config are watched then
+	rewatch chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewServer func create new Server struct object, nothing is loaded until Start is called
+func NewServer(configFilePath string, smart bool) *Server {
+	return &Server{configPath: configFilePath, smart: smart}
+}
+
+// Start func load config file and start listeners and background tasks
+// ctx is checked once config is loaded, loading itself is not interrupted.
+// SIGHUP is left to the caller, see HandleSignals.
+func (s *Server) Start(ctx context.Context) error {
+	conf, err := config.LoadConfig(s.configPath)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.conf = conf
+	s.cacheTimer = cron.NewCacheManager(conf.Cache, conf.CacheCrontab)
+	s.inbound = inbound.NewServer(conf.AllListeners(), conf.DebugHTTPAddress, nil, conf.RejectQType)
+	s.inbound.SetAdminHandler(s.adminHandler())
+	if err := s.inbound.Listen(); err != nil {
+		s.inbound = nil
+		return err
+	}
+	// listeners are bound, root is not needed any more
+	// query log and dnstap files are opened after dropping, so rotation and reload can open them again
+	if err := dropPrivileges(conf); err != nil {
+		s.inbound.Close()
+		s.inbound = nil
+		return err
+	}
+	queryLog, err := querylog.New(conf.QueryLog)
+	if err != nil {
+		s.inbound.Close()
+		s.inbound = nil
+		return fmt.Errorf("Failed to open query log: %s", err)
+	}
+	tap, err := dnstap.New(conf.Dnstap)
+	if err != nil {
+		s.inbound.Close()
+		s.inbound = nil
+		queryLog.Close()
+		return fmt.Errorf("Failed to start dnstap: %s", err)
+	}
+	s.queryLog = queryLog
+	s.tap = tap
 	//New dispatcher without RemoteClientBundle, RemoteClientBundle must be initiated when server is running
-	dispatcher := outbound.Dispatcher{
-		DefaultDNSBundle:   conf.DefaultDNSBundle,
-		DNSFilter:          conf.DNSFilter,
-		DNSBunch:           conf.DNSBunch,
-		RedirectIPv6Record: conf.IPv6UseAlternativeDNS,
-		MinimumTTL:         conf.MinimumTTL,
-		DomainTTLMap:       conf.DomainTTLMap,
-		Hosts:              conf.Hosts,
-		Cache:              conf.Cache,
-		CacheTimer:         new(cron.CacheManager),
-		SmartDNS:           *smart,
-	}
-	s := inbound.NewServer(conf.BindAddress, conf.DebugHTTPAddress, dispatcher, conf.RejectQType)
-	if *smart {
-		dispatcher.CacheTimer.TaskChan = make(chan bool, 1000)
-		dispatcher.CacheTimer.Cache = dispatcher.Cache
-		dispatcher.CacheTimer.Interval = conf.CacheCrontab
-		dispatcher.CacheTimer.TaskSum = 0
-		go dispatcher.CacheTimer.Handle()
-		go dispatcher.CacheTimer.Crontab()
-	}
-
-	s.Run()
+	s.inbound.SetDispatcher(s.dispatcherOf(conf, tap), conf.RejectQType)
+	s.inbound.SetQueryLog(queryLog)
+	if err := s.inbound.Serve(); err != nil {
+		s.inbound = nil
+		queryLog.Close()
+		tap.Close()
+		return err
+	}
+	s.errors = s.inbound.Errors()
+
+	if s.smart {
+		if err := s.cacheTimer.Crontab(); err != nil {
+			log.Errorf("Invalid CacheCrontab %s: %s", conf.CacheCrontab, err)
+		}
+		s.goBackground(s.cacheTimer.Handle)
+	}
+	s.startRuleUpdater()
+
+	if watcher, err := fsnotify.NewWatcher(); err != nil {
+		log.Warnf("Failed to watch config files, reload by file change is disabled: %s", err)
+	} else {
+		s.watcher = watcher
+		s.rewatch = make(chan struct{}, 1)
+		s.goBackground(s.watchFiles)
+	}
 
+	s.done = make(chan struct{})
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		s.goBackground(func() { s.watchdog(interval, s.done) })
+	}
+	notify(systemd.Ready)
+	return nil
+}
+
+// HandleSignals func reload config when SIGHUP is received until Shutdown, it should be called after Start
+// Programs embedding smartDNS that handle SIGHUP themselves call Reload instead.
+func (s *Server) HandleSignals() {
+	s.Lock()
+	defer s.Unlock()
+	if s.inbound == nil || s.signals != nil {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	s.signals = signals
+	s.goBackground(func() { s.watchSignal(signals) })
+}
+
+// Shutdown func stop listeners, wait for queries in flight, and stop all background tasks
+// It returns ctx.Err() if ctx is done before everything is stopped.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.Lock()
+	in := s.inbound
+	if in == nil {
+		s.Unlock()
+		return nil
+	}
+	// Reload is refused from now on
+	s.inbound = nil
+	notify(systemd.Stopping)
+	close(s.done)
+	if s.ruleUpdater != nil {
+		s.ruleUpdater.Stop()
+		s.ruleUpdater = nil
+	}
+	if s.signals != nil {
+		signal.Stop(s.signals)
+		close(s.signals)
+		s.signals = nil
+	}
+	if s.watcher != nil {
+		s.watcher.Close()
+	}
+	s.Unlock()
+
+	err := in.Shutdown(ctx)
+	if cerr := s.cacheTimer.Stop(ctx); err == nil {
+		err = cerr
+	}
+	s.queryLog.Close()
+	s.tap.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	log.Info("smartDNS has been shut down")
+	return err
+}
+
+// Errors func return the channel that receives errors of listeners after Start
+func (s *Server) Errors() <-chan error {
+	return s.errors
+}
+
+func (s *Server) goBackground(f func()) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		f()
+	}()
+}
+
+// newDispatcher func create dispatcher by config, cacheTimer is shared by all dispatchers
+func newDispatcher(conf *config.Config, cacheTimer *cron.CacheManager, smart bool, tap *dnstap.Tap) *outbound.Dispatcher {
+	return &outbound.Dispatcher{
+		DefaultDNSBundle:     conf.DefaultDNSBundle,
+		PrivateReverseBundle: conf.PrivateReverseBundle,
+		DNSFilter:            conf.DNSFilter,
+		DNSBunch:             conf.DNSBunch,
+		RedirectIPv6Record:   conf.IPv6UseAlternativeDNS,
+		MinimumTTL:           conf.MinimumTTL,
+		DomainTTLRules:       conf.DomainTTLRules,
+		Rewrite:              conf.Rewrite,
+		Rebinding:            conf.RebindingProtection,
+		Zones:                conf.Zones,
+		Hosts:                conf.Hosts,
+		Cache:                conf.Cache,
+		CacheTimer:           cacheTimer,
+		SmartDNS:             smart,
+		Tap:                  tap,
+	}
 }
@@ -0,0 +1,119 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package core
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func writeTestConfig(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "config.json")
+	err := ioutil.WriteFile(path, []byte(`{
+  "BindAddress": "127.0.0.1:0",
+  "DNSBunch": {"A": [{"Name": "a1", "Address": "127.0.0.1:53", "Protocol": "udp", "Timeout": 3}]},
+  "DNSFilter": {"A": {"Matcher": "suffix-tree"}},
+  "DefaultDNSBundle": "A"
+}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestServer_StartShutdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smartdns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeTestConfig(t, dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := NewServer(path, false).Start(ctx); err != context.Canceled {
+		t.Errorf("Start with cancelled context: %v", err)
+	}
+
+	s := NewServer(path, true)
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.HandleSignals()
+	if err := s.Reload(); err != nil {
+		t.Errorf("reload: %s", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("shutdown: %s", err)
+	}
+	if err := s.Reload(); err == nil {
+		t.Error("reload after shutdown should fail")
+	}
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("second shutdown: %s", err)
+	}
+}
+
+func TestServer_ShutdownWhileReloading(t *testing.T) {
+	fetching, release := make(chan struct{}, 1), make(chan struct{})
+	var blocked int32
+	list := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&blocked) == 1 {
+			fetching <- struct{}{}
+			<-release
+		}
+		io.WriteString(w, "example.com\n")
+	}))
+	defer list.Close()
+	defer close(release)
+
+	dir, err := ioutil.TempDir("", "smartdns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(path, []byte(`{
+  "BindAddress": "127.0.0.1:0",
+  "DNSBunch": {"A": [{"Name": "a1", "Address": "127.0.0.1:53", "Protocol": "udp", "Timeout": 3}]},
+  "DNSFilter": {"A": {"Matcher": "suffix-tree", "DomainURL": "`+list.URL+`"}},
+  "DefaultDNSBundle": "A"
+}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(path, false)
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&blocked, 1)
+	reloaded := make(chan error, 1)
+	go func() { reloaded <- s.Reload() }()
+	<-fetching
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("shutdown while reloading: %s", err)
+	}
+	release <- struct{}{}
+	if err := <-reloaded; err == nil {
+		t.Error("reload finished after shutdown should fail")
+	}
+}
@@ -6,16 +6,23 @@
 
 package full
 
+import "github.com/import-yuefeng/smartDNS/core/rule"
+
 type List struct {
 	DataList []string
 }
 
 func (s *List) Insert(str string) error {
-	s.DataList = append(s.DataList, str)
+	d, err := rule.NormalizeDomain(str)
+	if err != nil {
+		return err
+	}
+	s.DataList = append(s.DataList, d)
 	return nil
 }
 
 func (s *List) Has(str string) bool {
+	str = rule.NormalizeName(str)
 	for _, data := range s.DataList {
 		if data == str {
 			return true
@@ -6,17 +6,23 @@
 
 package full
 
+import "github.com/import-yuefeng/smartDNS/core/rule"
+
 type Map struct {
 	DataMap map[string]struct{}
 }
 
 func (m *Map) Insert(str string) error {
-	m.DataMap[str] = struct{}{}
+	d, err := rule.NormalizeDomain(str)
+	if err != nil {
+		return err
+	}
+	m.DataMap[d] = struct{}{}
 	return nil
 }
 
 func (m *Map) Has(str string) bool {
-	if _, ok := m.DataMap[str]; ok {
+	if _, ok := m.DataMap[rule.NormalizeName(str)]; ok {
 		return true
 	}
 	return false
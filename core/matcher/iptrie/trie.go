@@ -0,0 +1,234 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package iptrie implements IP network matcher based on path-compressed binary trie.
+// IPv4 and IPv6 networks share one trie, IPv4 is stored as IPv4-mapped IPv6 address.
+package iptrie
+
+import (
+	"fmt"
+	"math/big"
+	"math/bits"
+	"net"
+	"strings"
+)
+
+// v4Offset is the prefix length of ::ffff:0:0/96
+const v4Offset = 96
+
+type key [16]byte
+
+type node struct {
+	prefix key
+	bits   uint8
+	// leaf marks that a network ends at this node, all addresses below it are matched
+	leaf  bool
+	child [2]*node
+}
+
+// Trie is not safe for concurrent Insert, replace the whole Trie to update it
+type Trie struct {
+	root *node
+	size int
+}
+
+// New func create new empty Trie
+func New() *Trie {
+	return new(Trie)
+}
+
+// Name func return matcher name
+func (t *Trie) Name() string {
+	return "ip-trie"
+}
+
+// Len func return count of inserted networks
+func (t *Trie) Len() int {
+	return t.size
+}
+
+// Insert func insert CIDR (1.0.1.0/24), single IP (1.0.1.1) or range (1.0.1.0-1.0.3.255)
+func (t *Trie) Insert(s string) error {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, "-"); i >= 0 {
+		start, end := net.ParseIP(strings.TrimSpace(s[:i])), net.ParseIP(strings.TrimSpace(s[i+1:]))
+		if start == nil || end == nil {
+			return fmt.Errorf("invalid IP range: %s", s)
+		}
+		return t.InsertRange(start, end)
+	}
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return err
+		}
+		t.InsertIPNet(ipNet)
+		return nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return fmt.Errorf("invalid IP address: %s", s)
+	}
+	k, _ := toKey(ip)
+	t.insert(k, 128)
+	return nil
+}
+
+// InsertIPNet func insert network
+func (t *Trie) InsertIPNet(ipNet *net.IPNet) {
+	k, _ := toKey(ipNet.IP)
+	ones, size := ipNet.Mask.Size()
+	if size == 8*net.IPv4len {
+		ones += v4Offset
+	}
+	t.insert(k, uint8(ones))
+}
+
+// InsertRange func insert all addresses from start to end, both must be same family
+func (t *Trie) InsertRange(start net.IP, end net.IP) error {
+	sk, sv4 := toKey(start)
+	ek, ev4 := toKey(end)
+	if sv4 != ev4 {
+		return fmt.Errorf("IP range %s-%s mixes IPv4 and IPv6", start, end)
+	}
+	first, last := new(big.Int).SetBytes(sk[:]), new(big.Int).SetBytes(ek[:])
+	if first.Cmp(last) > 0 {
+		return fmt.Errorf("IP range %s-%s is reversed", start, end)
+	}
+
+	// split range into the largest aligned blocks
+	one := big.NewInt(1)
+	for first.Cmp(last) <= 0 {
+		size := 128
+		if first.Sign() != 0 {
+			size = int(first.TrailingZeroBits())
+		}
+		for ; size > 0; size-- {
+			blockLast := new(big.Int).Lsh(one, uint(size))
+			blockLast.Add(blockLast, first).Sub(blockLast, one)
+			if blockLast.Cmp(last) <= 0 {
+				break
+			}
+		}
+		var k key
+		b := first.Bytes()
+		copy(k[len(k)-len(b):], b)
+		t.insert(k, uint8(128-size))
+		first.Add(first, new(big.Int).Lsh(one, uint(size)))
+	}
+	return nil
+}
+
+// Has func return true if string ip is in any network
+func (t *Trie) Has(s string) bool {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return false
+	}
+	return t.Contains(ip)
+}
+
+// Contains func return true if ip is in any network
+func (t *Trie) Contains(ip net.IP) bool {
+	if t == nil || ip == nil {
+		return false
+	}
+	k, _ := toKey(ip)
+	for n := t.root; n != nil; {
+		if commonPrefixLen(n.prefix, k, n.bits) < n.bits {
+			return false
+		}
+		if n.leaf {
+			return true
+		}
+		if n.bits == 128 {
+			return false
+		}
+		n = n.child[bitAt(k, n.bits)]
+	}
+	return false
+}
+
+func (t *Trie) insert(k key, prefixLen uint8) {
+	k = maskKey(k, prefixLen)
+	t.size++
+
+	p := &t.root
+	for {
+		n := *p
+		if n == nil {
+			*p = &node{prefix: k, bits: prefixLen, leaf: true}
+			return
+		}
+
+		limit := n.bits
+		if prefixLen < limit {
+			limit = prefixLen
+		}
+		cpl := commonPrefixLen(n.prefix, k, limit)
+
+		switch {
+		case cpl == n.bits && n.bits == prefixLen:
+			// same network, the subtree is covered now
+			n.leaf, n.child = true, [2]*node{}
+			return
+		case cpl == n.bits:
+			if n.leaf {
+				// covered by a shorter network
+				return
+			}
+			p = &n.child[bitAt(k, n.bits)]
+		case cpl == prefixLen:
+			// new network covers n, drop the subtree of n
+			*p = &node{prefix: k, bits: prefixLen, leaf: true}
+			return
+		default:
+			fork := &node{prefix: maskKey(k, cpl), bits: cpl}
+			fork.child[bitAt(n.prefix, cpl)] = n
+			fork.child[bitAt(k, cpl)] = &node{prefix: k, bits: prefixLen, leaf: true}
+			*p = fork
+			return
+		}
+	}
+}
+
+// toKey func return 16 bytes key of ip, and whether ip is IPv4
+func toKey(ip net.IP) (k key, v4 bool) {
+	copy(k[:], ip.To16())
+	return k, ip.To4() != nil
+}
+
+func maskKey(k key, prefixLen uint8) key {
+	for i := range k {
+		switch bit := int(prefixLen) - i*8; {
+		case bit >= 8:
+		case bit <= 0:
+			k[i] = 0
+		default:
+			k[i] &= ^byte(0xff >> uint(bit))
+		}
+	}
+	return k
+}
+
+func bitAt(k key, i uint8) int {
+	return int(k[i/8]>>(7-i%8)) & 1
+}
+
+func commonPrefixLen(a key, b key, limit uint8) uint8 {
+	var n uint8
+	for i := 0; i < len(a) && n < limit; i++ {
+		if x := a[i] ^ b[i]; x != 0 {
+			n += uint8(bits.LeadingZeros8(x))
+			break
+		}
+		n += 8
+	}
+	if n > limit {
+		n = limit
+	}
+	return n
+}
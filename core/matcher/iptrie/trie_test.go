@@ -0,0 +1,154 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package iptrie
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+)
+
+func TestTrie_Contains(t *testing.T) {
+	trie := New()
+	for _, s := range []string{"1.0.1.0/24", "10.0.0.0/8", "10.1.0.0/16", "192.168.1.1", "2001:db8::/32", "fe80::1"} {
+		if err := trie.Insert(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := map[string]bool{
+		"1.0.1.0":        true,
+		"1.0.1.255":      true,
+		"1.0.2.0":        false,
+		"10.200.3.4":     true,
+		"10.1.2.3":       true,
+		"11.0.0.0":       false,
+		"192.168.1.1":    true,
+		"192.168.1.2":    false,
+		"2001:db8::1":    true,
+		"2001:db9::1":    false,
+		"fe80::1":        true,
+		"fe80::2":        false,
+		"::ffff:1.0.1.3": true,
+	}
+	for ip, want := range cases {
+		if got := trie.Has(ip); got != want {
+			t.Errorf("Has(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestTrie_InsertRange(t *testing.T) {
+	trie := New()
+	if err := trie.Insert("1.0.1.0-1.0.3.255"); err != nil {
+		t.Fatal(err)
+	}
+	if err := trie.Insert("2001:db8::5 - 2001:db8::10"); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"1.0.0.255":    false,
+		"1.0.1.0":      true,
+		"1.0.2.128":    true,
+		"1.0.3.255":    true,
+		"1.0.4.0":      false,
+		"2001:db8::4":  false,
+		"2001:db8::5":  true,
+		"2001:db8::10": true,
+		"2001:db8::11": false,
+	}
+	for ip, want := range cases {
+		if got := trie.Has(ip); got != want {
+			t.Errorf("Has(%s) = %v, want %v", ip, got, want)
+		}
+	}
+
+	for _, bad := range []string{"1.0.3.0-1.0.1.0", "1.0.0.1-::1", "1.0.0.256", "1.0.0.0/33"} {
+		if err := trie.Insert(bad); err == nil {
+			t.Errorf("Insert(%s) should fail", bad)
+		}
+	}
+}
+
+func TestTrie_MatchLinearScan(t *testing.T) {
+	trie, list := randomNetworks(2000, 500)
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 100000; i++ {
+		ip := randomIP(r, i%4 == 0)
+		if got, want := trie.Contains(ip), linearContains(list, ip); got != want {
+			t.Fatalf("Contains(%s) = %v, linear scan = %v", ip, got, want)
+		}
+	}
+}
+
+func BenchmarkTrie_Contains(b *testing.B) {
+	trie, _ := randomNetworks(8000, 3000)
+	ips := randomIPs(1024)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trie.Contains(ips[i%len(ips)])
+	}
+}
+
+func BenchmarkLinearScan(b *testing.B) {
+	_, list := randomNetworks(8000, 3000)
+	ips := randomIPs(1024)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		linearContains(list, ips[i%len(ips)])
+	}
+}
+
+// linearContains is the matching used before the trie
+func linearContains(list []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range list {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func randomNetworks(v4 int, v6 int) (*Trie, []*net.IPNet) {
+	r := rand.New(rand.NewSource(1))
+	trie := New()
+	var list []*net.IPNet
+	for i := 0; i < v4+v6; i++ {
+		isV6 := i >= v4
+		ip := randomIP(r, isV6)
+		ones, size := 8+r.Intn(17), 32
+		if isV6 {
+			ones, size = 16+r.Intn(33), 128
+		}
+		ipNet := &net.IPNet{IP: ip.Mask(net.CIDRMask(ones, size)), Mask: net.CIDRMask(ones, size)}
+		trie.InsertIPNet(ipNet)
+		list = append(list, ipNet)
+	}
+	return trie, list
+}
+
+func randomIPs(n int) []net.IP {
+	r := rand.New(rand.NewSource(3))
+	ips := make([]net.IP, n)
+	for i := range ips {
+		ips[i] = randomIP(r, i%4 == 0)
+	}
+	return ips
+}
+
+func randomIP(r *rand.Rand, v6 bool) net.IP {
+	if v6 {
+		ip := make(net.IP, net.IPv6len)
+		r.Read(ip)
+		// keep addresses in 2000::/4 so networks overlap
+		ip[0] = 0x20 | ip[0]&0x0f
+		return ip
+	}
+	ip := make(net.IP, net.IPv4len)
+	r.Read(ip)
+	return ip
+}
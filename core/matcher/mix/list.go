@@ -8,8 +8,10 @@ package mix
 
 import (
 	"fmt"
-	"regexp"
 	"strings"
+	"sync"
+
+	"github.com/import-yuefeng/smartDNS/core/rule"
 )
 
 type Data struct {
@@ -17,24 +19,55 @@ type Data struct {
 	Content string
 }
 
+// List supports domain, full, regex and keyword rules, rules are compiled when they are inserted
 type List struct {
-	DataList []Data
+	sync.RWMutex
+
+	domains  []string
+	full     map[string]struct{}
+	regex    rule.RegexSet
+	keywords rule.Keywords
 }
 
 func (s *List) Insert(str string) error {
-	kv := strings.Split(str, ":")
+	kv := strings.SplitN(str, ":", 2)
 
+	var data Data
 	switch len(kv) {
 	case 1:
-		s.DataList = append(s.DataList,
-			Data{
-				Type:    "domain",
-				Content: strings.ToLower(kv[0])})
-	case 2:
-		s.DataList = append(s.DataList,
-			Data{
-				Type:    strings.ToLower(kv[0]),
-				Content: strings.ToLower(kv[1])})
+		data = Data{Type: "domain", Content: kv[0]}
+	default:
+		data = Data{Type: strings.ToLower(kv[0]), Content: kv[1]}
+	}
+
+	// domain and full rules are stored as lowercase A-labels, regex is kept as written and matched case-insensitively
+	switch data.Type {
+	case "domain", "full":
+		d, err := rule.NormalizeDomain(data.Content)
+		if err != nil {
+			return err
+		}
+		data.Content = d
+	case "keyword":
+		data.Content = strings.ToLower(data.Content)
+	}
+
+	switch data.Type {
+	case "domain":
+		s.Lock()
+		s.domains = append(s.domains, data.Content)
+		s.Unlock()
+	case "full":
+		s.Lock()
+		if s.full == nil {
+			s.full = make(map[string]struct{})
+		}
+		s.full[data.Content] = struct{}{}
+		s.Unlock()
+	case "regex":
+		return s.regex.Add(data.Content)
+	case "keyword":
+		return s.keywords.Add(data.Content)
 	default:
 		return fmt.Errorf("invalid format: %s", str)
 	}
@@ -43,29 +76,22 @@ func (s *List) Insert(str string) error {
 }
 
 func (s *List) Has(str string) bool {
-	for _, data := range s.DataList {
-		switch data.Type {
-		case "domain":
-			idx := len(str) - len(data.Content)
-			if idx > 0 && data.Content == str[idx:] {
-				return true
-			}
-		case "regex":
-			reg := regexp.MustCompile(data.Content)
-			if reg.MatchString(str) {
-				return true
-			}
-		case "keyword":
-			if strings.Contains(str, data.Content) {
-				return true
-			}
-		case "full":
-			if data.Content == str {
-				return true
-			}
+	str = rule.NormalizeName(str)
+	s.RLock()
+	_, ok := s.full[str]
+	domains := s.domains
+	s.RUnlock()
+	if ok {
+		return true
+	}
+
+	// domain rules match the domain itself and its subdomains, like suffix-tree
+	for _, domain := range domains {
+		if str == domain || strings.HasSuffix(str, "."+domain) {
+			return true
 		}
 	}
-	return false
+	return s.keywords.Match(str) || s.regex.Match(str)
 }
 
 func (s *List) Name() string {
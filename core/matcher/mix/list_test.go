@@ -0,0 +1,42 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package mix
+
+import "testing"
+
+func TestList_Has(t *testing.T) {
+	l := new(List)
+	for _, s := range []string{
+		"domain:example.com",
+		"Example.ORG.",
+		"full:exact.net",
+		"keyword:tracker",
+		"regex:^ads[0-9]+\\.",
+	} {
+		if err := l.Insert(s); err != nil {
+			t.Fatalf("Insert(%s): %s", s, err)
+		}
+	}
+
+	cases := map[string]bool{
+		"example.com":       true,
+		"www.example.com.":  true,
+		"badexample.com":    false,
+		"example.com.evil":  false,
+		"WWW.example.org":   true,
+		"exact.net":         true,
+		"www.exact.net":     false,
+		"my-tracker.io":     true,
+		"ads12.example.net": true,
+		"com":               false,
+	}
+	for name, want := range cases {
+		if got := l.Has(name); got != want {
+			t.Errorf("Has(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
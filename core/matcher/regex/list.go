@@ -6,24 +6,19 @@
 
 package regex
 
-import "github.com/import-yuefeng/smartDNS/core/common"
+import "github.com/import-yuefeng/smartDNS/core/rule"
 
+// List compiles every regex when it is inserted, invalid regex is returned as error
 type List struct {
-	RegexList []string
+	patterns rule.RegexSet
 }
 
 func (r *List) Insert(s string) error {
-	r.RegexList = append(r.RegexList, s)
-	return nil
+	return r.patterns.Add(s)
 }
 
 func (r *List) Has(s string) bool {
-	for _, regex := range r.RegexList {
-		if common.IsDomainMatchRule(regex, s) {
-			return true
-		}
-	}
-	return false
+	return r.patterns.Match(rule.NormalizeName(s))
 }
 
 func (r *List) Name() string {
@@ -9,90 +9,176 @@ package suffix
 import (
 	"errors"
 	"strings"
+
+	"github.com/import-yuefeng/smartDNS/core/rule"
 )
 
-type Domain string
+// Rule syntax of Insert:
+//
+//	example.com / domain:example.com     example.com and all subdomains
+//	full:example.com                     example.com only
+//	!ads.example.com / !domain:...       exclude ads.example.com and all subdomains
+//	!full:ads.example.com                exclude ads.example.com only
+//
+// The most specific rule wins: exact rules beat subdomain rules on the same name,
+// exclusion beats inclusion on the same name, and a deeper name beats its parents.
+const (
+	markDomain uint8 = 1 << iota
+	markFull
+	markExcludeDomain
+	markExcludeFull
+)
 
-type Tree struct {
-	mark uint8
-	sub  domainMap
-}
+// children are kept in slices until there are more than maxSliceChildren, then a map is used
+const maxSliceChildren = 8
 
-func (dt *Tree) Name() string {
-	return "suffix-tree"
+type node struct {
+	mark     uint8
+	labels   []string
+	children []*node
+	index    map[string]*node
 }
 
-type domainMap map[Domain]*Tree
-
-func (d Domain) nextLevel() Domain {
-	if pointIndex := strings.LastIndex(string(d), "."); pointIndex == -1 {
-		return ""
-	} else {
-		return d[:pointIndex]
-	}
+// Tree is a reversed-label trie (com -> example -> www), it is not safe for concurrent Insert,
+// replace the whole Tree to update it
+type Tree struct {
+	root node
+	size int
 }
 
-func (d Domain) topLevel() Domain {
-	if pointIndex := strings.LastIndex(string(d), "."); pointIndex == -1 {
-		return d
-	} else {
-		return d[pointIndex+1:]
-	}
+func (dt *Tree) Name() string {
+	return "suffix-tree"
 }
 
 func DefaultDomainTree() *Tree {
 	return NewDomainTree()
 }
 
-func NewDomainTree() (dt *Tree) {
-	dt = new(Tree)
-	dt.sub = make(domainMap)
-	return
+func NewDomainTree() *Tree {
+	return new(Tree)
 }
 
-func (dt *Tree) has(d Domain) bool {
-	if len(dt.sub) == 0 {
-		return true
-	}
-
-	if sub, ok := dt.sub[d.topLevel()]; ok {
-		return sub.has(d.nextLevel())
-	}
-	return false
+// Len func return count of inserted rules
+func (dt *Tree) Len() int {
+	return dt.size
 }
 
 func (dt *Tree) Has(d string) bool {
-	return dt.has(Domain(d))
-}
-
-func (dt *Tree) insert(sections []Domain) {
-
-	if len(sections) == 0 {
-		return
+	d = rule.NormalizeName(d)
+	if d == "" {
+		return false
 	}
 
-	var lastIndex, lastSec = len(sections) - 1, sections[len(sections)-1]
+	matched := false
+	n := &dt.root
+	for end := len(d); n != nil; {
+		start := strings.LastIndexByte(d[:end], '.') + 1
+		if n = n.child(d[start:end]); n == nil {
+			break
+		}
+		if start == 0 {
+			// all labels are consumed, exact rules override subdomain rules
+			switch {
+			case n.mark&markExcludeFull != 0:
+				return false
+			case n.mark&markFull != 0:
+				return true
+			}
+		}
+		switch {
+		case n.mark&markExcludeDomain != 0:
+			matched = false
+		case n.mark&markDomain != 0:
+			matched = true
+		}
+		if start == 0 {
+			break
+		}
+		end = start - 1
+	}
+	return matched
+}
 
-	if sec, ok := dt.sub[lastSec]; ok {
-		sec.insert(sections[:lastIndex])
+func (dt *Tree) Insert(s string) error {
+	s = strings.TrimSpace(s)
+	exclude := strings.HasPrefix(s, "!")
+	if exclude {
+		s = s[1:]
+	}
+	full := strings.HasPrefix(s, "full:")
+	if full {
+		s = s[len("full:"):]
 	} else {
-		dt.sub[lastSec] = NewDomainTree()
-		dt.sub[lastSec].insert(sections[:lastIndex])
+		s = strings.TrimPrefix(s, "domain:")
 	}
-}
 
-func (dt *Tree) Insert(d string) error {
-	sections := strings.Split(d, ".")
-	if len(sections) == 0 {
-		return errors.New("Split Domain error\n")
+	var mark uint8
+	switch {
+	case exclude && full:
+		mark = markExcludeFull
+	case exclude:
+		mark = markExcludeDomain
+	case full:
+		mark = markFull
+	default:
+		mark = markDomain
 	}
 
-	domainSec := make([]Domain, len(sections))
+	d, err := rule.NormalizeDomain(strings.TrimLeft(s, "."))
+	if err != nil {
+		return err
+	}
 
-	for i := range sections {
-		domainSec[i] = Domain(sections[i])
+	n := &dt.root
+	for end := len(d); ; {
+		start := strings.LastIndexByte(d[:end], '.') + 1
+		label := d[start:end]
+		if label == "" {
+			return errors.New("empty label in domain " + d)
+		}
+		n = n.addChild(label)
+		if start == 0 {
+			break
+		}
+		end = start - 1
 	}
+	n.mark |= mark
+	dt.size++
+	return nil
+}
 
-	dt.insert(domainSec)
+func (n *node) child(label string) *node {
+	if n.index != nil {
+		return n.index[label]
+	}
+	for i, l := range n.labels {
+		if l == label {
+			return n.children[i]
+		}
+	}
 	return nil
 }
+
+func (n *node) addChild(label string) *node {
+	if c := n.child(label); c != nil {
+		return c
+	}
+	// copy label, so that the tree does not keep the whole input line
+	label = string([]byte(label))
+	c := new(node)
+	switch {
+	case n.index != nil:
+		n.index[label] = c
+	case len(n.labels) < maxSliceChildren:
+		n.labels = append(n.labels, label)
+		n.children = append(n.children, c)
+	default:
+		n.index = make(map[string]*node, 2*maxSliceChildren)
+		for i, l := range n.labels {
+			n.index[l] = n.children[i]
+		}
+		n.index[label] = c
+		n.labels, n.children = nil, nil
+	}
+	return c
+}
@@ -7,9 +7,136 @@
 package suffix
 
 import (
+	"fmt"
+	"math/rand"
 	"testing"
 )
 
 func TestTree_Has(t *testing.T) {
+	dt := NewDomainTree()
+	for _, s := range []string{
+		"a.example.com",
+		"b.example.com",
+		"google.com",
+		"!ads.google.com",
+		"full:ads.google.com.hk",
+		"domain:Example.ORG.",
+		"!full:www.example.org",
+		"full:exact.net",
+		"!domain:exact.net",
+		"中国.cn",
+	} {
+		if err := dt.Insert(s); err != nil {
+			t.Fatalf("Insert(%s): %s", s, err)
+		}
+	}
 
+	cases := map[string]bool{
+		"a.example.com":       true,
+		"x.a.example.com":     true,
+		"b.example.com":       true,
+		"example.com":         false,
+		"c.example.com":       false,
+		"com":                 false,
+		"google.com":          true,
+		"www.google.com":      true,
+		"ads.google.com":      false,
+		"x.ads.google.com":    false,
+		"ads.google.com.hk":   true,
+		"x.ads.google.com.hk": false,
+		"example.org":         true,
+		"WWW.Example.Org.":    false,
+		"mail.example.org":    true,
+		"x.www.example.org":   true,
+		"exact.net":           true,
+		"sub.exact.net":       false,
+		"WWW.XN--FIQS8S.CN.":  true,
+		"":                    false,
+		".":                   false,
+	}
+	for name, want := range cases {
+		if got := dt.Has(name); got != want {
+			t.Errorf("Has(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestTree_InsertInvalid(t *testing.T) {
+	dt := NewDomainTree()
+	for _, s := range []string{"", " ", ".", "!", "full:", "a..com"} {
+		if err := dt.Insert(s); err == nil {
+			t.Errorf("Insert(%q) should fail", s)
+		}
+	}
+	if dt.Has("com") || dt.Len() != 0 {
+		t.Error("invalid rules must not be inserted")
+	}
+}
+
+func TestTree_ManyChildren(t *testing.T) {
+	dt := NewDomainTree()
+	for i := 0; i < 100; i++ {
+		_ = dt.Insert(fmt.Sprintf("d%d.com", i))
+	}
+	for i := 0; i < 100; i++ {
+		if !dt.Has(fmt.Sprintf("www.d%d.com", i)) {
+			t.Fatalf("d%d.com is lost", i)
+		}
+	}
+	if dt.Has("d100.com") {
+		t.Error("d100.com should not match")
+	}
+}
+
+func BenchmarkTree_Insert(b *testing.B) {
+	domains := randomDomains(100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dt := NewDomainTree()
+		for _, d := range domains {
+			_ = dt.Insert(d)
+		}
+	}
+}
+
+func BenchmarkTree_Has(b *testing.B) {
+	domains := randomDomains(100000)
+	dt := NewDomainTree()
+	for _, d := range domains {
+		_ = dt.Insert(d)
+	}
+	queries := make([]string, 1024)
+	r := rand.New(rand.NewSource(2))
+	for i := range queries {
+		if i%2 == 0 {
+			queries[i] = "www." + domains[r.Intn(len(domains))]
+		} else {
+			queries[i] = randomDomain(r)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dt.Has(queries[i%len(queries)])
+	}
+}
+
+func randomDomains(n int) []string {
+	r := rand.New(rand.NewSource(1))
+	domains := make([]string, n)
+	for i := range domains {
+		domains[i] = randomDomain(r)
+	}
+	return domains
+}
+
+var tlds = []string{"com", "net", "org", "cn", "io", "com.cn", "co.uk"}
+
+func randomDomain(r *rand.Rand) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+	label := make([]byte, 4+r.Intn(12))
+	for i := range label {
+		label[i] = letters[r.Intn(len(letters))]
+	}
+	return string(label) + "." + tlds[r.Intn(len(tlds))]
 }
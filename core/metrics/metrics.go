@@ -0,0 +1,317 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package metrics implements counters, histograms and gauges exposed in Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// metric is one metric family in the registry
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+var registry = struct {
+	sync.RWMutex
+	names   []string
+	metrics map[string]metric
+}{metrics: make(map[string]metric)}
+
+// register func add m to registry, a metric with the same name is replaced
+func register(name string, m metric) {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.metrics[name]; !ok {
+		registry.names = append(registry.names, name)
+		sort.Strings(registry.names)
+	}
+	registry.metrics[name] = m
+}
+
+// WriteTo func write all registered metrics to w in Prometheus text exposition format
+func WriteTo(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	registry.RLock()
+	for _, name := range registry.names {
+		registry.metrics[name].write(bw)
+	}
+	registry.RUnlock()
+	return bw.Flush()
+}
+
+// Handler func serve /metrics
+func Handler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	WriteTo(w)
+}
+
+// series is one set of label values of a vector
+type series struct {
+	// value is first for 64-bit alignment of atomic operations
+	value  uint64
+	values []string
+
+	// histogram only, guarded by lock
+	lock    sync.Mutex
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// vec holds all series of a metric family
+type vec struct {
+	name   string
+	help   string
+	labels []string
+
+	lock   sync.RWMutex
+	series map[string]*series
+}
+
+func newVec(name string, help string, labels []string) *vec {
+	v := &vec{name: name, help: help, labels: labels, series: make(map[string]*series)}
+	if len(labels) == 0 {
+		// metrics without labels are exposed as zero before the first update
+		v.get(nil)
+	}
+	return v
+}
+
+// get func return series of values, it is created on first use
+func (v *vec) get(values []string) *series {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.lock.RLock()
+	s, ok := v.series[key]
+	v.lock.RUnlock()
+	if ok {
+		return s
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if s, ok = v.series[key]; !ok {
+		s = &series{values: append([]string(nil), values...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+// sorted func return series ordered by label values, so output is stable
+func (v *vec) sorted() []*series {
+	v.lock.RLock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	list := make([]*series, len(keys))
+	for i, k := range keys {
+		list[i] = v.series[k]
+	}
+	v.lock.RUnlock()
+	return list
+}
+
+func (v *vec) writeHeader(w *bufio.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escape(v.help, false), v.name, typ)
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	*vec
+}
+
+// NewCounterVec func create and register a counter
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, labels)}
+	register(name, c)
+	return c
+}
+
+// Inc func add 1 to the series of label values
+func (c *CounterVec) Inc(values ...string) {
+	atomic.AddUint64(&c.get(values).value, 1)
+}
+
+// Add func add n to the series of label values
+func (c *CounterVec) Add(n uint64, values ...string) {
+	atomic.AddUint64(&c.get(values).value, n)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w, "counter")
+	for _, s := range c.sorted() {
+		writeSample(w, c.name, c.labels, s.values, "", "", float64(atomic.LoadUint64(&s.value)))
+	}
+}
+
+// Sample is current value of one series, Count is only set for histograms and Value is their sum
+type Sample struct {
+	Labels []string
+	Value  float64
+	Count  uint64
+}
+
+// Samples func return values of all series, ordered by label values
+func (c *CounterVec) Samples() []Sample {
+	var rs []Sample
+	for _, s := range c.sorted() {
+		rs = append(rs, Sample{Labels: s.values, Value: float64(atomic.LoadUint64(&s.value))})
+	}
+	return rs
+}
+
+// Total func return sum of all series
+func (c *CounterVec) Total() float64 {
+	var total float64
+	for _, s := range c.Samples() {
+		total += s.Value
+	}
+	return total
+}
+
+// HistogramVec is a histogram partitioned by labels, buckets are upper bounds in ascending order
+type HistogramVec struct {
+	*vec
+	bounds []float64
+}
+
+// DefaultBuckets are bounds in seconds for request latency
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// NewHistogramVec func create and register a histogram
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{vec: newVec(name, help, labels), bounds: buckets}
+	register(name, h)
+	return h
+}
+
+// Observe func record v in the series of label values
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	s := h.get(values)
+	i := sort.SearchFloat64s(h.bounds, v)
+	s.lock.Lock()
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(h.bounds))
+	}
+	if i < len(h.bounds) {
+		s.buckets[i]++
+	}
+	s.sum += v
+	s.count++
+	s.lock.Unlock()
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w, "histogram")
+	for _, s := range h.sorted() {
+		s.lock.Lock()
+		var cumulative uint64
+		for i, b := range h.bounds {
+			if s.buckets != nil {
+				cumulative += s.buckets[i]
+			}
+			writeSample(w, h.name+"_bucket", h.labels, s.values, "le", formatFloat(b), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", h.labels, s.values, "le", "+Inf", float64(s.count))
+		writeSample(w, h.name+"_sum", h.labels, s.values, "", "", s.sum)
+		writeSample(w, h.name+"_count", h.labels, s.values, "", "", float64(s.count))
+		s.lock.Unlock()
+	}
+}
+
+// Samples func return sum and count of all series, ordered by label values
+func (h *HistogramVec) Samples() []Sample {
+	var rs []Sample
+	for _, s := range h.sorted() {
+		s.lock.Lock()
+		rs = append(rs, Sample{Labels: s.values, Value: s.sum, Count: s.count})
+		s.lock.Unlock()
+	}
+	return rs
+}
+
+// funcMetric is a gauge or counter whose value is read when metrics are collected
+type funcMetric struct {
+	name string
+	help string
+	typ  string
+	fn   func() float64
+}
+
+// NewGaugeFunc func register a gauge read from fn, fn replaces the one of an existing gauge with the same name
+func NewGaugeFunc(name string, help string, fn func() float64) {
+	register(name, &funcMetric{name, help, "gauge", fn})
+}
+
+// NewCounterFunc func register a counter read from fn, fn must never decrease
+func NewCounterFunc(name string, help string, fn func() float64) {
+	register(name, &funcMetric{name, help, "counter", fn})
+}
+
+func (m *funcMetric) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, escape(m.help, false), m.name, m.typ)
+	writeSample(w, m.name, nil, nil, "", "", m.fn())
+}
+
+// writeSample func write one line, extraName and extraValue is an additional label like le of histogram buckets
+func writeSample(w *bufio.Writer, name string, labels []string, values []string, extraName string, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", l, escape(values[i], true))
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escape func escape help text, label values also escape double quotes
+func escape(s string, quote bool) string {
+	r := strings.NewReplacer("\\", `\\`, "\n", `\n`)
+	if quote {
+		r = strings.NewReplacer("\\", `\\`, "\n", `\n`, "\"", `\"`)
+	}
+	return r.Replace(s)
+}
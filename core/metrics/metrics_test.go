@@ -0,0 +1,85 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	c := NewCounterVec("test_requests_total", "Requests.", "bundle", "upstream")
+	c.Inc("CN", "ali")
+	c.Add(2, "CN", "ali")
+	c.Inc("Global", `quote"back\slash`)
+
+	h := NewHistogramVec("test_duration_seconds", "Latency.", []float64{0.1, 1}, "bundle")
+	h.Observe(0.05, "CN")
+	h.Observe(0.5, "CN")
+	h.Observe(3, "CN")
+
+	NewCounterVec("test_plain_total", "Without labels.")
+	NewGaugeFunc("test_size", "Size.", func() float64 { return 42 })
+	NewGaugeFunc("test_size", "Size.", func() float64 { return 7 })
+
+	var buf bytes.Buffer
+	if err := WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{bundle="CN",upstream="ali"} 3`,
+		`test_requests_total{bundle="Global",upstream="quote\"back\\slash"} 1`,
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{bundle="CN",le="0.1"} 1`,
+		`test_duration_seconds_bucket{bundle="CN",le="1"} 2`,
+		`test_duration_seconds_bucket{bundle="CN",le="+Inf"} 3`,
+		`test_duration_seconds_sum{bundle="CN"} 3.55`,
+		`test_duration_seconds_count{bundle="CN"} 3`,
+		"test_plain_total 0",
+		"# TYPE test_size gauge",
+		"test_size 7",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing line %q in:\n%s", line, out)
+		}
+	}
+	if strings.Count(out, "# TYPE test_size ") != 1 {
+		t.Error("gauge registered twice should be replaced")
+	}
+}
+
+func TestVec_LabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("wrong label count should panic")
+		}
+	}()
+	NewCounterVec("test_labels_total", "Labels.", "a").Inc("x", "y")
+}
+
+func TestTop(t *testing.T) {
+	top := NewTop(3)
+	for i := 0; i < 4; i++ {
+		top.Inc("a.com")
+	}
+	top.Inc("b.com")
+	top.Inc("b.com")
+	top.Inc("c.com")
+	if l := top.List(2); len(l) != 2 || l[0] != (TopEntry{"a.com", 4}) || l[1] != (TopEntry{"b.com", 2}) {
+		t.Errorf("unexpected top list %v", l)
+	}
+
+	// full, counts are halved and c.com is forgotten to make room
+	top.Inc("d.com")
+	l := top.List(10)
+	if len(l) != 3 || l[0] != (TopEntry{"a.com", 2}) || l[1] != (TopEntry{"b.com", 1}) || l[2] != (TopEntry{"d.com", 1}) {
+		t.Errorf("unexpected top list after eviction %v", l)
+	}
+}
@@ -0,0 +1,60 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/miekg/dns"
+)
+
+// Metrics of smartDNS, gauges that depend on running objects are registered by inbound server
+var (
+	Queries       = NewCounterVec("smartdns_queries_total", "Queries received by listeners.", "listener", "protocol", "qtype", "rcode")
+	QueryDuration = NewHistogramVec("smartdns_query_duration_seconds", "Time to handle client queries, from receiving to writing the response.", DefaultBuckets, "listener", "protocol")
+
+	CacheHits      = NewCounterVec("smartdns_cache_hits_total", "Cache lookups that found a valid answer.")
+	CacheMisses    = NewCounterVec("smartdns_cache_misses_total", "Cache lookups that found nothing or an expired answer.")
+	CacheEvictions = NewCounterVec("smartdns_cache_evictions_total", "Cache entries removed because the cache is full.")
+
+	BundleRequests   = NewCounterVec("smartdns_bundle_requests_total", "Queries sent to DNSBunch.", "bundle")
+	UpstreamRequests = NewCounterVec("smartdns_upstream_requests_total", "Queries sent to upstream servers.", "bundle", "upstream")
+	UpstreamErrors   = NewCounterVec("smartdns_upstream_errors_total", "Queries that got no usable response from upstream servers.", "bundle", "upstream")
+	UpstreamDuration = NewHistogramVec("smartdns_upstream_request_duration_seconds", "Latency of upstream servers.", DefaultBuckets, "bundle", "upstream")
+
+	BlockedQueries = NewCounterVec("smartdns_blocked_queries_total", "Queries rejected by type or answered with blocked answers.")
+
+	FilterMatches = NewCounterVec("smartdns_filter_matches_total", "Queries that matched the domain or IP network list of DNSFilter.", "filter", "list")
+
+	DetectorRuns   = NewCounterVec("smartdns_detector_runs_total", "Runs of the fastest IP detector.")
+	FastMapUpdates = NewCounterVec("smartdns_fastmap_updates_total", "Cache entries updated with detector results.")
+)
+
+// Top domains shown by dashboard
+var (
+	TopQueried = NewTop(topCapacity)
+	TopBlocked = NewTop(topCapacity)
+)
+
+// topCapacity bounds names tracked by TopQueried and TopBlocked
+const topCapacity = 2000
+
+// QType func return label value of query type, unknown types share one value to bound cardinality
+func QType(t uint16) string {
+	if s, ok := dns.TypeToString[t]; ok {
+		return s
+	}
+	return "OTHER"
+}
+
+// Rcode func return label value of response code
+func Rcode(rcode int) string {
+	if s, ok := dns.RcodeToString[rcode]; ok {
+		return s
+	}
+	return strconv.Itoa(rcode)
+}
@@ -0,0 +1,71 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package metrics
+
+import (
+	"sort"
+	"sync"
+)
+
+// Top counts occurrences of names with bounded memory, it is not exposed as Prometheus metric
+// When capacity is reached all counts are halved and names that drop to zero are forgotten,
+// so rare names make room for new ones and the ranking leans to recent traffic.
+type Top struct {
+	lock     sync.Mutex
+	capacity int
+	counts   map[string]uint64
+}
+
+// TopEntry is a name and its count
+type TopEntry struct {
+	Name  string `json:"name"`
+	Count uint64 `json:"count"`
+}
+
+// NewTop func create Top that keeps at most capacity names
+func NewTop(capacity int) *Top {
+	return &Top{capacity: capacity, counts: make(map[string]uint64)}
+}
+
+// Inc func add 1 to count of name
+func (t *Top) Inc(name string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.counts[name]; !ok {
+		for len(t.counts) >= t.capacity {
+			for k, v := range t.counts {
+				if v /= 2; v == 0 {
+					delete(t.counts, k)
+				} else {
+					t.counts[k] = v
+				}
+			}
+		}
+	}
+	t.counts[name]++
+}
+
+// List func return n names with the largest counts in descending order
+func (t *Top) List(n int) []TopEntry {
+	t.lock.Lock()
+	rs := make([]TopEntry, 0, len(t.counts))
+	for k, v := range t.counts {
+		rs = append(rs, TopEntry{k, v})
+	}
+	t.lock.Unlock()
+
+	sort.Slice(rs, func(i, j int) bool {
+		if rs[i].Count != rs[j].Count {
+			return rs[i].Count > rs[j].Count
+		}
+		return rs[i].Name < rs[j].Name
+	})
+	if len(rs) > n {
+		rs = rs[:n]
+	}
+	return rs
+}
@@ -22,15 +22,15 @@ type LocalClient struct {
 	responseMessage *dns.Msg
 	questionMessage *dns.Msg
 
-	minimumTTL   int
-	domainTTLMap map[string]uint32
+	minimumTTL     int
+	domainTTLRules *common.TTLRules
 
 	hosts   *hosts.Hosts
 	rawName string
 }
 
-func NewLocalClient(q *dns.Msg, h *hosts.Hosts, minimumTTL int, domainTTLMap map[string]uint32) *LocalClient {
-	c := &LocalClient{questionMessage: q.Copy(), hosts: h, minimumTTL: minimumTTL, domainTTLMap: domainTTLMap}
+func NewLocalClient(q *dns.Msg, h *hosts.Hosts, minimumTTL int, domainTTLRules *common.TTLRules) *LocalClient {
+	c := &LocalClient{questionMessage: q.Copy(), hosts: h, minimumTTL: minimumTTL, domainTTLRules: domainTTLRules}
 	c.rawName = c.questionMessage.Question[0].Name
 	// require domain name is c.rawName
 	return c
@@ -40,7 +40,7 @@ func (c *LocalClient) Exchange() *dns.Msg {
 	if c.exchangeFromHosts() || c.exchangeFromIP() {
 		if c.responseMessage != nil {
 			common.SetMinimumTTL(c.responseMessage, uint32(c.minimumTTL))
-			common.SetTTLByMap(c.responseMessage, c.domainTTLMap)
+			common.SetTTLByRules(c.responseMessage, c.domainTTLRules)
 		}
 		return c.responseMessage
 	}
@@ -53,13 +53,26 @@ func (c *LocalClient) exchangeFromHosts() bool {
 		return false
 	}
 
+	if c.questionMessage.Question[0].Qtype == dns.TypePTR {
+		name, ttl := c.hosts.FindPTR(c.rawName)
+		if name == "" {
+			return false
+		}
+		ptr := &dns.PTR{
+			Hdr: dns.RR_Header{Name: c.rawName, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl},
+			Ptr: dns.Fqdn(name),
+		}
+		c.setLocalResponseMessage([]dns.RR{ptr})
+		return true
+	}
+
 	name := c.rawName[:len(c.rawName)-1]
-	ipv4List, ipv6List := c.hosts.Find(name)
-	// regex ipv4 & ipv6 list
+	ipv4List, ipv6List, ttl := c.hosts.Find(name)
+	// exact & wildcard ipv4 & ipv6 list
 	if c.questionMessage.Question[0].Qtype == dns.TypeA && len(ipv4List) > 0 {
 		var rrl []dns.RR
 		for _, ip := range ipv4List {
-			a, _ := dns.NewRR(c.rawName + " IN A " + ip.String())
+			a := &dns.A{Hdr: dns.RR_Header{Name: c.rawName, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}, A: ip}
 			rrl = append(rrl, a)
 		}
 		c.setLocalResponseMessage(rrl)
@@ -69,7 +82,7 @@ func (c *LocalClient) exchangeFromHosts() bool {
 	} else if c.questionMessage.Question[0].Qtype == dns.TypeAAAA && len(ipv6List) > 0 {
 		var rrl []dns.RR
 		for _, ip := range ipv6List {
-			aaaa, _ := dns.NewRR(c.rawName + " IN AAAA " + ip.String())
+			aaaa := &dns.AAAA{Hdr: dns.RR_Header{Name: c.rawName, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl}, AAAA: ip}
 			rrl = append(rrl, aaaa)
 		}
 		c.setLocalResponseMessage(rrl)
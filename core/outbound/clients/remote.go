@@ -24,6 +24,7 @@ import (
 
 	"github.com/import-yuefeng/smartDNS/core/cache"
 	"github.com/import-yuefeng/smartDNS/core/common"
+	"github.com/import-yuefeng/smartDNS/core/dnstap"
 )
 
 type RemoteClient struct {
@@ -32,12 +33,16 @@ type RemoteClient struct {
 
 	dnsUpstream *common.DNSUpstream
 	inboundIP   string
+	filter      *common.Filter
+	tap         *dnstap.Tap
+	// duration of the last Exchange, set by RemoteClientBundle
+	duration time.Duration
 
 	cache *cache.Cache
 }
 
-func NewClient(q *dns.Msg, u *common.DNSUpstream, ip string, cache *cache.Cache) *RemoteClient {
-	c := &RemoteClient{questionMessage: q.Copy(), dnsUpstream: u, inboundIP: ip, cache: cache}
+func NewClient(q *dns.Msg, u *common.DNSUpstream, ip string, cache *cache.Cache, filter *common.Filter, tap *dnstap.Tap) *RemoteClient {
+	c := &RemoteClient{questionMessage: q.Copy(), dnsUpstream: u, inboundIP: ip, cache: cache, filter: filter, tap: tap}
 
 	return c
 }
@@ -98,13 +103,15 @@ func (c *RemoteClient) Exchange(isLog bool) *dns.Msg {
 
 	dc := &dns.Conn{Conn: conn}
 	defer dc.Close()
+	queryTime := time.Now()
+	c.emit(dnstap.ForwarderQuery, conn, queryTime, nil)
 	err := dc.WriteMsg(c.questionMessage)
 	// require dnsUpstream
 	if err != nil {
 		log.Warnf("%s Fail: Send question message failed", c.dnsUpstream.Name)
 		return nil
 	}
-	temp, err := dc.ReadMsg()
+	temp, err := c.readMsg(dc)
 	// read dnsUpstream response
 	if err != nil {
 		log.Debugf("%s Fail: %s", c.dnsUpstream.Name, err)
@@ -114,6 +121,16 @@ func (c *RemoteClient) Exchange(isLog bool) *dns.Msg {
 		log.Debugf("Fail: Response message returned nil, maybe timeout? Please check your query or DNS configuration")
 		return nil
 	}
+	c.emit(dnstap.ForwarderResponse, conn, queryTime, temp)
+	if c.filter.IsBogus(temp) {
+		if c.filter.FailOnBogus() {
+			log.Debugf("%s Fail: answer of %s contains bogus IP", c.dnsUpstream.Name, c.questionMessage.Question[0].Name)
+			return nil
+		}
+		log.Debugf("%s: answer of %s contains bogus IP, treated as NXDOMAIN", c.dnsUpstream.Name, c.questionMessage.Question[0].Name)
+		temp.Rcode = dns.RcodeNameError
+		temp.Answer = nil
+	}
 
 	c.responseMessage = temp
 
@@ -124,6 +141,51 @@ func (c *RemoteClient) Exchange(isLog bool) *dns.Msg {
 	return c.responseMessage
 }
 
+// emit func send dnstap message of the exchange with upstream, resp is nil for query
+func (c *RemoteClient) emit(typ dnstap.MessageType, conn net.Conn, queryTime time.Time, resp *dns.Msg) {
+	if !c.tap.Enabled() {
+		return
+	}
+	m := &dnstap.Message{Type: typ, QueryAddr: conn.LocalAddr(), ResponseAddr: conn.RemoteAddr(), QueryTime: queryTime}
+	m.QueryMessage, _ = c.questionMessage.Pack()
+	if resp != nil {
+		m.ResponseTime = time.Now()
+		m.ResponseMessage, _ = resp.Pack()
+	}
+	c.tap.Emit(m)
+}
+
+// readMsg func read the response to questionMessage. With anti-poisoning, a forged UDP response usually
+// arrives first, so the second response read within the window is preferred
+func (c *RemoteClient) readMsg(dc *dns.Conn) (*dns.Msg, error) {
+	first, err := c.readReply(dc)
+	window := c.filter.AntiPoisoningTimeout()
+	if err != nil || window == 0 || c.dnsUpstream.Protocol != "udp" || c.dnsUpstream.SOCKS5Address != "" {
+		return first, err
+	}
+
+	dc.SetReadDeadline(time.Now().Add(window))
+	if second, err := c.readReply(dc); err == nil {
+		log.Debugf("%s: second response is preferred (anti-poisoning)", c.dnsUpstream.Name)
+		return second, nil
+	}
+	return first, nil
+}
+
+// readReply func skip responses whose ID does not match the question
+func (c *RemoteClient) readReply(dc *dns.Conn) (*dns.Msg, error) {
+	for {
+		m, err := dc.ReadMsg()
+		if err != nil {
+			return nil, err
+		}
+		if m.Id == c.questionMessage.Id {
+			return m, nil
+		}
+		log.Debugf("%s: response with unexpected ID %d is dropped", c.dnsUpstream.Name, m.Id)
+	}
+}
+
 func (c *RemoteClient) logAnswer(indicator string) {
 
 	for _, a := range c.responseMessage.Answer {
@@ -12,10 +12,14 @@
 package clients
 
 import (
+	"time"
+
 	"github.com/miekg/dns"
 
 	"github.com/import-yuefeng/smartDNS/core/cache"
 	"github.com/import-yuefeng/smartDNS/core/common"
+	"github.com/import-yuefeng/smartDNS/core/dnstap"
+	"github.com/import-yuefeng/smartDNS/core/metrics"
 )
 
 type RemoteClientBundle struct {
@@ -24,10 +28,12 @@ type RemoteClientBundle struct {
 
 	clients []*RemoteClient
 
-	dnsUpstreams []*common.DNSUpstream
-	inboundIP    string
-	minimumTTL   int
-	domainTTLMap map[string]uint32
+	dnsUpstreams   []*common.DNSUpstream
+	inboundIP      string
+	minimumTTL     int
+	domainTTLRules *common.TTLRules
+	filter         *common.Filter
+	tap            *dnstap.Tap
 
 	cache *cache.Cache
 	Name  string
@@ -40,15 +46,27 @@ type CacheMessage struct {
 	MinimumTTL int
 	BundleName string
 	DomainName string
+	// Upstream is name of the upstream that answered
+	Upstream string
+	// Upstreams are the upstreams that finished before the answer is chosen, in order
+	Upstreams []UpstreamResult
+}
+
+// UpstreamResult is the exchange with one upstream, it is used to explain queries
+type UpstreamResult struct {
+	Name     string
+	Duration time.Duration
+	// Response is nil if the upstream failed or its answer is dropped
+	Response *dns.Msg
 }
 
-func NewClientBundle(q *dns.Msg, ul []*common.DNSUpstream, ip string, minimumTTL int, cache *cache.Cache, name string, domainTTLMap map[string]uint32) *RemoteClientBundle {
+func NewClientBundle(q *dns.Msg, ul []*common.DNSUpstream, ip string, minimumTTL int, cache *cache.Cache, name string, domainTTLRules *common.TTLRules, filter *common.Filter, tap *dnstap.Tap) *RemoteClientBundle {
 
-	cb := &RemoteClientBundle{questionMessage: q.Copy(), dnsUpstreams: ul, inboundIP: ip, minimumTTL: minimumTTL, cache: cache, Name: name, domainTTLMap: domainTTLMap}
+	cb := &RemoteClientBundle{questionMessage: q.Copy(), dnsUpstreams: ul, inboundIP: ip, minimumTTL: minimumTTL, cache: cache, Name: name, domainTTLRules: domainTTLRules, filter: filter, tap: tap}
 
 	for _, u := range ul {
 
-		c := NewClient(cb.questionMessage, u, cb.inboundIP, cb.cache)
+		c := NewClient(cb.questionMessage, u, cb.inboundIP, cb.cache, cb.filter, cb.tap)
 		cb.clients = append(cb.clients, c)
 	}
 
@@ -56,10 +74,19 @@ func NewClientBundle(q *dns.Msg, ul []*common.DNSUpstream, ip string, minimumTTL
 }
 
 func (cb *RemoteClientBundle) Exchange(isLog bool) *CacheMessage {
+	metrics.BundleRequests.Inc(cb.Name)
 	ch := make(chan *RemoteClient, len(cb.clients))
 	for _, o := range cb.clients {
 		go func(c *RemoteClient, ch chan *RemoteClient) {
-			c.Exchange(isLog)
+			start := time.Now()
+			metrics.UpstreamRequests.Inc(cb.Name, c.dnsUpstream.Name)
+			resp := c.Exchange(isLog)
+			c.duration = time.Since(start)
+			if resp == nil {
+				metrics.UpstreamErrors.Inc(cb.Name, c.dnsUpstream.Name)
+			} else {
+				metrics.UpstreamDuration.Observe(c.duration.Seconds(), cb.Name, c.dnsUpstream.Name)
+			}
 			ch <- c
 		}(o, ch)
 	}
@@ -71,17 +98,21 @@ func (cb *RemoteClientBundle) Exchange(isLog bool) *CacheMessage {
 	for i := 0; i < len(cb.clients); i++ {
 		c := <-ch
 		if c != nil {
+			cacheMessage.Upstreams = append(cacheMessage.Upstreams, UpstreamResult{c.dnsUpstream.Name, c.duration, c.responseMessage})
+		}
+		if c != nil && c.responseMessage != nil {
 			ec = c
 			break
-			// use dns that first response
+			// use dns that first response, failed and dropped (bogus) responses wait for other upstreams
 		}
 	}
 	if ec != nil && ec.responseMessage != nil {
 		cacheMessage.ResponseMessage = ec.responseMessage
 		cacheMessage.QuestionMessage = ec.questionMessage
+		cacheMessage.Upstream = ec.dnsUpstream.Name
 
 		common.SetMinimumTTL(cacheMessage.ResponseMessage, uint32(cacheMessage.MinimumTTL))
-		common.SetTTLByMap(cacheMessage.ResponseMessage, cb.domainTTLMap)
+		common.SetTTLByRules(cacheMessage.ResponseMessage, cb.domainTTLRules)
 	}
 
 	return cacheMessage
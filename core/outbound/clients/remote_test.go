@@ -0,0 +1,115 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package clients
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/import-yuefeng/smartDNS/core/common"
+	"github.com/import-yuefeng/smartDNS/core/matcher/iptrie"
+)
+
+// fakeUpstream answers every query with one A response for each address, in order
+func fakeUpstream(t *testing.T, badID bool, addresses ...string) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer conn.Close()
+		buf := make([]byte, 512)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		q := new(dns.Msg)
+		if q.Unpack(buf[:n]) != nil {
+			return
+		}
+		if badID {
+			r := new(dns.Msg)
+			r.SetReply(q)
+			r.Id++
+			b, _ := r.Pack()
+			conn.WriteTo(b, addr)
+		}
+		for _, a := range addresses {
+			r := new(dns.Msg)
+			r.SetReply(q)
+			rr, _ := dns.NewRR(q.Question[0].Name + " 60 IN A " + a)
+			r.Answer = append(r.Answer, rr)
+			b, _ := r.Pack()
+			conn.WriteTo(b, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func exchangeA(t *testing.T, addr string, f *common.Filter) *dns.Msg {
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	u := &common.DNSUpstream{Name: "fake", Address: addr, Protocol: "udp", Timeout: 3}
+	return NewClient(q, u, "", nil, f, nil).Exchange(false)
+}
+
+func TestRemoteClient_AntiPoisoning(t *testing.T) {
+	f := &common.Filter{AntiPoisoning: true, AntiPoisoningWindow: 200}
+	r := exchangeA(t, fakeUpstream(t, true, "6.6.6.6", "93.184.216.34"), f)
+	if r == nil || len(r.Answer) != 1 || r.Answer[0].(*dns.A).A.String() != "93.184.216.34" {
+		t.Errorf("second response should be preferred: %v", r)
+	}
+
+	r = exchangeA(t, fakeUpstream(t, false, "93.184.216.34"), f)
+	if r == nil || len(r.Answer) != 1 {
+		t.Errorf("single response should be kept: %v", r)
+	}
+
+	r = exchangeA(t, fakeUpstream(t, false, "6.6.6.6", "93.184.216.34"), nil)
+	if r == nil || r.Answer[0].(*dns.A).A.String() != "6.6.6.6" {
+		t.Errorf("first response should be used without anti-poisoning: %v", r)
+	}
+}
+
+func TestRemoteClient_BogusIP(t *testing.T) {
+	bogus := iptrie.New()
+	bogus.Insert("6.6.6.0/24")
+
+	f := &common.Filter{BogusIPList: bogus}
+	r := exchangeA(t, fakeUpstream(t, false, "6.6.6.6"), f)
+	if r == nil || r.Rcode != dns.RcodeNameError || len(r.Answer) != 0 {
+		t.Errorf("bogus answer should be NXDOMAIN: %v", r)
+	}
+
+	f.BogusIPAction = common.BogusIPFail
+	if r := exchangeA(t, fakeUpstream(t, false, "6.6.6.6"), f); r != nil {
+		t.Errorf("bogus answer should be dropped: %v", r)
+	}
+
+	if r := exchangeA(t, fakeUpstream(t, false, "93.184.216.34"), f); r == nil || len(r.Answer) != 1 {
+		t.Errorf("good answer should be kept: %v", r)
+	}
+}
+
+func TestRemoteClientBundle_WaitsForGoodAnswer(t *testing.T) {
+	bogus := iptrie.New()
+	bogus.Insert("6.6.6.6")
+	f := &common.Filter{BogusIPList: bogus, BogusIPAction: common.BogusIPFail}
+
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+	ul := []*common.DNSUpstream{
+		{Name: "hijack", Address: fakeUpstream(t, false, "6.6.6.6"), Protocol: "udp", Timeout: 3},
+		{Name: "good", Address: fakeUpstream(t, false, "93.184.216.34"), Protocol: "udp", Timeout: 3},
+	}
+	result := NewClientBundle(q, ul, "", 0, nil, "CN", nil, f, nil).Exchange(false)
+	if result.ResponseMessage == nil || result.ResponseMessage.Answer[0].(*dns.A).A.String() != "93.184.216.34" {
+		t.Errorf("bundle should use the good upstream: %v", result.ResponseMessage)
+	}
+}
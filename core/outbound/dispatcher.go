@@ -15,23 +15,36 @@ import (
 	"github.com/import-yuefeng/smartDNS/core/cache"
 	"github.com/import-yuefeng/smartDNS/core/common"
 	"github.com/import-yuefeng/smartDNS/core/cron"
+	"github.com/import-yuefeng/smartDNS/core/dnstap"
 	"github.com/import-yuefeng/smartDNS/core/hosts"
 	"github.com/import-yuefeng/smartDNS/core/matcher"
+	"github.com/import-yuefeng/smartDNS/core/metrics"
 	"github.com/import-yuefeng/smartDNS/core/outbound/clients"
+	"github.com/import-yuefeng/smartDNS/core/zone"
 )
 
 // Dispatcher struct
 type Dispatcher struct {
 	RedirectIPv6Record bool
 	MinimumTTL         int
-	DomainTTLMap       map[string]uint32
+	DomainTTLRules     *common.TTLRules
+	Rewrite            *common.Rewrite
+	Rebinding          *common.Rebinding
+	Zones              *zone.Zones
 	DefaultDNSBundle   string
-	DNSFilter          map[string]*common.Filter
-	DNSBunch           map[string][]*common.DNSUpstream
-	Hosts              *hosts.Hosts
-	Cache              *cache.Cache
-	CacheTimer         *cron.CacheManager
-	SmartDNS           bool
+	// PrivateReverseBundle answers reverse queries of private addresses, they get NXDOMAIN locally if it is empty
+	PrivateReverseBundle string
+	DNSFilter            map[string]*common.Filter
+	DNSBunch             map[string][]*common.DNSUpstream
+	Hosts                *hosts.Hosts
+	Cache                *cache.Cache
+	CacheTimer           *cron.CacheManager
+	SmartDNS             bool
+	// Tap receives dnstap messages of clients and upstreams, it may be nil
+	Tap *dnstap.Tap
+
+	// flight coalesces identical queries in flight
+	flight flightGroup
 }
 
 // BundleMsg struct isSelectDomain func return match result
@@ -49,80 +62,153 @@ type Bundle struct {
 type HitTask struct {
 	hitRemoteClientBundle *clients.RemoteClientBundle
 	isHit                 bool
+	// isRuntime is true if the result comes from domains changed at runtime
+	isRuntime bool
 }
 
-// Exchange func will dispatch dns query (Priority: client(hosts & ip), cache-lru, domain list, ip list, defaultDNS)
+// Exchange func will dispatch dns query (Priority: rewrite, client(hosts & ip), local zone, private reverse zone, cache-lru, domain list, ip list, defaultDNS)
 func (d *Dispatcher) Exchange(query *dns.Msg, inboundIP string) *dns.Msg {
+	resp, _ := d.exchangeOnce(query, inboundIP)
+	return resp
+}
+
+// ExchangeWithInfo func is same as Exchange, and it also returns how the query is answered
+func (d *Dispatcher) ExchangeWithInfo(query *dns.Msg, inboundIP string) (*dns.Msg, *QueryInfo) {
+	return d.exchangeOnce(query, inboundIP)
+}
+
+func (d *Dispatcher) exchange(query *dns.Msg, inboundIP string, depth int, info *QueryInfo) *dns.Msg {
 	bundle := new(Bundle)
 	bundle.ClientBundle = make(map[string]*clients.RemoteClientBundle)
 	for name, v := range d.DNSBunch {
-		bundle.ClientBundle[name] = clients.NewClientBundle(query, v, inboundIP, d.MinimumTTL, d.Cache, name, d.DomainTTLMap)
+		bundle.ClientBundle[name] = clients.NewClientBundle(query, v, inboundIP, d.MinimumTTL, d.Cache, name, d.DomainTTLRules, d.DNSFilter[name], d.Tap)
+	}
+
+	// address and CNAME rewrite, bundle override
+	if resp := d.exchangeByRewrite(query, inboundIP, depth, bundle, info); resp != nil {
+		return resp
 	}
 
 	var ActiveClientBundle *clients.RemoteClientBundle
 	// local hosts, ip
-	localClient := clients.NewLocalClient(query, d.Hosts, d.MinimumTTL, d.DomainTTLMap)
+	localClient := clients.NewLocalClient(query, d.Hosts, d.MinimumTTL, d.DomainTTLRules)
 	resp := localClient.Exchange()
 	if resp != nil {
 		// find item in local host/ip list
+		info.Reason = ReasonLocal
+		info.trace("local", "answered by hosts or IP literal")
 		return resp
 	}
+	info.trace("local", "no hosts entry")
+
+	// local authoritative zones
+	if z := d.Zones.Find(query.Question[0].Name); z != nil {
+		info.Reason = ReasonZone
+		info.trace("zone", "answered by local zone %s", z.Origin)
+		return z.Answer(query)
+	}
 
 	// Global cache(be shared all DNSBunch)
 	cacheClient := clients.NewCacheClient(query, d.Cache)
 	isHit, bundleName, msg := cacheClient.Exchange()
+	if d.Cache != nil {
+		info.Cache = CacheMiss
+	}
 	if isHit {
 		if msg != nil {
+			info.Cache = CacheHit
+			info.Reason, info.Bundle = ReasonCache, bundleName
+			info.trace("cache", "hit, answered by %s before", bundleName)
 			return msg
 		} else if msg == nil && bundleName != "" {
 			log.Infof("Hit Cache, msg is expiration, but bundleName: %s\n", bundleName)
+			info.Cache = CacheExpired
+			info.trace("cache", "expired, ask %s again", bundleName)
 			ActiveClientBundle = bundle.ClientBundle[bundleName]
 			if result := ActiveClientBundle.Exchange(true); result != nil {
 				result.BundleName = ActiveClientBundle.Name
-				d.CacheResultIfNeeded(result, bundle.ClientBundle)
+				info.setResult(ReasonCache, result)
+				info.traceUpstreams(result)
+				info.Blocked = d.Rebinding.Check(result.ResponseMessage)
+				d.cacheResult(info, result, bundle.ClientBundle)
 				return result.ResponseMessage
 			}
 		}
 	}
 
+	if d.Cache == nil {
+		info.trace("cache", "disabled")
+	} else if !isHit {
+		info.trace("cache", "miss")
+	}
+
+	// reverse zones of private addresses (RFC 6303), answers of PrivateReverseBundle are served by cache above
+	if resp := d.exchangePrivateReverse(query, bundle, info); resp != nil {
+		return resp
+	}
+
 	// local Domain, ip
 	ch := make(chan *HitTask, len(bundle.ClientBundle))
 	bundleLenght := len(bundle.ClientBundle)
-	var c = new(HitTask)
 
 	for bunchName := range bundle.ClientBundle {
 		go func(ch chan *HitTask, bunchName string) {
-			c.isHit = d.isSelectDomain(bundle.ClientBundle[bunchName], d.DNSFilter[bunchName].DomainList)
+			c := new(HitTask)
 			c.hitRemoteClientBundle = bundle.ClientBundle[bunchName]
+			// domains changed by admin API take precedence over domain list
+			if c.isHit, c.isRuntime = d.DNSFilter[bunchName].MatchRuntimeDomain(c.hitRemoteClientBundle.GetFirstQuestionDomain()); !c.isRuntime {
+				c.isHit = d.isSelectDomain(c.hitRemoteClientBundle, d.DNSFilter[bunchName].GetDomainList())
+			}
 			ch <- c
 			return
 		}(ch, bunchName)
 	}
 
+	reason := ReasonDomain
 	for bundleLenght > 0 {
 		if x, ok := <-ch; ok {
 			bundleLenght--
+			name := x.hitRemoteClientBundle.Name
+			if x.isHit && x.isRuntime {
+				info.trace("domain", "%s: matched by domain added at runtime", name)
+				ActiveClientBundle = x.hitRemoteClientBundle
+				break
+			}
 			if x.isHit {
+				info.trace("domain", "%s: matched by domain list (%s)", name, d.DNSFilter[name].GetDomainList().Name())
 				ActiveClientBundle = x.hitRemoteClientBundle
 				break
 			}
+			info.trace("domain", "%s: not matched", name)
 		}
 	}
 	if ActiveClientBundle == nil {
 		log.Warnf("Domain match failed. will check ip list or use default DNS: %s(If not nil)", d.DefaultDNSBundle)
-		if resp := d.selectByIPNetwork(bundle); resp != nil {
+		if resp := d.selectByIPNetwork(bundle, info); resp != nil {
 			log.Info("Match ip!")
-			d.CacheResultIfNeeded(resp.result, bundle.ClientBundle)
+			resp.result.BundleName = resp.bundleName
+			info.setResult(ReasonIPNetwork, resp.result)
+			info.Blocked = d.Rebinding.Check(resp.result.ResponseMessage)
+			d.cacheResult(info, resp.result, bundle.ClientBundle)
 			return resp.result.ResponseMessage
 		}
 	}
 	if ActiveClientBundle == nil && d.DefaultDNSBundle != "" {
 		log.Warnf("Use default dns bundle: %s", d.DefaultDNSBundle)
 		ActiveClientBundle = bundle.ClientBundle[d.DefaultDNSBundle]
+		reason = ReasonDefault
+		info.trace("default", "use DefaultDNSBundle %s", d.DefaultDNSBundle)
+	}
+	if ActiveClientBundle == nil {
+		info.trace("default", "no DefaultDNSBundle")
+		return nil
 	}
 	if result := ActiveClientBundle.Exchange(true); result != nil {
 		result.BundleName = ActiveClientBundle.Name
-		d.CacheResultIfNeeded(result, bundle.ClientBundle)
+		info.setResult(reason, result)
+		info.traceUpstreams(result)
+		info.Blocked = d.Rebinding.Check(result.ResponseMessage)
+		d.cacheResult(info, result, bundle.ClientBundle)
 		return result.ResponseMessage
 	}
 
@@ -130,6 +216,15 @@ func (d *Dispatcher) Exchange(query *dns.Msg, inboundIP string) *dns.Msg {
 
 }
 
+// cacheResult func cache the result unless the query is explained, explaining a query must not change the cache
+func (d *Dispatcher) cacheResult(info *QueryInfo, cacheMessage *clients.CacheMessage, ClientBundle map[string]*clients.RemoteClientBundle) {
+	if info.explain {
+		info.trace("cache", "not stored, query is explained")
+		return
+	}
+	d.CacheResultIfNeeded(cacheMessage, ClientBundle)
+}
+
 // CacheResultIfNeeded func will insert cache to lru cache link-list
 func (d *Dispatcher) CacheResultIfNeeded(cacheMessage *clients.CacheMessage, ClientBundle map[string]*clients.RemoteClientBundle) {
 	if d.Cache != nil && cacheMessage.ResponseMessage != nil {
@@ -173,6 +268,7 @@ func (d *Dispatcher) isSelectDomain(rcb *clients.RemoteClientBundle, dt matcher.
 				"domain":   qn,
 			}).Debug("Matched")
 			log.Debugf("Finally use %s DNS", rcb.Name)
+			metrics.FilterMatches.Inc(rcb.Name, "domain")
 			return true
 		}
 
@@ -184,7 +280,7 @@ func (d *Dispatcher) isSelectDomain(rcb *clients.RemoteClientBundle, dt matcher.
 	return false
 }
 
-func (d *Dispatcher) selectByIPNetwork(bundle *Bundle) *BundleMsg {
+func (d *Dispatcher) selectByIPNetwork(bundle *Bundle, info *QueryInfo) *BundleMsg {
 
 	ch := make(chan *BundleMsg, len(bundle.ClientBundle))
 	Response := make(map[string]*clients.CacheMessage)
@@ -210,7 +306,10 @@ func (d *Dispatcher) selectByIPNetwork(bundle *Bundle) *BundleMsg {
 		for bundleName, a := range Response {
 			if a == nil {
 				continue
-			} else if a.ResponseMessage == nil {
+			}
+			a.BundleName = bundleName
+			info.traceUpstreams(a)
+			if a.ResponseMessage == nil {
 				continue
 			}
 			for i := range a.ResponseMessage.Answer {
@@ -224,10 +323,14 @@ func (d *Dispatcher) selectByIPNetwork(bundle *Bundle) *BundleMsg {
 				} else {
 					continue
 				}
-				if common.IsIPMatchList(ip, d.DNSFilter[bundleName].IPNetworkList, true, bundleName) {
+				if d.DNSFilter[bundleName].GetIPNetworkList().Contains(ip) {
+					info.trace("ip-network", "%s: answer %s is in IP network list", bundleName, ip)
+					log.Debugf("Matched: IP network %s %s", bundleName, ip.String())
 					log.Debugf("(IPMatcher)Finally use: %s", bundleName)
+					metrics.FilterMatches.Inc(bundleName, "ip_network")
 					return &BundleMsg{a, bundleName}
 				}
+				info.trace("ip-network", "%s: answer %s is not in IP network list", bundleName, ip)
 			}
 
 		}
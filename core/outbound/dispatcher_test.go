@@ -1,106 +1,132 @@
 package outbound
 
 import (
+	"io/ioutil"
+	"net"
 	"os"
+	"sync/atomic"
 	"testing"
-	"time"
 
 	"github.com/miekg/dns"
 
+	"github.com/import-yuefeng/smartDNS/core/cache"
 	"github.com/import-yuefeng/smartDNS/core/common"
-	"github.com/import-yuefeng/smartDNS/core/config"
+	"github.com/import-yuefeng/smartDNS/core/hosts"
 )
 
-var c *config.Config
-var d Dispatcher
-
-func init() {
-	os.Chdir("../..")
-	c = config.NewConfig("config.test.json")
-	d = Dispatcher{
-		PrimaryDNS:            c.PrimaryDNS,
-		AlternativeDNS:        c.AlternativeDNS,
-		OnlyPrimaryDNS:        c.OnlyPrimaryDNS,
-		IPNetworkPrimaryList:  c.IPNetworkPrimaryList,
-		DomainAlternativeList: c.DomainAlternativeList,
-		DomainPrimaryList:     c.DomainPrimaryList,
-		RedirectIPv6Record:    c.IPv6UseAlternativeDNS,
-		Hosts:                 c.Hosts,
-		Cache:                 c.Cache,
+func newTestDispatcher(t *testing.T) *Dispatcher {
+	f, err := ioutil.TempFile("", "hosts")
+	if err != nil {
+		t.Fatal(err)
 	}
-}
-
-func TestDispatcher(t *testing.T) {
-
-	testHosts(t)
-	testIPResponse(t)
-
-	testAAAA(t)
-	testForeign(t)
-
-	d.DomainAlternativeList = nil
-	testDomestic(t)
-	testForeign(t)
-
-	testCache(t)
-}
-
-func testDomestic(t *testing.T) {
+	defer os.Remove(f.Name())
+	f.WriteString("127.0.0.1 localhost\n")
+	f.Close()
 
-	resp := exchange("www.baidu.com.", dns.TypeA)
-	if common.FindRecordByType(resp, dns.TypeA) == "" {
-		t.Error("baidu.com should have an A record")
+	h, err := hosts.New(f.Name())
+	if err != nil {
+		t.Fatal(err)
 	}
+	return &Dispatcher{Hosts: h, Cache: cache.New(16)}
 }
 
-func testForeign(t *testing.T) {
+func TestDispatcher(t *testing.T) {
+	d := newTestDispatcher(t)
 
-	resp := exchange("www.twitter.com.", dns.TypeA)
-	if common.FindRecordByType(resp, dns.TypeCNAME) != "twitter.com." {
-		t.Error("twitter.com should have an twitter.com CNAME record")
-	}
+	testHosts(t, d)
+	testIPResponse(t, d)
+	testCache(t, d)
 }
 
-func testAAAA(t *testing.T) {
-
-	resp := exchange("www.twitter.com.", dns.TypeAAAA)
-	if common.FindRecordByType(resp, dns.TypeAAAA) != "" {
-		t.Error("twitter.com should not have AAAA record")
-	}
-}
+func testHosts(t *testing.T, d *Dispatcher) {
 
-func testHosts(t *testing.T) {
-
-	resp := exchange("localhost.", dns.TypeA)
+	resp := exchange(d, "localhost.", dns.TypeA)
 	if common.FindRecordByType(resp, dns.TypeA) != "127.0.0.1" {
 		t.Error("localhost should be 127.0.0.1")
 	}
 }
 
-func testIPResponse(t *testing.T) {
+func testIPResponse(t *testing.T, d *Dispatcher) {
 
-	resp := exchange("127.0.0.1.", dns.TypeA)
+	resp := exchange(d, "127.0.0.1.", dns.TypeA)
 	if common.FindRecordByType(resp, dns.TypeA) != "127.0.0.1" {
 		t.Error("127.0.0.1 should be 127.0.0.1")
 	}
 
-	resp = exchange("fe80::7f:4f42:3f4d:f4c8.", dns.TypeAAAA)
+	resp = exchange(d, "fe80::7f:4f42:3f4d:f4c8.", dns.TypeAAAA)
 	if common.FindRecordByType(resp, dns.TypeAAAA) != "fe80::7f:4f42:3f4d:f4c8" {
 		t.Error("fe80::7f:4f42:3f4d:f4c8 should be fe80::7f:4f42:3f4d:f4c8")
 	}
 }
 
-func testCache(t *testing.T) {
+func testCache(t *testing.T, d *Dispatcher) {
 
-	exchange("www.cnn.com.", dns.TypeA)
-	now := time.Now()
-	exchange("www.cnn.com.", dns.TypeA)
-	if time.Since(now) > 10*time.Millisecond {
-		t.Error("Cache response slower than 10ms")
+	q := new(dns.Msg)
+	q.SetQuestion("www.cnn.com.", dns.TypeA)
+	m := new(dns.Msg)
+	m.SetReply(q)
+	m.Answer = append(m.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: "www.cnn.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.ParseIP("151.101.1.67"),
+	})
+	d.Cache.Insert(cache.Key(q.Question[0]), m, 300, "remote", "www.cnn.com.")
+
+	resp, info := d.ExchangeWithInfo(q, "")
+	if common.FindRecordByType(resp, dns.TypeA) != "151.101.1.67" || info.Reason != ReasonCache {
+		t.Errorf("www.cnn.com should be answered by cache, reason %s", info.Reason)
+	}
+}
+
+// newTestUpstream func serve answers of rdata for every question on a local udp port, count is increased per query
+func newTestUpstream(t *testing.T, rdata string, count *int32) (u *common.DNSUpstream, stop func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, q *dns.Msg) {
+		if count != nil {
+			atomic.AddInt32(count, 1)
+		}
+		r := new(dns.Msg)
+		r.SetReply(q)
+		rr, _ := dns.NewRR(q.Question[0].Name + " " + rdata)
+		r.Answer = append(r.Answer, rr)
+		w.WriteMsg(r)
+	})}
+	go server.ActivateAndServe()
+
+	u = &common.DNSUpstream{Name: "fake", Address: conn.LocalAddr().String(), Protocol: "udp", Timeout: 3}
+	return u, func() { server.Shutdown() }
+}
+
+func TestDispatcher_PrivateReverseCache(t *testing.T) {
+	var count int32
+	u, stop := newTestUpstream(t, "60 IN PTR nas.lan.", &count)
+	defer stop()
+
+	d := &Dispatcher{
+		DefaultDNSBundle:     "remote",
+		PrivateReverseBundle: "lan",
+		DNSBunch:             map[string][]*common.DNSUpstream{"remote": {}, "lan": {u}},
+		DNSFilter:            map[string]*common.Filter{"remote": {}, "lan": {}},
+		Cache:                cache.New(16),
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("2.1.168.192.in-addr.arpa.", dns.TypePTR)
+
+	if _, info := d.ExchangeWithInfo(q, ""); info.Reason != ReasonPrivateReverse {
+		t.Errorf("first query should be sent to lan, reason %s", info.Reason)
+	}
+	resp, info := d.ExchangeWithInfo(q, "")
+	if common.FindRecordByType(resp, dns.TypePTR) != "nas.lan." || info.Reason != ReasonCache {
+		t.Errorf("second query should be answered by cache, reason %s", info.Reason)
+	}
+	if n := atomic.LoadInt32(&count); n != 1 {
+		t.Errorf("lan upstream is asked %d times, want 1", n)
 	}
 }
 
-func exchange(z string, t uint16) *dns.Msg {
+func exchange(d *Dispatcher, z string, t uint16) *dns.Msg {
 
 	q := new(dns.Msg)
 	q.SetQuestion(z, t)
@@ -0,0 +1,112 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package outbound
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/import-yuefeng/smartDNS/core/cache"
+)
+
+// coalescedQueries counts queries answered by an identical lookup in flight, it is kept across reloads
+var coalescedQueries uint64
+
+// CoalescedQueries func return count of queries that waited for an identical lookup instead of sending their own
+func CoalescedQueries() uint64 {
+	return atomic.LoadUint64(&coalescedQueries)
+}
+
+// flightCall is a lookup in flight, waiters block on wg and share msg and info
+type flightCall struct {
+	wg   sync.WaitGroup
+	msg  *dns.Msg
+	info QueryInfo
+}
+
+// flightGroup coalesces identical lookups, the zero value is ready to use
+type flightGroup struct {
+	sync.Mutex
+	calls map[string]*flightCall
+}
+
+// do func run fn once for all concurrent callers with the same key, shared is true for callers that waited
+func (g *flightGroup) do(key string, fn func() (*dns.Msg, QueryInfo)) (msg *dns.Msg, info QueryInfo, shared bool) {
+	g.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.Unlock()
+		c.wg.Wait()
+		return c.msg, c.info, true
+	}
+	c := new(flightCall)
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.Unlock()
+
+	// waiters must be released even if fn panics
+	defer func() {
+		g.Lock()
+		delete(g.calls, key)
+		g.Unlock()
+		c.wg.Done()
+	}()
+	c.msg, c.info = fn()
+	return c.msg, c.info, false
+}
+
+// flightKey func return key of query, queries with the same key get the same answer: cache key, class,
+// DO and CD bits and EDNS client subnet
+func flightKey(query *dns.Msg) string {
+	q := query.Question[0]
+	var key strings.Builder
+	key.WriteString(cache.Key(q))
+	key.WriteString(strconv.Itoa(int(q.Qclass)))
+	if query.CheckingDisabled {
+		key.WriteString("|cd")
+	}
+	if opt := query.IsEdns0(); opt != nil {
+		if opt.Do() {
+			key.WriteString("|do")
+		}
+		for _, o := range opt.Option {
+			if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+				key.WriteString("|ecs=")
+				key.WriteString(ecs.String())
+			}
+		}
+	}
+	return key.String()
+}
+
+// exchangeOnce func send identical concurrent queries upstream only once, every waiter gets a copy
+// with its own message ID
+func (d *Dispatcher) exchangeOnce(query *dns.Msg, inboundIP string) (*dns.Msg, *QueryInfo) {
+	msg, info, shared := d.flight.do(flightKey(query), func() (*dns.Msg, QueryInfo) {
+		var info QueryInfo
+		msg := d.exchange(query, inboundIP, 0, &info)
+		return msg, info
+	})
+	info.Coalesced = shared
+	if !shared || msg == nil {
+		return msg, &info
+	}
+
+	atomic.AddUint64(&coalescedQueries, 1)
+	log.Debugf("Query %s is coalesced with an identical lookup in flight", query.Question[0].String())
+	resp := msg.Copy()
+	resp.Id = query.Id
+	resp.Question = query.Question
+	return resp, &info
+}
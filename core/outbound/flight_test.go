@@ -0,0 +1,80 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package outbound
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestFlightGroup_Do(t *testing.T) {
+	var g flightGroup
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (*dns.Msg, QueryInfo) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		m := new(dns.Msg)
+		m.SetQuestion("example.com.", dns.TypeA)
+		return m, QueryInfo{Reason: ReasonDomain}
+	}
+
+	const n = 20
+	var wg sync.WaitGroup
+	var shared int32
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if m, info, s := g.do("k", fn); m == nil || info.Reason != ReasonDomain {
+				t.Error("result is not shared")
+			} else if s {
+				atomic.AddInt32(&shared, 1)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 || shared != n-1 {
+		t.Errorf("fn is called %d times, %d callers shared the result", calls, shared)
+	}
+	if len(g.calls) != 0 {
+		t.Errorf("%d calls are left in group", len(g.calls))
+	}
+}
+
+func TestFlightKey(t *testing.T) {
+	q := func(name string, do bool, subnet string) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		if do || subnet != "" {
+			m.SetEdns0(4096, do)
+		}
+		if subnet != "" {
+			m.IsEdns0().Option = append(m.IsEdns0().Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: []byte{1, 2, 3, 0}})
+		}
+		return m
+	}
+
+	a := q("example.com.", false, "")
+	b := q("example.com.", false, "")
+	b.Id = a.Id + 1
+	if flightKey(a) != flightKey(b) {
+		t.Error("message ID should not be part of key")
+	}
+	for _, other := range []*dns.Msg{q("example.org.", false, ""), q("example.com.", true, ""), q("example.com.", false, "1.2.3.0/24")} {
+		if flightKey(a) == flightKey(other) {
+			t.Errorf("key of %s should differ", other)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package outbound
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/import-yuefeng/smartDNS/core/outbound/clients"
+)
+
+// Reasons of QueryInfo, the step of dispatcher that answered the query
+const (
+	ReasonRewriteAddress = "rewrite-address"
+	ReasonRewriteCNAME   = "rewrite-cname"
+	ReasonRewriteBundle  = "rewrite-bundle"
+	ReasonLocal          = "local"
+	ReasonZone           = "zone"
+	ReasonPrivateReverse = "private-reverse"
+	ReasonCache          = "cache"
+	ReasonDomain         = "domain"
+	ReasonIPNetwork      = "ip-network"
+	ReasonDefault        = "default"
+)
+
+// Cache status of QueryInfo, empty if cache is not consulted
+const (
+	CacheHit     = "hit"
+	CacheExpired = "expired"
+	CacheMiss    = "miss"
+)
+
+// QueryInfo records how dispatcher answered a query
+type QueryInfo struct {
+	Reason   string
+	Bundle   string
+	Upstream string
+	Cache    string
+	// Coalesced is true if the answer is shared with an identical query in flight
+	Coalesced bool
+	// Blocked is true if answers are removed by rebinding protection or rewritten to unspecified address
+	Blocked bool
+	// Trace is only recorded by Explain
+	Trace []TraceStep
+
+	explain bool
+	start   time.Time
+}
+
+// TraceStep is one stage of an explained query, Elapsed is counted from the start of the query
+type TraceStep struct {
+	Stage   string
+	Detail  string
+	Elapsed time.Duration
+}
+
+// trace func record a step if the query is explained
+func (info *QueryInfo) trace(stage string, format string, args ...interface{}) {
+	if !info.explain {
+		return
+	}
+	info.Trace = append(info.Trace, TraceStep{stage, fmt.Sprintf(format, args...), time.Since(info.start)})
+}
+
+// traceUpstreams func record timing and result of every upstream of the bundle that finished
+func (info *QueryInfo) traceUpstreams(result *clients.CacheMessage) {
+	if !info.explain || result == nil {
+		return
+	}
+	if len(result.Upstreams) == 0 {
+		info.trace("upstream", "%s: no upstream answered", result.BundleName)
+	}
+	for _, u := range result.Upstreams {
+		status := "failed or dropped"
+		if u.Response != nil {
+			status = fmt.Sprintf("%s, %d answers", dns.RcodeToString[u.Response.Rcode], len(u.Response.Answer))
+		}
+		info.trace("upstream", "%s/%s: %s in %s", result.BundleName, u.Name, status, u.Duration)
+	}
+}
+
+// Explain func resolve query like Exchange without coalescing, every stage is recorded in Trace of QueryInfo
+func (d *Dispatcher) Explain(query *dns.Msg, inboundIP string) (*dns.Msg, *QueryInfo) {
+	info := &QueryInfo{explain: true, start: time.Now()}
+	resp := d.exchange(query, inboundIP, 0, info)
+	info.trace("done", "")
+	return resp, info
+}
+
+// WriteExplain func print trace and answer of an explained query
+func WriteExplain(w io.Writer, query *dns.Msg, resp *dns.Msg, info *QueryInfo) {
+	q := query.Question[0]
+	fmt.Fprintf(w, ";; explain %s %s\n", q.Name, dns.TypeToString[q.Qtype])
+	for _, s := range info.Trace {
+		if s.Stage == "done" {
+			continue
+		}
+		fmt.Fprintf(w, "%10.3fms  %-16s %s\n", float64(s.Elapsed)/float64(time.Millisecond), s.Stage, s.Detail)
+	}
+	fmt.Fprintf(w, ";; reason: %s, bundle: %s, upstream: %s, cache: %s\n", orNone(info.Reason), orNone(info.Bundle), orNone(info.Upstream), orNone(info.Cache))
+	if resp == nil {
+		fmt.Fprintln(w, ";; no answer (SERVFAIL)")
+		return
+	}
+	var total time.Duration
+	if n := len(info.Trace); n > 0 {
+		total = info.Trace[n-1].Elapsed
+	}
+	fmt.Fprintf(w, ";; rcode: %s, %d answers in %s\n", dns.RcodeToString[resp.Rcode], len(resp.Answer), total)
+	for _, rr := range resp.Answer {
+		fmt.Fprintln(w, rr.String())
+	}
+	for _, rr := range resp.Ns {
+		fmt.Fprintln(w, rr.String())
+	}
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// setResult func record reason and the bundle and upstream that answered
+func (info *QueryInfo) setResult(reason string, result *clients.CacheMessage) {
+	info.Reason = reason
+	if result != nil {
+		info.Bundle, info.Upstream = result.BundleName, result.Upstream
+	}
+}
@@ -0,0 +1,80 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package outbound
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/import-yuefeng/smartDNS/core/cache"
+	"github.com/import-yuefeng/smartDNS/core/common"
+	"github.com/import-yuefeng/smartDNS/core/hosts"
+)
+
+func TestDispatcher_Explain(t *testing.T) {
+	f, err := ioutil.TempFile("", "hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("10.0.0.1 nas.lan\n")
+	f.Close()
+
+	h, err := hosts.New(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Dispatcher{Hosts: h}
+	q := new(dns.Msg)
+	q.SetQuestion("nas.lan.", dns.TypeA)
+
+	resp, info := d.Explain(q, "127.0.0.1")
+	if resp == nil || len(resp.Answer) != 1 || info.Reason != ReasonLocal {
+		t.Fatalf("unexpected result %v, reason %s", resp, info.Reason)
+	}
+	if len(info.Trace) == 0 || info.Trace[len(info.Trace)-1].Stage != "done" {
+		t.Errorf("trace is not finished: %v", info.Trace)
+	}
+
+	var buf bytes.Buffer
+	WriteExplain(&buf, q, resp, info)
+	for _, want := range []string{";; explain nas.lan. A", "answered by hosts", ";; reason: local", "10.0.0.1"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("explain output has no %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestDispatcher_ExplainKeepsCache(t *testing.T) {
+	u, stop := newTestUpstream(t, "60 IN A 93.184.216.34", nil)
+	defer stop()
+	d := &Dispatcher{
+		DefaultDNSBundle: "remote",
+		DNSBunch:         map[string][]*common.DNSUpstream{"remote": {u}},
+		DNSFilter:        map[string]*common.Filter{"remote": {}},
+		Cache:            cache.New(16),
+	}
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	resp, info := d.Explain(q, "127.0.0.1")
+	if resp == nil || len(resp.Answer) != 1 || info.Reason != ReasonDefault {
+		t.Fatalf("unexpected result %v, reason %s", resp, info.Reason)
+	}
+	if d.Cache.Size() != 0 {
+		t.Errorf("explain should not change cache, %d entries are cached", d.Cache.Size())
+	}
+
+	if resp := d.Exchange(q, "127.0.0.1"); resp == nil || d.Cache.Size() != 1 {
+		t.Errorf("answer of Exchange should be cached, %d entries are cached", d.Cache.Size())
+	}
+}
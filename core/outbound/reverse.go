@@ -0,0 +1,75 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package outbound
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/import-yuefeng/smartDNS/core/common"
+)
+
+// privateReverseTTL is TTL and negative TTL of the empty zones recommended by RFC 6303
+const privateReverseTTL = 10800
+
+// exchangePrivateReverse func keep queries of private reverse zones away from public upstreams,
+// they are sent to PrivateReverseBundle or answered by an empty zone. nil is returned for other names
+func (d *Dispatcher) exchangePrivateReverse(query *dns.Msg, bundle *Bundle, info *QueryInfo) *dns.Msg {
+	q := query.Question[0]
+	apex, ok := common.PrivateReverseZone(q.Name)
+	if !ok {
+		return nil
+	}
+
+	info.Reason = ReasonPrivateReverse
+	info.trace("private-reverse", "%s is a private reverse zone", apex)
+	if d.PrivateReverseBundle != "" {
+		if ActiveClientBundle, ok := bundle.ClientBundle[d.PrivateReverseBundle]; ok {
+			log.Debugf("Private reverse zone %s, finally use %s DNS", apex, d.PrivateReverseBundle)
+			if result := ActiveClientBundle.Exchange(true); result != nil {
+				result.BundleName = ActiveClientBundle.Name
+				info.setResult(ReasonPrivateReverse, result)
+				info.traceUpstreams(result)
+				d.cacheResult(info, result, bundle.ClientBundle)
+				return result.ResponseMessage
+			}
+			resp := new(dns.Msg)
+			resp.SetRcode(query, dns.RcodeServerFailure)
+			return resp
+		}
+	}
+
+	// empty zone: SOA at apex, NXDOMAIN below it
+	soa := &dns.SOA{
+		Hdr:     dns.RR_Header{Name: apex, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: privateReverseTTL},
+		Ns:      apex,
+		Mbox:    "nobody.invalid.",
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   1200,
+		Expire:  604800,
+		Minttl:  privateReverseTTL,
+	}
+	resp := new(dns.Msg)
+	resp.SetReply(query)
+	resp.Authoritative = true
+	resp.RecursionAvailable = true
+	switch {
+	case !strings.EqualFold(dns.Fqdn(q.Name), apex):
+		resp.Rcode = dns.RcodeNameError
+		resp.Ns = []dns.RR{soa}
+	case q.Qtype == dns.TypeSOA:
+		resp.Answer = []dns.RR{soa}
+	default:
+		resp.Ns = []dns.RR{soa}
+	}
+	log.Debugf("Private reverse zone %s is answered locally: %s", apex, dns.RcodeToString[resp.Rcode])
+	info.trace("private-reverse", "answered by empty zone")
+	return resp
+}
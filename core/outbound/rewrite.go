@@ -0,0 +1,145 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package outbound
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/import-yuefeng/smartDNS/core/common"
+	"github.com/import-yuefeng/smartDNS/core/rule"
+)
+
+// rewriteTTL is TTL of answers made by rewrite rules, MinimumTTL and domain TTL rules are applied after it
+const rewriteTTL = 300
+
+// maxCNAMEDepth limits chained CNAME rewrites, so that a rewrite loop is answered with SERVFAIL
+const maxCNAMEDepth = 8
+
+// exchangeByRewrite func answer query by address or CNAME rewrite, or exchange it with the bundle of bundle rewrite.
+// nil is returned if no rule matches
+func (d *Dispatcher) exchangeByRewrite(query *dns.Msg, inboundIP string, depth int, bundle *Bundle, info *QueryInfo) *dns.Msg {
+	if d.Rewrite == nil {
+		info.trace("rewrite", "no rewrite rules")
+		return nil
+	}
+	q := query.Question[0]
+	name := rule.NormalizeName(q.Name)
+
+	if q.Qtype == dns.TypeA || q.Qtype == dns.TypeAAAA {
+		if v := d.Rewrite.GetAddress(name); v != "" {
+			log.WithFields(log.Fields{"question": name, "address": v}).Debug("Matched address rewrite")
+			info.Reason = ReasonRewriteAddress
+			info.Blocked = isUnspecifiedAddress(v)
+			info.trace("rewrite", "address rule: %s", v)
+			return d.rewriteAddress(query, v)
+		}
+	}
+
+	if target := d.Rewrite.GetCNAME(name); target != "" {
+		log.WithFields(log.Fields{"question": name, "target": target}).Debug("Matched CNAME rewrite")
+		info.trace("rewrite", "CNAME rule: %s", target)
+		return d.rewriteCNAME(query, inboundIP, depth, target, info)
+	}
+
+	if bundleName := d.Rewrite.GetBundle(name); bundleName != "" {
+		ActiveClientBundle, ok := bundle.ClientBundle[bundleName]
+		if !ok {
+			info.trace("rewrite", "bundle rule: %s does not exist", bundleName)
+			return nil
+		}
+		info.trace("rewrite", "bundle rule: %s", bundleName)
+		log.Debugf("Matched bundle rewrite, finally use %s DNS", bundleName)
+		if result := ActiveClientBundle.Exchange(true); result != nil {
+			result.BundleName = ActiveClientBundle.Name
+			info.setResult(ReasonRewriteBundle, result)
+			info.traceUpstreams(result)
+			info.Blocked = d.Rebinding.Check(result.ResponseMessage)
+			d.cacheResult(info, result, bundle.ClientBundle)
+			return result.ResponseMessage
+		}
+	}
+	info.trace("rewrite", "no rule matched")
+	return nil
+}
+
+// rewriteAddress func answer A/AAAA query with comma separated ips, the other family gets an empty answer
+func (d *Dispatcher) rewriteAddress(query *dns.Msg, ips string) *dns.Msg {
+	q := query.Question[0]
+	resp := new(dns.Msg)
+	resp.SetReply(query)
+	resp.RecursionAvailable = true
+
+	for _, s := range strings.Split(ips, ",") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			continue
+		}
+		hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: rewriteTTL}
+		switch {
+		case q.Qtype == dns.TypeA && ip.To4() != nil:
+			resp.Answer = append(resp.Answer, &dns.A{Hdr: hdr, A: ip.To4()})
+		case q.Qtype == dns.TypeAAAA && ip.To4() == nil:
+			resp.Answer = append(resp.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+	common.SetMinimumTTL(resp, uint32(d.MinimumTTL))
+	common.SetTTLByRules(resp, d.DomainTTLRules)
+	return resp
+}
+
+// rewriteCNAME func answer query with CNAME to target, followed by the answers of target
+func (d *Dispatcher) rewriteCNAME(query *dns.Msg, inboundIP string, depth int, target string, info *QueryInfo) *dns.Msg {
+	q := query.Question[0]
+	resp := new(dns.Msg)
+	resp.SetReply(query)
+	resp.RecursionAvailable = true
+
+	if depth >= maxCNAMEDepth {
+		log.Warnf("CNAME rewrite of %s is nested too deep", q.Name)
+		resp.Rcode = dns.RcodeServerFailure
+		return resp
+	}
+
+	cname := &dns.CNAME{
+		Hdr:    dns.RR_Header{Name: q.Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: rewriteTTL},
+		Target: dns.Fqdn(target),
+	}
+	resp.Answer = []dns.RR{cname}
+	common.SetMinimumTTL(resp, uint32(d.MinimumTTL))
+	common.SetTTLByRules(resp, d.DomainTTLRules)
+	if q.Qtype == dns.TypeCNAME {
+		return resp
+	}
+
+	targetQuery := query.Copy()
+	targetQuery.Question[0].Name = cname.Target
+	// reason is kept as CNAME rewrite, bundle and upstream are the ones that answered target
+	defer func() { info.Reason = ReasonRewriteCNAME }()
+	if targetResp := d.exchange(targetQuery, inboundIP, depth+1, info); targetResp != nil {
+		// answers of target may be shared with cache, they are appended without modification
+		resp.Answer = append(resp.Answer, targetResp.Answer...)
+		resp.Rcode = targetResp.Rcode
+		if resp.Rcode == dns.RcodeServerFailure {
+			resp.Answer = nil
+		}
+	}
+	return resp
+}
+
+// isUnspecifiedAddress func return true if comma separated ips are all 0.0.0.0 or ::, such rules block domains
+func isUnspecifiedAddress(ips string) bool {
+	for _, s := range strings.Split(ips, ",") {
+		if ip := net.ParseIP(strings.TrimSpace(s)); ip == nil || !ip.IsUnspecified() {
+			return false
+		}
+	}
+	return true
+}
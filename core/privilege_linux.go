@@ -0,0 +1,69 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build linux
+// +build linux
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/import-yuefeng/smartDNS/core/config"
+)
+
+// dropPrivileges func switch to user and group after listeners are bound, nothing is done if User is empty
+// Group without User is refused by config.LoadConfig, so a successful call never leaves the process as root.
+func dropPrivileges(conf *config.Config) error {
+	if conf.User == "" {
+		return nil
+	}
+	if os.Geteuid() != 0 {
+		log.Warnf("smartDNS is not started as root, User and Group are ignored")
+		return nil
+	}
+	if !setuidSupported(runtime.Version()) {
+		return fmt.Errorf("User requires smartDNS built with Go 1.16 or later, it is built with %s", runtime.Version())
+	}
+	uid, gid, err := conf.RunAs()
+	if err != nil {
+		return err
+	}
+
+	// supplementary groups of root are dropped first, they would be kept by setuid
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("Failed to set groups: %s", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("Failed to set group %d: %s", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("Failed to set user %d: %s", uid, err)
+	}
+	log.Infof("Privileges have been dropped to uid %d, gid %d", os.Getuid(), os.Getgid())
+	return nil
+}
+
+// setuidSupported func report whether Setuid of the Go version applies to all threads, which is true since Go 1.16
+// Development versions are assumed to be recent.
+func setuidSupported(version string) bool {
+	if !strings.HasPrefix(version, "go1.") {
+		return true
+	}
+	minor := strings.TrimPrefix(version, "go1.")
+	if i := strings.IndexFunc(minor, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		minor = minor[:i]
+	}
+	n, err := strconv.Atoi(minor)
+	return err != nil || n >= 16
+}
@@ -0,0 +1,100 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build linux
+// +build linux
+
+package core
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestSetuidSupported(t *testing.T) {
+	for version, want := range map[string]bool{
+		"go1.12.9":             false,
+		"go1.15":               false,
+		"go1.16":               true,
+		"go1.16beta1":          true,
+		"go1.21.3":             true,
+		"devel go1.22-abcdef0": true,
+	} {
+		if got := setuidSupported(version); got != want {
+			t.Errorf("setuidSupported(%q) = %v, want %v", version, got, want)
+		}
+	}
+}
+
+// TestServer_DropPrivileges runs the server in a child process, privileges can not be regained by the test
+func TestServer_DropPrivileges(t *testing.T) {
+	if path := os.Getenv("SMARTDNS_TEST_DROP_CONFIG"); path != "" {
+		s := NewServer(path, false)
+		if err := s.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if os.Getuid() == 0 {
+			t.Error("still running as root")
+		}
+		if err := s.Reload(); err != nil {
+			t.Error(err)
+		}
+		s.Shutdown(context.Background())
+		return
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("dropping privileges needs root")
+	}
+	u, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("user nobody does not exist")
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+
+	dir, err := ioutil.TempDir("", "smartdns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Chmod(dir, 0755)
+	logDir := filepath.Join(dir, "log")
+	os.Mkdir(logDir, 0755)
+	os.Chown(logDir, uid, -1)
+	path := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(path, []byte(`{
+  "BindAddress": "127.0.0.1:0",
+  "User": "nobody",
+  "QueryLog": {"File": "`+filepath.Join(logDir, "query.log")+`", "MaxSize": 1},
+  "Dnstap": {"File": "`+filepath.Join(logDir, "dnstap.fstrm")+`"},
+  "DNSBunch": {"A": [{"Name": "a1", "Address": "127.0.0.1:53", "Protocol": "udp", "Timeout": 3}]},
+  "DNSFilter": {"A": {"Matcher": "suffix-tree"}}
+}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestServer_DropPrivileges$")
+	cmd.Env = append(os.Environ(), "SMARTDNS_TEST_DROP_CONFIG="+path)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+	for _, name := range []string{"query.log", "dnstap.fstrm"} {
+		info, err := os.Stat(filepath.Join(logDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if owner := int(info.Sys().(*syscall.Stat_t).Uid); owner != uid {
+			t.Errorf("%s is owned by uid %d, want %d", name, owner, uid)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package querylog writes one JSON line per query to a file with rotation.
+package querylog
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Modes of ClientIP
+const (
+	ClientIPFull     = "full"
+	ClientIPTruncate = "truncate"
+	ClientIPHash     = "hash"
+	ClientIPNone     = "none"
+)
+
+// queueSize is count of entries waiting to be written, queries never wait for the log file
+const queueSize = 4096
+
+// Config is QueryLog in config file
+type Config struct {
+	File string
+	// MaxSize is size in MB that rotates the file, 0 disables size based rotation
+	MaxSize int
+	// RotateInterval rotates the file periodically, e.g. "24h", empty disables time based rotation
+	RotateInterval string
+	// MaxBackups is count of rotated files to keep, 0 keeps all
+	MaxBackups int
+	// Compress gzips rotated files
+	Compress bool
+	// ClientIP is "full" (default), "truncate" (/24 and /48), "hash" (HMAC with HashKey) or "none"
+	ClientIP string
+	// HashKey is the key of hashed client IP, a random key is used if it is empty, so hashes change on restart
+	HashKey string
+}
+
+// Entry is one line of query log
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client,omitempty"`
+	Group     string    `json:"group,omitempty"`
+	Name      string    `json:"qname"`
+	Type      string    `json:"qtype"`
+	Bundle    string    `json:"bundle,omitempty"`
+	Upstream  string    `json:"upstream,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Rcode     string    `json:"rcode"`
+	Answers   []string  `json:"answers,omitempty"`
+	Latency   float64   `json:"latency_ms"`
+	Cache     string    `json:"cache,omitempty"`
+	Coalesced bool      `json:"coalesced,omitempty"`
+}
+
+// Logger writes entries in background
+type Logger struct {
+	// dropped is first for 64-bit alignment of atomic operations
+	dropped uint64
+	config  Config
+	file    *rotateFile
+	hashKey []byte
+
+	// lock guards entries against Log after Close, queries in flight may still log after reload
+	lock    sync.RWMutex
+	closed  bool
+	entries chan *Entry
+	done    chan struct{}
+}
+
+// New func open query log file, nil is returned if c is nil or File is empty
+func New(c *Config) (*Logger, error) {
+	if c == nil || c.File == "" {
+		return nil, nil
+	}
+	if err := c.Check(); err != nil {
+		return nil, err
+	}
+
+	var interval time.Duration
+	if c.RotateInterval != "" {
+		interval, _ = time.ParseDuration(c.RotateInterval)
+	}
+	f, err := openRotateFile(c.File, int64(c.MaxSize)*1024*1024, interval, c.MaxBackups, c.Compress)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Logger{config: *c, file: f, entries: make(chan *Entry, queueSize), done: make(chan struct{})}
+	if c.ClientIP == ClientIPHash {
+		if c.HashKey != "" {
+			l.hashKey = []byte(c.HashKey)
+		} else {
+			l.hashKey = make([]byte, 32)
+			rand.Read(l.hashKey)
+		}
+	}
+	go l.run()
+	log.Infof("Query log is written to %s", c.File)
+	return l, nil
+}
+
+// Check func validate config, nil config is valid
+func (c *Config) Check() error {
+	if c == nil {
+		return nil
+	}
+	switch c.ClientIP {
+	case "", ClientIPFull, ClientIPTruncate, ClientIPHash, ClientIPNone:
+	default:
+		return fmt.Errorf("Invalid QueryLog ClientIP: %s", c.ClientIP)
+	}
+	if c.RotateInterval != "" {
+		if d, err := time.ParseDuration(c.RotateInterval); err != nil || d <= 0 {
+			return fmt.Errorf("Invalid QueryLog RotateInterval: %s", c.RotateInterval)
+		}
+	}
+	if c.MaxSize < 0 || c.MaxBackups < 0 {
+		return fmt.Errorf("QueryLog MaxSize and MaxBackups must not be negative")
+	}
+	return nil
+}
+
+// Log func queue e, e is dropped if the queue is full. Client of e is replaced according to ClientIP
+func (l *Logger) Log(e *Entry) {
+	if l == nil {
+		return
+	}
+	e.Client = l.client(e.Client)
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.closed {
+		return
+	}
+	select {
+	case l.entries <- e:
+	default:
+		if atomic.AddUint64(&l.dropped, 1)%1000 == 1 {
+			log.Warnf("Query log is too slow, %d entries have been dropped", atomic.LoadUint64(&l.dropped))
+		}
+	}
+}
+
+// Close func write queued entries and close file
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.lock.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.entries)
+	}
+	l.lock.Unlock()
+	<-l.done
+	return l.file.Close()
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+	for e := range l.entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		if _, err := l.file.Write(append(b, '\n')); err != nil {
+			log.Warnf("Failed to write query log: %s", err)
+		}
+	}
+}
+
+// client func apply privacy mode to client IP
+func (l *Logger) client(s string) string {
+	switch l.config.ClientIP {
+	case ClientIPNone:
+		return ""
+	case ClientIPTruncate:
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return s
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4.Mask(net.CIDRMask(24, 32)).String()
+		}
+		return ip.Mask(net.CIDRMask(48, 128)).String()
+	case ClientIPHash:
+		mac := hmac.New(sha256.New, l.hashKey)
+		mac.Write([]byte(s))
+		return hex.EncodeToString(mac.Sum(nil))[:16]
+	}
+	return s
+}
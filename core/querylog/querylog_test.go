@@ -0,0 +1,151 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package querylog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogger_Client(t *testing.T) {
+	cases := []struct {
+		mode, ip, want string
+	}{
+		{"", "192.168.1.23", "192.168.1.23"},
+		{ClientIPTruncate, "192.168.1.23", "192.168.1.0"},
+		{ClientIPTruncate, "2001:db8:1:2::5", "2001:db8:1::"},
+		{ClientIPNone, "192.168.1.23", ""},
+	}
+	for _, c := range cases {
+		l := &Logger{config: Config{ClientIP: c.mode}}
+		if got := l.client(c.ip); got != c.want {
+			t.Errorf("%s %s: got %s, want %s", c.mode, c.ip, got, c.want)
+		}
+	}
+
+	l := &Logger{config: Config{ClientIP: ClientIPHash}, hashKey: []byte("key")}
+	a, b := l.client("192.168.1.23"), l.client("192.168.1.24")
+	if len(a) != 16 || a == b || a != l.client("192.168.1.23") || strings.Contains(a, "192") {
+		t.Errorf("hash: %s %s", a, b)
+	}
+}
+
+func TestConfig_Check(t *testing.T) {
+	for _, c := range []*Config{
+		{File: "q.log", ClientIP: "mask"},
+		{File: "q.log", RotateInterval: "daily"},
+		{File: "q.log", MaxSize: -1},
+	} {
+		if c.Check() == nil {
+			t.Errorf("%+v should be invalid", c)
+		}
+	}
+	if (*Config)(nil).Check() != nil {
+		t.Error("nil config should be valid")
+	}
+}
+
+func TestLogger_Rotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "querylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "query.log")
+
+	l, err := New(&Config{File: path, MaxBackups: 2, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rotate every 200 bytes
+	l.file.maxSize = 200
+	for i := 0; i < 20; i++ {
+		l.Log(&Entry{Time: time.Now(), Client: "10.0.0.1", Name: "example.com.", Type: "A", Rcode: "NOERROR", Answers: []string{"A 1.2.3.4"}})
+		// backup names have millisecond precision
+		time.Sleep(2 * time.Millisecond)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l.Log(&Entry{Name: "after.close."})
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Name != "example.com." || e.Client != "10.0.0.1" {
+			t.Errorf("invalid line %s: %v", scanner.Text(), err)
+		}
+	}
+
+	// compression and cleanup run in background
+	var backups []string
+	for i := 0; i < 50; i++ {
+		backups, _ = filepath.Glob(path + ".*")
+		if len(backups) == 2 && strings.HasSuffix(backups[0], ".gz") && strings.HasSuffix(backups[1], ".gz") {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("backups: %v", backups)
+	}
+	gf, err := os.Open(backups[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gf.Close()
+	gz, err := gzip.NewReader(gf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(gz); err != nil || !strings.Contains(string(b), "example.com.") {
+		t.Errorf("backup content %q: %v", b, err)
+	}
+}
+
+func TestRotateFile_Reopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "querylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "query.log")
+
+	f, err := openRotateFile(path, 0, 0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as left by rotation that failed to open the new file
+	f.file.Close()
+	f.file = nil
+	os.Remove(path)
+	os.Mkdir(path, 0755)
+	if _, err := f.Write([]byte("lost\n")); err == nil {
+		t.Error("write should fail while the file cannot be opened")
+	}
+
+	os.Remove(path)
+	if _, err := f.Write([]byte("kept\n")); err != nil {
+		t.Fatalf("file is not reopened: %s", err)
+	}
+	f.Close()
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != "kept\n" {
+		t.Errorf("content %q: %v", b, err)
+	}
+}
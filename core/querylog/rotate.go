@@ -0,0 +1,172 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package querylog
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// backupTimeFormat is suffix of rotated files, e.g. query.log.20190901-150405.000
+const backupTimeFormat = "20060102-150405.000"
+
+// rotateFile is a file rotated by size and age, it is only used by one goroutine
+type rotateFile struct {
+	path       string
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+	compress   bool
+
+	// file is nil if reopening failed after rotation, the next write tries again
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func openRotateFile(path string, maxSize int64, interval time.Duration, maxBackups int, compress bool) (*rotateFile, error) {
+	f := &rotateFile{path: path, maxSize: maxSize, interval: interval, maxBackups: maxBackups, compress: compress}
+	if maxSize > 0 || interval > 0 {
+		// rotation creates files next to path, a directory not writable is found now instead of at the first rotation
+		tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+		if err != nil {
+			return nil, fmt.Errorf("rotating %s needs a writable directory: %s", path, err)
+		}
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotateFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+func (f *rotateFile) Write(b []byte) (int, error) {
+	if f.file != nil && f.size > 0 && ((f.maxSize > 0 && f.size+int64(len(b)) > f.maxSize) || (f.interval > 0 && time.Since(f.opened) >= f.interval)) {
+		if err := f.rotate(); err != nil {
+			log.Warnf("Failed to rotate query log %s: %s", f.path, err)
+		}
+	}
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotateFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+// rotate func rename current file with time suffix and open a new one, the old file is compressed in background
+func (f *rotateFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return err
+	}
+	backup := f.path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(f.path, backup); err != nil {
+		f.open()
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	go func(backup string) {
+		if f.compress {
+			if err := compressFile(backup); err != nil {
+				log.Warnf("Failed to compress query log %s: %s", backup, err)
+			}
+		}
+		f.removeOldBackups()
+	}(backup)
+	return nil
+}
+
+// removeOldBackups func keep the newest maxBackups rotated files
+func (f *rotateFile) removeOldBackups() {
+	if f.maxBackups <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return
+	}
+	var backups []string
+	for _, m := range matches {
+		// files being compressed are not counted
+		if !strings.HasSuffix(m, ".tmp") {
+			backups = append(backups, m)
+		}
+	}
+	// time suffix sorts by age
+	sort.Strings(backups)
+	for i := 0; i < len(backups)-f.maxBackups; i++ {
+		os.Remove(backups[i])
+	}
+}
+
+// compressFile func replace path with path.gz
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := path + ".gz.tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if cerr := gz.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,204 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/import-yuefeng/smartDNS/core/config"
+	"github.com/import-yuefeng/smartDNS/core/cron"
+	"github.com/import-yuefeng/smartDNS/core/dnstap"
+	"github.com/import-yuefeng/smartDNS/core/querylog"
+	"github.com/import-yuefeng/smartDNS/core/systemd"
+)
+
+// reloadDelay merges the burst of events produced by editors saving a file
+const reloadDelay = time.Second
+
+// Reload func load config again, the running config is kept if new config is invalid
+// Reloads run one at a time, Server is only locked to swap the new config in, so loading
+// rule lists does not block admin requests or Shutdown.
+func (s *Server) Reload() error {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+	s.Lock()
+	running, old := s.inbound != nil, s.conf
+	s.Unlock()
+	if !running {
+		return errors.New("server is not running")
+	}
+	notify(systemd.Reloading)
+	defer notify(systemd.Ready)
+
+	log.Infof("Reloading config file %s", s.configPath)
+	conf, err := config.LoadConfig(s.configPath)
+	if err != nil {
+		log.Errorf("Reload failed, keep running with old config: %s", err)
+		return err
+	}
+	// cache is preserved across reloads
+	conf.Cache = old.Cache
+
+	// dnstap stream and query log file are reopened only if their config is changed
+	var tap *dnstap.Tap
+	var queryLog *querylog.Logger
+	tapChanged := !reflect.DeepEqual(old.Dnstap, conf.Dnstap)
+	queryLogChanged := !reflect.DeepEqual(old.QueryLog, conf.QueryLog)
+	if tapChanged {
+		if tap, err = dnstap.New(conf.Dnstap); err != nil {
+			log.Errorf("Reload failed, keep running with old config: failed to start dnstap: %s", err)
+			return err
+		}
+	}
+	if queryLogChanged {
+		if queryLog, err = querylog.New(conf.QueryLog); err != nil {
+			tap.Close()
+			log.Errorf("Reload failed, keep running with old config: failed to open query log: %s", err)
+			return err
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if s.inbound == nil {
+		tap.Close()
+		queryLog.Close()
+		return errors.New("server is shut down while reloading")
+	}
+	changes := config.Diff(old, conf)
+	if len(changes) == 0 {
+		log.Info("Config is not changed, rule files have been reloaded")
+	}
+	for _, c := range changes {
+		log.Infof("Config changed: %s", c)
+	}
+
+	if queryLogChanged {
+		s.inbound.SetQueryLog(queryLog).Close()
+		s.queryLog = queryLog
+	}
+	oldTap := (*dnstap.Tap)(nil)
+	if tapChanged {
+		oldTap, s.tap = s.tap, tap
+	}
+	s.inbound.SetDispatcher(s.dispatcherOf(conf, s.tap), conf.RejectQType)
+	// queries in flight on the old dispatcher drop their messages
+	oldTap.Close()
+	s.conf = conf
+	s.startRuleUpdater()
+	// reload by signal and admin API change watched files too
+	select {
+	case s.rewatch <- struct{}{}:
+	default:
+	}
+	log.Info("Reload finished")
+	return nil
+}
+
+// startRuleUpdater func replace the refresh schedule of subscribed rule lists with the one of current config
+func (s *Server) startRuleUpdater() {
+	if s.ruleUpdater != nil {
+		s.ruleUpdater.Stop()
+		s.ruleUpdater = nil
+	}
+	if !s.conf.HasRuleSubscription() {
+		return
+	}
+	updater := &cron.RuleUpdater{Interval: s.conf.RuleRefreshCrontab, Refresh: s.conf.RefreshRules}
+	if err := updater.Crontab(); err != nil {
+		log.Errorf("Invalid RuleRefreshCrontab %s: %s", s.conf.RuleRefreshCrontab, err)
+		return
+	}
+	s.ruleUpdater = updater
+}
+
+// watchSignal func reload when SIGHUP is received
+func (s *Server) watchSignal(signals <-chan os.Signal) {
+	for range signals {
+		log.Info("SIGHUP received")
+		s.Reload()
+	}
+}
+
+// watchFiles func reload when config file or rule files are changed
+// Directories are watched instead of files, so files replaced by rename are still noticed.
+func (s *Server) watchFiles() {
+	watcher, rewatch := s.watcher, s.rewatch
+	dirs := make(map[string]struct{})
+	files := s.updateWatch(watcher, dirs)
+
+	var delay <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if _, hit := files[filepath.Clean(event.Name)]; !hit {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+				continue
+			}
+			log.Debugf("File %s changed: %s", event.Name, event.Op)
+			delay = time.After(reloadDelay)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warnf("File watcher error: %s", err)
+		case <-delay:
+			delay = nil
+			s.Reload()
+		case <-rewatch:
+			files = s.updateWatch(watcher, dirs)
+		}
+	}
+}
+
+// updateWatch func watch directories of files current config depends on, and return these files
+func (s *Server) updateWatch(watcher *fsnotify.Watcher, dirs map[string]struct{}) map[string]struct{} {
+	s.Lock()
+	list := s.conf.WatchFiles(s.configPath)
+	s.Unlock()
+
+	files := make(map[string]struct{})
+	wanted := make(map[string]struct{})
+	for _, f := range list {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			continue
+		}
+		files[abs] = struct{}{}
+		wanted[filepath.Dir(abs)] = struct{}{}
+	}
+
+	for d := range wanted {
+		if _, ok := dirs[d]; ok {
+			continue
+		}
+		if err := watcher.Add(d); err != nil {
+			log.Warnf("Failed to watch directory %s: %s", d, err)
+			continue
+		}
+		dirs[d] = struct{}{}
+	}
+	for d := range dirs {
+		if _, ok := wanted[d]; !ok {
+			watcher.Remove(d)
+			delete(dirs, d)
+		}
+	}
+	return files
+}
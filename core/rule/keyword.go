@@ -0,0 +1,146 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rule
+
+import (
+	"errors"
+	"sync"
+)
+
+// acNode is a state of Aho-Corasick automaton
+type acNode struct {
+	next map[byte]int32
+	fail int32
+	// output is true if a keyword ends at this state or any state in its fail chain
+	output bool
+}
+
+// Keywords matches names containing any keyword, all keywords are searched in one pass (Aho-Corasick)
+type Keywords struct {
+	sync.RWMutex
+
+	keywords []string
+	nodes    []acNode
+	dirty    bool
+}
+
+// NewKeywords func create new empty Keywords
+func NewKeywords() *Keywords {
+	return new(Keywords)
+}
+
+// Add func append keyword
+func (k *Keywords) Add(keyword string) error {
+	if keyword == "" {
+		return errors.New("empty keyword")
+	}
+	k.Lock()
+	k.keywords = append(k.keywords, keyword)
+	k.dirty = true
+	k.Unlock()
+	return nil
+}
+
+// Len func return count of keywords
+func (k *Keywords) Len() int {
+	k.RLock()
+	defer k.RUnlock()
+	return len(k.keywords)
+}
+
+// Match func return true if s contains any keyword
+func (k *Keywords) Match(s string) bool {
+	nodes := k.automaton()
+	if len(nodes) == 0 {
+		return false
+	}
+
+	var state int32
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		for {
+			if next, ok := nodes[state].next[c]; ok {
+				state = next
+				break
+			}
+			if state == 0 {
+				break
+			}
+			state = nodes[state].fail
+		}
+		if nodes[state].output {
+			return true
+		}
+	}
+	return false
+}
+
+// automaton func return states of automaton, it is rebuilt after keywords are added
+func (k *Keywords) automaton() []acNode {
+	k.RLock()
+	if !k.dirty {
+		defer k.RUnlock()
+		return k.nodes
+	}
+	k.RUnlock()
+
+	k.Lock()
+	defer k.Unlock()
+	if k.dirty {
+		k.nodes = build(k.keywords)
+		k.dirty = false
+	}
+	return k.nodes
+}
+
+func build(keywords []string) []acNode {
+	nodes := []acNode{{next: make(map[byte]int32)}}
+
+	// trie of keywords
+	for _, kw := range keywords {
+		var state int32
+		for i := 0; i < len(kw); i++ {
+			next, ok := nodes[state].next[kw[i]]
+			if !ok {
+				nodes = append(nodes, acNode{next: make(map[byte]int32)})
+				next = int32(len(nodes) - 1)
+				nodes[state].next[kw[i]] = next
+			}
+			state = next
+		}
+		nodes[state].output = true
+	}
+
+	// fail links by breadth-first order
+	queue := make([]int32, 0, len(nodes))
+	for _, child := range nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for c, child := range nodes[state].next {
+			fail := nodes[state].fail
+			for {
+				if next, ok := nodes[fail].next[c]; ok && next != child {
+					nodes[child].fail = next
+					break
+				}
+				if fail == 0 {
+					nodes[child].fail = 0
+					break
+				}
+				fail = nodes[fail].fail
+			}
+			if nodes[nodes[child].fail].output {
+				nodes[child].output = true
+			}
+			queue = append(queue, child)
+		}
+	}
+	return nodes
+}
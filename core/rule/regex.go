@@ -0,0 +1,112 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package rule implements precompiled domain rules: regex sets and keyword automaton.
+package rule
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RegexSet holds patterns compiled at insertion, they are also combined into one automaton
+// so that a name matching none of them is rejected in a single pass.
+type RegexSet struct {
+	sync.RWMutex
+
+	patterns []*regexp.Regexp
+	combined *regexp.Regexp
+	dirty    bool
+}
+
+// NewRegexSet func create new empty RegexSet
+func NewRegexSet() *RegexSet {
+	return new(RegexSet)
+}
+
+// Add func compile pattern and append it to set, invalid pattern is returned as error
+// Patterns are case-insensitive, as names are matched in lowercase.
+func (r *RegexSet) Add(pattern string) error {
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return err
+	}
+	r.Lock()
+	r.patterns = append(r.patterns, re)
+	r.dirty = true
+	r.Unlock()
+	return nil
+}
+
+// Len func return count of patterns
+func (r *RegexSet) Len() int {
+	r.RLock()
+	defer r.RUnlock()
+	return len(r.patterns)
+}
+
+// Match func return true if s matches any pattern
+func (r *RegexSet) Match(s string) bool {
+	return r.Find(s) >= 0
+}
+
+// Find func return index of the first pattern (in insertion order) that s matches, or -1
+func (r *RegexSet) Find(s string) int {
+	combined, patterns := r.compiled()
+	if combined != nil && !combined.MatchString(s) {
+		return -1
+	}
+	for i, re := range patterns {
+		if re.MatchString(s) {
+			return i
+		}
+	}
+	return -1
+}
+
+// compiled func return the combined automaton, it is rebuilt after patterns are added
+func (r *RegexSet) compiled() (*regexp.Regexp, []*regexp.Regexp) {
+	r.RLock()
+	if !r.dirty {
+		defer r.RUnlock()
+		return r.combined, r.patterns
+	}
+	r.RUnlock()
+
+	r.Lock()
+	defer r.Unlock()
+	if r.dirty {
+		r.combined = combine(r.patterns)
+		r.dirty = false
+	}
+	return r.combined, r.patterns
+}
+
+// combine func join patterns by alternation, nil is returned if it is unnecessary or fails
+func combine(patterns []*regexp.Regexp) *regexp.Regexp {
+	if len(patterns) < 2 {
+		return nil
+	}
+	var b strings.Builder
+	for i, re := range patterns {
+		if i > 0 {
+			b.WriteByte('|')
+		}
+		b.WriteString("(?:")
+		b.WriteString(re.String())
+		b.WriteByte(')')
+	}
+	combined, err := regexp.Compile(b.String())
+	if err != nil {
+		// patterns are still matched one by one
+		log.Warnf("Failed to combine %d regex rules: %s", len(patterns), err)
+		return nil
+	}
+	return combined
+}
@@ -0,0 +1,89 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rule
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeywords_Match(t *testing.T) {
+	k := NewKeywords()
+	for _, kw := range []string{"he", "she", "his", "hers", "google", "ads"} {
+		if err := k.Add(kw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := k.Add(""); err == nil {
+		t.Error("empty keyword should be rejected")
+	}
+
+	words := []string{"ushers", "this", "www.google.com", "badserver.net", "xyz.com", "hxs", "goog.le", "sh"}
+	for _, w := range words {
+		want := false
+		for _, kw := range []string{"he", "she", "his", "hers", "google", "ads"} {
+			want = want || strings.Contains(w, kw)
+		}
+		if got := k.Match(w); got != want {
+			t.Errorf("Match(%s) = %v, want %v", w, got, want)
+		}
+	}
+
+	// automaton is rebuilt after Add
+	k.Add("xyz")
+	if !k.Match("xyz.com") {
+		t.Error("Match(xyz.com) should be true after adding xyz")
+	}
+}
+
+func TestRegexSet_Find(t *testing.T) {
+	r := NewRegexSet()
+	for _, p := range []string{`^ads\.`, `example\.com$`, `\.cn$`, `^CDN[0-9]+\.`} {
+		if err := r.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Add(`(`); err == nil {
+		t.Error("invalid pattern should be rejected")
+	}
+
+	cases := map[string]int{
+		"ads.example.com": 0,
+		"www.example.com": 1,
+		"www.baidu.cn":    2,
+		"www.google.com":  -1,
+		"cdn1.google.com": 3,
+	}
+	for s, want := range cases {
+		if got := r.Find(s); got != want {
+			t.Errorf("Find(%s) = %d, want %d", s, got, want)
+		}
+	}
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	cases := map[string]string{
+		"Example.COM.":       "example.com",
+		"_dmarc.example.com": "_dmarc.example.com",
+		"中国.cn":              "xn--fiqs8s.cn",
+		"Bücher.example":     "xn--bcher-kva.example",
+		"XN--FIQS8S.CN":      "xn--fiqs8s.cn",
+	}
+	for in, want := range cases {
+		if got, err := NormalizeDomain(in); err != nil || got != want {
+			t.Errorf("NormalizeDomain(%s) = %s, %v, want %s", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", ".", "xn--zz.com", "ex\u00adample\u0378.com"} {
+		if got, err := NormalizeDomain(bad); err == nil {
+			t.Errorf("NormalizeDomain(%q) = %s, should fail", bad, got)
+		}
+	}
+	if got := NormalizeName("WwW.Example.Com."); got != "www.example.com" {
+		t.Errorf("NormalizeName = %s", got)
+	}
+}
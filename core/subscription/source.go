@@ -0,0 +1,188 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package subscription fetches remote rule lists over HTTP(S).
+package subscription
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxListSize limits the size of a downloaded rule list, it is a variable for tests
+var maxListSize int64 = 64 << 20
+
+// ErrNotModified is returned by Fetch when the remote list has not changed
+var ErrNotModified = errors.New("rule list not modified")
+
+// Source is a remote rule list, FallbackFile keeps the last verified copy on disk
+type Source struct {
+	sync.Mutex
+
+	URL          string
+	ChecksumURL  string
+	FallbackFile string
+
+	etag         string
+	lastModified string
+	client       *http.Client
+}
+
+// NewSource func create new Source struct object
+func NewSource(url string, checksumURL string, fallbackFile string) *Source {
+	return &Source{
+		URL:          url,
+		ChecksumURL:  checksumURL,
+		FallbackFile: fallbackFile,
+		client:       &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// Load func fetch the remote list, it will read FallbackFile when the remote list is unavailable
+func (s *Source) Load() ([]byte, error) {
+	body, err := s.Fetch()
+	if err == nil {
+		return body, nil
+	}
+	log.Warnf("Failed to fetch rule list %s: %s", s.URL, err)
+
+	if s.FallbackFile == "" {
+		return nil, err
+	}
+	body, ferr := ioutil.ReadFile(s.FallbackFile)
+	if ferr != nil {
+		return nil, fmt.Errorf("%s, and fallback file is unavailable: %s", err, ferr)
+	}
+	log.Infof("Use fallback copy %s of rule list %s", s.FallbackFile, s.URL)
+	return body, nil
+}
+
+// Fetch func download the list, it returns ErrNotModified if the list is unchanged since last fetch
+func (s *Source) Fetch() ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	req, err := http.NewRequest(http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	if s.lastModified != "" {
+		req.Header.Set("If-Modified-Since", s.lastModified)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, ErrNotModified
+	default:
+		return nil, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+
+	body, err := readLimited(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.verify(body); err != nil {
+		return nil, err
+	}
+	if err := s.saveFallback(body); err != nil {
+		// download is still usable, just lose the on-disk copy
+		log.Warnf("Failed to save fallback copy of %s: %s", s.URL, err)
+	}
+
+	s.etag = resp.Header.Get("ETag")
+	s.lastModified = resp.Header.Get("Last-Modified")
+	log.Infof("Rule list %s has been fetched (%d bytes)", s.URL, len(body))
+	return body, nil
+}
+
+// verify func compare sha256 of body with the checksum file, the first field of it is hex digest
+func (s *Source) verify(body []byte) error {
+	if s.ChecksumURL == "" {
+		return nil
+	}
+
+	resp, err := s.client.Get(s.ChecksumURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch checksum: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch checksum: unexpected HTTP status %s", resp.Status)
+	}
+	b, err := readLimited(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to fetch checksum: %s", err)
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return errors.New("checksum file is empty")
+	}
+
+	sum := sha256.Sum256(body)
+	if !strings.EqualFold(fields[0], hex.EncodeToString(sum[:])) {
+		return fmt.Errorf("checksum mismatch, expected %s, got %s", fields[0], hex.EncodeToString(sum[:]))
+	}
+	return nil
+}
+
+// saveFallback func replace FallbackFile atomically
+func (s *Source) saveFallback(body []byte) error {
+	if s.FallbackFile == "" {
+		return nil
+	}
+	if old, err := ioutil.ReadFile(s.FallbackFile); err == nil && bytes.Equal(old, body) {
+		return nil
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.FallbackFile), "."+filepath.Base(s.FallbackFile))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.FallbackFile)
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxListSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > maxListSize {
+		return nil, fmt.Errorf("rule list is larger than %d bytes", maxListSize)
+	}
+	return b, nil
+}
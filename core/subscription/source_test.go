@@ -0,0 +1,130 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package subscription
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testList = "example.com\nexample.org\n"
+
+// newTestServer serves testList at /list with an ETag, and checksum at /list.sha256
+func newTestServer(checksum string) (*httptest.Server, *int) {
+	requests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/list", func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(testList))
+	})
+	mux.HandleFunc("/list.sha256", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(checksum + "  list\n"))
+	})
+	return httptest.NewServer(mux), &requests
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "subscription")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestSource_Fetch(t *testing.T) {
+	ts, requests := newTestServer(strings.ToUpper(sha256Hex(testList)))
+	defer ts.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fallback := filepath.Join(dir, "list.txt")
+
+	s := NewSource(ts.URL+"/list", ts.URL+"/list.sha256", fallback)
+	body, err := s.Fetch()
+	if err != nil || string(body) != testList {
+		t.Fatalf("first fetch: %q %v", body, err)
+	}
+	if b, err := ioutil.ReadFile(fallback); err != nil || string(b) != testList {
+		t.Errorf("fallback file is not saved: %q %v", b, err)
+	}
+
+	if _, err := s.Fetch(); err != ErrNotModified || *requests != 2 {
+		t.Errorf("second fetch should send ETag and get 304: %v", err)
+	}
+}
+
+func TestSource_ChecksumMismatch(t *testing.T) {
+	ts, _ := newTestServer(sha256Hex("something else"))
+	defer ts.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fallback := filepath.Join(dir, "list.txt")
+
+	s := NewSource(ts.URL+"/list", ts.URL+"/list.sha256", fallback)
+	if _, err := s.Fetch(); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("fetch should fail: %v", err)
+	}
+	if _, err := os.Stat(fallback); !os.IsNotExist(err) {
+		t.Errorf("unverified list is saved: %v", err)
+	}
+	if s.etag != "" {
+		t.Error("ETag of unverified list is kept")
+	}
+}
+
+func TestSource_LoadFallback(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fallback := filepath.Join(dir, "list.txt")
+
+	s := NewSource(ts.URL, "", fallback)
+	if _, err := s.Load(); err == nil || !strings.Contains(err.Error(), "fallback file is unavailable") {
+		t.Errorf("load without fallback file: %v", err)
+	}
+
+	ioutil.WriteFile(fallback, []byte(testList), 0644)
+	if body, err := s.Load(); err != nil || string(body) != testList {
+		t.Errorf("load should read fallback file: %q %v", body, err)
+	}
+}
+
+func TestSource_SizeLimit(t *testing.T) {
+	defer func(n int64) { maxListSize = n }(maxListSize)
+	maxListSize = int64(len(testList) - 1)
+
+	ts, _ := newTestServer("")
+	defer ts.Close()
+	s := NewSource(ts.URL+"/list", "", "")
+	if _, err := s.Fetch(); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("fetch should fail: %v", err)
+	}
+
+	maxListSize = int64(len(testList))
+	if _, err := s.Fetch(); err != nil {
+		t.Errorf("list of maximal size: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package systemd
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation
+const listenFDsStart = 3
+
+// Socket is a socket passed by systemd, Name is FileDescriptorName of the socket unit
+type Socket struct {
+	Name string
+	File *os.File
+}
+
+// Sockets func return sockets passed by systemd socket activation (LISTEN_FDS), nil if there is none
+// LISTEN_ variables are removed, so the sockets are taken only once and not passed to child processes.
+func Sockets() []Socket {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	names := socketNames(os.Getpid(), os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"))
+	var sockets []Socket
+	for i, name := range names {
+		sockets = append(sockets, Socket{Name: name, File: os.NewFile(uintptr(listenFDsStart+i), name)})
+	}
+	return sockets
+}
+
+// socketNames func return names of passed sockets in the order of their file descriptors
+// Sockets without FileDescriptorName are named by their file descriptor, like LISTEN_FD_3.
+func socketNames(pid int, listenPID string, listenFDs string, names string) []string {
+	if listenPID != strconv.Itoa(pid) {
+		return nil
+	}
+	n, err := strconv.Atoi(listenFDs)
+	if err != nil || n <= 0 {
+		return nil
+	}
+	nameList := strings.Split(names, ":")
+
+	result := make([]string, n)
+	for i := range result {
+		result[i] = "LISTEN_FD_" + strconv.Itoa(listenFDsStart+i)
+		if i < len(nameList) && nameList[i] != "" {
+			result[i] = nameList[i]
+		}
+	}
+	return result
+}
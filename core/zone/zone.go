@@ -0,0 +1,251 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package zone implements local authoritative zones loaded from RFC 1035 zone files.
+package zone
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/import-yuefeng/smartDNS/core/matcher/iptrie"
+)
+
+// maxCNAMEChain limits CNAME chain followed inside a zone
+const maxCNAMEChain = 8
+
+// Zone is read only after it is loaded
+type Zone struct {
+	// Origin is lowercase FQDN of zone apex
+	Origin string
+
+	soa     *dns.SOA
+	records map[string][]dns.RR
+	// names holds owner names and empty non-terminals
+	names map[string]struct{}
+	// all keeps records in file order for zone transfer
+	all           []dns.RR
+	allowTransfer *iptrie.Trie
+}
+
+// Load func parse zone file of origin
+func Load(origin string, file string) (*Zone, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f, origin, file)
+}
+
+// Parse func parse zone from r, file is only used in error messages
+func Parse(r io.Reader, origin string, file string) (*Zone, error) {
+	origin = strings.ToLower(dns.Fqdn(origin))
+	z := &Zone{
+		Origin:  origin,
+		records: make(map[string][]dns.RR),
+		names:   make(map[string]struct{}),
+	}
+
+	zp := dns.NewZoneParser(r, origin, file)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		owner := strings.ToLower(rr.Header().Name)
+		if !dns.IsSubDomain(origin, owner) {
+			return nil, fmt.Errorf("%s: record %s is out of zone %s", file, rr.Header().Name, origin)
+		}
+		if soa, ok := rr.(*dns.SOA); ok {
+			if owner != origin {
+				return nil, fmt.Errorf("%s: SOA %s is not at zone apex", file, rr.Header().Name)
+			}
+			if z.soa != nil {
+				return nil, fmt.Errorf("%s: zone %s has more than one SOA", file, origin)
+			}
+			z.soa = soa
+		}
+		z.records[owner] = append(z.records[owner], rr)
+		z.all = append(z.all, rr)
+		for name := owner; ; name = parent(name) {
+			z.names[name] = struct{}{}
+			if name == origin {
+				break
+			}
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	if z.soa == nil {
+		return nil, fmt.Errorf("%s: zone %s has no SOA", file, origin)
+	}
+	return z, nil
+}
+
+// Len func return count of records
+func (z *Zone) Len() int {
+	return len(z.all)
+}
+
+// Answer func answer query authoritatively, name of query must be in zone
+func (z *Zone) Answer(query *dns.Msg) *dns.Msg {
+	q := query.Question[0]
+	resp := new(dns.Msg)
+	resp.SetReply(query)
+	resp.Authoritative = true
+	resp.RecursionAvailable = true
+
+	name := strings.ToLower(dns.Fqdn(q.Name))
+	for i := 0; i < maxCNAMEChain; i++ {
+		if ns := z.delegation(name); ns != nil {
+			// referral to child zone
+			resp.Authoritative = len(resp.Answer) > 0
+			resp.Ns = ns
+			z.addAdditional(resp, ns)
+			return resp
+		}
+
+		rrs, exists := z.lookup(name)
+		if !exists {
+			resp.Rcode = dns.RcodeNameError
+			resp.Ns = []dns.RR{z.negativeSOA()}
+			return resp
+		}
+
+		var cname *dns.CNAME
+		var answers []dns.RR
+		for _, rr := range rrs {
+			switch {
+			case q.Qtype == dns.TypeANY || rr.Header().Rrtype == q.Qtype:
+				answers = append(answers, rr)
+			case rr.Header().Rrtype == dns.TypeCNAME:
+				cname = rr.(*dns.CNAME)
+			}
+		}
+		if len(answers) > 0 {
+			resp.Answer = append(resp.Answer, answers...)
+			z.addAdditional(resp, answers)
+			return resp
+		}
+		if cname == nil {
+			// NODATA
+			resp.Ns = []dns.RR{z.negativeSOA()}
+			return resp
+		}
+
+		resp.Answer = append(resp.Answer, cname)
+		name = strings.ToLower(cname.Target)
+		if !dns.IsSubDomain(z.Origin, name) {
+			// target is resolved by client
+			return resp
+		}
+	}
+	return resp
+}
+
+// Transfer func return records for AXFR, it starts and ends with SOA
+func (z *Zone) Transfer() []dns.RR {
+	rrs := make([]dns.RR, 0, len(z.all)+1)
+	rrs = append(rrs, z.soa)
+	for _, rr := range z.all {
+		if rr != dns.RR(z.soa) {
+			rrs = append(rrs, rr)
+		}
+	}
+	return append(rrs, z.soa)
+}
+
+// AllowTransfer func return true if ip is allowed to transfer zone
+func (z *Zone) AllowTransfer(ip net.IP) bool {
+	return z.allowTransfer.Contains(ip)
+}
+
+// lookup func return records of name, records of wildcard are synthesized if name does not exist.
+// exists is true for empty non-terminals, which have no record
+func (z *Zone) lookup(name string) (rrs []dns.RR, exists bool) {
+	if rrs, ok := z.records[name]; ok {
+		return rrs, true
+	}
+	if _, ok := z.names[name]; ok {
+		return nil, true
+	}
+
+	// find closest encloser, only its wildcard may match
+	for p := parent(name); dns.IsSubDomain(z.Origin, p); p = parent(p) {
+		if _, ok := z.names[p]; !ok {
+			continue
+		}
+		wildcard, ok := z.records["*."+p]
+		if !ok {
+			return nil, false
+		}
+		for _, rr := range wildcard {
+			rr = dns.Copy(rr)
+			rr.Header().Name = name
+			rrs = append(rrs, rr)
+		}
+		return rrs, true
+	}
+	return nil, false
+}
+
+// delegation func return NS records of the top zone cut between apex and name
+func (z *Zone) delegation(name string) (ns []dns.RR) {
+	for p := name; p != z.Origin && dns.IsSubDomain(z.Origin, p); p = parent(p) {
+		var cut []dns.RR
+		for _, rr := range z.records[p] {
+			if rr.Header().Rrtype == dns.TypeNS {
+				cut = append(cut, rr)
+			}
+		}
+		if cut != nil {
+			ns = cut
+		}
+	}
+	return
+}
+
+// addAdditional func add in-zone addresses of NS, MX and SRV targets
+func (z *Zone) addAdditional(resp *dns.Msg, rrs []dns.RR) {
+	for _, rr := range rrs {
+		var target string
+		switch rr := rr.(type) {
+		case *dns.NS:
+			target = rr.Ns
+		case *dns.MX:
+			target = rr.Mx
+		case *dns.SRV:
+			target = rr.Target
+		default:
+			continue
+		}
+		for _, a := range z.records[strings.ToLower(target)] {
+			if t := a.Header().Rrtype; t == dns.TypeA || t == dns.TypeAAAA {
+				resp.Extra = append(resp.Extra, a)
+			}
+		}
+	}
+}
+
+// negativeSOA func return SOA for negative answer, its TTL is the minimum of SOA TTL and MINIMUM field
+func (z *Zone) negativeSOA() dns.RR {
+	soa := dns.Copy(z.soa).(*dns.SOA)
+	if soa.Minttl < soa.Hdr.Ttl {
+		soa.Hdr.Ttl = soa.Minttl
+	}
+	return soa
+}
+
+func parent(name string) string {
+	if i := strings.IndexByte(name, '.'); i >= 0 && i < len(name)-1 {
+		return name[i+1:]
+	}
+	return "."
+}
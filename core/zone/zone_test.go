@@ -0,0 +1,128 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package zone
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+const corpZone = `$TTL 3600
+@        IN SOA ns1 hostmaster 1 7200 900 1209600 300
+@        IN NS  ns1
+@        IN MX  10 mail
+ns1      IN A   10.0.0.1
+mail     IN A   10.0.0.2
+www      IN CNAME web.dev
+web.dev  IN A   10.0.0.3
+ext      IN CNAME www.example.com.
+*.apps   IN A   10.0.0.4
+_ldap._tcp IN SRV 0 0 389 ns1
+sub      IN NS  ns.sub
+ns.sub   IN A   10.0.1.1
+txt      IN TXT "hello"
+`
+
+func TestZone_Answer(t *testing.T) {
+	z, err := Parse(strings.NewReader(corpZone), "Corp.Internal", "corp.zone")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name      string
+		qtype     uint16
+		rcode     int
+		aa        bool
+		answer    int
+		ns        uint16
+		extra     int
+		lastRdata string
+	}{
+		{"corp.internal.", dns.TypeSOA, dns.RcodeSuccess, true, 1, 0, 0, ""},
+		{"corp.internal.", dns.TypeMX, dns.RcodeSuccess, true, 1, 0, 1, "mail.corp.internal."},
+		{"NS1.corp.internal.", dns.TypeA, dns.RcodeSuccess, true, 1, 0, 0, "10.0.0.1"},
+		{"ns1.corp.internal.", dns.TypeAAAA, dns.RcodeSuccess, true, 0, dns.TypeSOA, 0, ""},
+		{"www.corp.internal.", dns.TypeA, dns.RcodeSuccess, true, 2, 0, 0, "10.0.0.3"},
+		{"www.corp.internal.", dns.TypeCNAME, dns.RcodeSuccess, true, 1, 0, 0, "web.dev.corp.internal."},
+		{"ext.corp.internal.", dns.TypeA, dns.RcodeSuccess, true, 1, 0, 0, "www.example.com."},
+		{"dev.corp.internal.", dns.TypeA, dns.RcodeSuccess, true, 0, dns.TypeSOA, 0, ""},
+		{"x.apps.corp.internal.", dns.TypeA, dns.RcodeSuccess, true, 1, 0, 0, "10.0.0.4"},
+		{"apps.corp.internal.", dns.TypeA, dns.RcodeSuccess, true, 0, dns.TypeSOA, 0, ""},
+		{"nope.corp.internal.", dns.TypeA, dns.RcodeNameError, true, 0, dns.TypeSOA, 0, ""},
+		{"y.x.dev.corp.internal.", dns.TypeA, dns.RcodeNameError, true, 0, dns.TypeSOA, 0, ""},
+		{"_ldap._tcp.corp.internal.", dns.TypeSRV, dns.RcodeSuccess, true, 1, 0, 1, "ns1.corp.internal."},
+		{"host.sub.corp.internal.", dns.TypeA, dns.RcodeSuccess, false, 0, dns.TypeNS, 1, ""},
+		{"txt.corp.internal.", dns.TypeTXT, dns.RcodeSuccess, true, 1, 0, 0, "\"hello\""},
+	}
+	for _, c := range cases {
+		q := new(dns.Msg)
+		q.SetQuestion(c.name, c.qtype)
+		r := z.Answer(q)
+		desc := c.name + " " + dns.TypeToString[c.qtype]
+		if r.Rcode != c.rcode || r.Authoritative != c.aa || len(r.Answer) != c.answer || len(r.Extra) != c.extra {
+			t.Errorf("%s: got rcode %d aa %v answer %d extra %d\n%s", desc, r.Rcode, r.Authoritative, len(r.Answer), len(r.Extra), r)
+			continue
+		}
+		if c.ns != 0 && (len(r.Ns) == 0 || r.Ns[0].Header().Rrtype != c.ns) {
+			t.Errorf("%s: authority should be %s\n%s", desc, dns.TypeToString[c.ns], r)
+		}
+		if c.ns == dns.TypeSOA && r.Ns[0].Header().Ttl != 300 {
+			t.Errorf("%s: negative TTL = %d, want 300", desc, r.Ns[0].Header().Ttl)
+		}
+		if c.lastRdata != "" {
+			last := r.Answer[len(r.Answer)-1].String()
+			if !strings.HasSuffix(last, "\t"+c.lastRdata) && !strings.HasSuffix(last, " "+c.lastRdata) {
+				t.Errorf("%s: last answer %s, want %s", desc, last, c.lastRdata)
+			}
+		}
+		if c.name == "x.apps.corp.internal." && r.Answer[0].Header().Name != c.name {
+			t.Errorf("wildcard answer owner = %s", r.Answer[0].Header().Name)
+		}
+	}
+
+	rrs := z.Transfer()
+	if len(rrs) != z.Len()+1 || rrs[0].Header().Rrtype != dns.TypeSOA || rrs[len(rrs)-1].Header().Rrtype != dns.TypeSOA {
+		t.Errorf("Transfer returns %d records, zone has %d", len(rrs), z.Len())
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, s := range []string{
+		"www IN A 10.0.0.1\n",
+		"@ IN SOA ns1 hostmaster 1 7200 900 1209600 300\nwww.example.com. IN A 10.0.0.1\n",
+		"@ IN SOA ns1 hostmaster 1 7200 900 1209600 300\nwww IN A 10.0.0.300\n",
+	} {
+		if _, err := Parse(strings.NewReader(s), "corp.internal.", "bad.zone"); err == nil {
+			t.Errorf("Parse should fail:\n%s", s)
+		}
+	}
+}
+
+func TestZones_Find(t *testing.T) {
+	corp, _ := Parse(strings.NewReader(corpZone), "corp.internal.", "corp.zone")
+	sub, _ := Parse(strings.NewReader("@ IN SOA ns hm 1 2 3 4 5\n"), "sub.corp.internal.", "sub.zone")
+	zs := &Zones{zones: map[string]*Zone{corp.Origin: corp, sub.Origin: sub}}
+
+	for name, want := range map[string]*Zone{
+		"corp.internal":        corp,
+		"a.corp.internal.":     corp,
+		"a.SUB.corp.internal.": sub,
+		"internal.":            nil,
+		"example.com.":         nil,
+		".":                    nil,
+	} {
+		if got := zs.Find(name); got != want {
+			t.Errorf("Find(%s) = %v", name, got)
+		}
+	}
+	if (*Zones)(nil).Find("corp.internal.") != nil {
+		t.Error("nil Zones should find nothing")
+	}
+}
@@ -0,0 +1,82 @@
+// The MIT License (MIT)
+// Copyright (c) 2019 import-yuefeng
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated documentation files (the "Software"), to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package zone
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/import-yuefeng/smartDNS/core/matcher/iptrie"
+)
+
+// Config is one item of LocalZones in config file
+type Config struct {
+	Origin string
+	File   string
+	// AllowTransfer lists secondaries (IP, CIDR or range) allowed to AXFR the zone over TCP
+	AllowTransfer []string
+}
+
+// Zones is a set of zones, the zone with the longest origin is used for a name
+type Zones struct {
+	zones map[string]*Zone
+}
+
+// New func load all zones, any invalid zone is returned as error
+func New(configs []*Config) (*Zones, error) {
+	if len(configs) == 0 {
+		return nil, nil
+	}
+
+	zs := &Zones{zones: make(map[string]*Zone)}
+	for _, c := range configs {
+		z, err := Load(c.Origin, c.File)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load zone %s: %s", c.Origin, err)
+		}
+		if _, ok := zs.zones[z.Origin]; ok {
+			return nil, fmt.Errorf("Zone %s is defined more than once", z.Origin)
+		}
+		z.allowTransfer = iptrie.New()
+		for _, s := range c.AllowTransfer {
+			if err := z.allowTransfer.Insert(s); err != nil {
+				return nil, fmt.Errorf("Invalid AllowTransfer of zone %s: %s", c.Origin, err)
+			}
+		}
+		zs.zones[z.Origin] = z
+		log.Infof("Zone %s has been loaded with %d records", z.Origin, z.Len())
+	}
+	return zs, nil
+}
+
+// Find func return the zone that name belongs to, or nil
+func (zs *Zones) Find(name string) *Zone {
+	if zs == nil {
+		return nil
+	}
+	name = strings.ToLower(dns.Fqdn(name))
+	for {
+		if z, ok := zs.zones[name]; ok {
+			return z
+		}
+		if name == "." {
+			return nil
+		}
+		name = parent(name)
+	}
+}
+
+// Get func return the zone whose origin is name, or nil
+func (zs *Zones) Get(name string) *Zone {
+	if zs == nil {
+		return nil
+	}
+	return zs.zones[strings.ToLower(dns.Fqdn(name))]
+}
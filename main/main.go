@@ -10,6 +10,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -17,13 +18,20 @@ import (
 
 	// pprof test tools
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/import-yuefeng/smartDNS/core"
+	"github.com/import-yuefeng/smartDNS/core/config"
+	"github.com/import-yuefeng/smartDNS/core/importer"
 )
 
+const shutdownTimeout = 10 * time.Second
+
 var (
 	version = "0.0.3"
 
@@ -32,12 +40,21 @@ var (
 	isLogVerbose    = flag.Bool("v", false, "verbose mode")
 	processorNumber = flag.Int("p", runtime.NumCPU(), "number of processor to use")
 	isShowVersion   = flag.Bool("V", false, "current version of smartDNS")
-	query           = flag.String("q", "", "query DNS")
+	checkConfig     = flag.Bool("t", false, "check config file and exit, same as \"smartDNS check\"")
+	query           = flag.String("q", "", "explain how a name is resolved and exit, query type may follow (-q example.com AAAA)")
 	smart           = flag.Bool("s", false, "start smart-study feature")
 )
 
 func main() {
-	flag.Parse()
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(importConfig(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		*checkConfig = true
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
 	// parse command-line flag
 	if *isShowVersion {
 		fmt.Println(version)
@@ -70,5 +87,102 @@ func main() {
 
 	runtime.GOMAXPROCS(*processorNumber)
 
-	core.InitServer(*configPath, smart)
+	if *checkConfig {
+		problems := config.Check(*configPath)
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", *configPath, p)
+		}
+		if len(problems) > 0 {
+			fmt.Fprintf(os.Stderr, "%s: %d problems found\n", *configPath, len(problems))
+			os.Exit(1)
+		}
+		fmt.Printf("%s: ok\n", *configPath)
+		return
+	}
+
+	if *query != "" {
+		if err := core.Explain(os.Stdout, *configPath, *query, flag.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "smartDNS: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	s := core.NewServer(*configPath, *smart)
+	if err := s.Start(context.Background()); err != nil {
+		log.Fatalf("Failed to start smartDNS: %s", err)
+	}
+	s.HandleSignals()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-sig:
+		log.Info("Shutting down smartDNS")
+	case err := <-s.Errors():
+		log.Errorf("smartDNS stopped: %s", err)
+	}
+
+	// queries in flight have at most shutdownTimeout to finish
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		log.Errorf("Failed to shut down smartDNS gracefully: %s", err)
+	}
+}
+
+// importConfig func convert an overture or dnsmasq config, "smartDNS import overture|dnsmasq [-o dir] [-f] file"
+func importConfig(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	outDir := fs.String("o", ".", "output directory of config and rule files")
+	overwrite := fs.Bool("f", false, "replace existing files")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: smartDNS import overture|dnsmasq [-o dir] [-f] file")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		return 2
+	}
+	format := args[0]
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	var result *importer.Result
+	var err error
+	switch format {
+	case "overture":
+		result, err = importer.Overture(fs.Arg(0), *outDir)
+	case "dnsmasq":
+		result, err = importer.Dnsmasq(fs.Arg(0), *outDir)
+	default:
+		fs.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "smartDNS: %s\n", err)
+		return 1
+	}
+	for _, w := range result.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+	configFile, err := result.Write(*overwrite)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "smartDNS: %s\n", err)
+		return 1
+	}
+	fmt.Printf("%s: written with %d rule files\n", configFile, len(result.Files))
+
+	// the converted config is checked like "smartDNS check"
+	problems := config.Check(configFile)
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", configFile, p)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
 }